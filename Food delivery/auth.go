@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+const (
+	roleAdmin    = "admin"
+	roleStaff    = "staff" // сотрудник ресторана: ведет заказы, но не управляет пользователями
+	roleCustomer = "customer"
+)
+
+// Назначение токена, чтобы ссылку подтверждения нельзя было использовать для входа.
+const (
+	purposeAccess  = "access"
+	purposeConfirm = "confirm"
+	purposeStream  = "stream" // только ?stream_token= потоков событий, см. streamAuthMiddleware
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	confirmTokenTTL = 24 * time.Hour
+	// streamTokenTTL - токен в URL попадает в логи прокси, поэтому живет ровно
+	// столько, чтобы успеть открыть поток.
+	streamTokenTTL = time.Minute
+)
+
+var (
+	errMissingToken  = errors.New("missing bearer token")
+	errWrongPurpose  = errors.New("token cannot be used for authentication")
+	errSessionClosed = errors.New("session revoked or expired")
+)
+
+// issueToken подписывает claims активным ключом; ExpiresAt и IssuedAt заполняются здесь.
+func issueToken(claims *Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	key := jwtKeys.activeKey()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Secret)
+}
+
+// parseToken проверяет подпись и срок действия токена и возвращает его claims.
+func parseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := jwtKeys.lookup(kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.Secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", errMissingToken
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if token == "" {
+		return "", errMissingToken
+	}
+	return token, nil
+}
+
+// authenticate достает пользователя по Bearer-токену из запроса.
+func authenticate(r *http.Request) (*User, error) {
+	tokenString, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	return authenticateToken(tokenString, purposeAccess)
+}
+
+// authenticateToken проверяет токен с назначением purpose и его сессию.
+func authenticateToken(tokenString, purpose string) (*User, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, errWrongPurpose
+	}
+	if !sessionActive(claims.SessionID) {
+		return nil, errSessionClosed
+	}
+	var user User
+	if err := db.Where("email = ?", claims.Email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func claimsFromRequest(r *http.Request) (*Claims, error) {
+	tokenString, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseToken(tokenString)
+}
+
+// authMiddleware пропускает запрос дальше только с валидным JWT и кладет User в контекст.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticate(r)
+		if err != nil {
+			handleError(w, http.StatusUnauthorized, "Unauthorized", err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireRole должен стоять после authMiddleware.
+func requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := userFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			for _, role := range roles {
+				if user.Role == role {
+					if role == roleAdmin {
+						logAdminAction(user.Email, r.Method+" "+r.URL.Path)
+					}
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+func userFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userContextKey).(*User)
+	return user, ok && user != nil
+}
+
+// authenticated оборачивает обработчик в authMiddleware.
+func authenticated(h http.HandlerFunc) http.Handler {
+	return authMiddleware(h)
+}
+
+// optionalAuth пускает и гостей: без заголовка Authorization запрос идет без
+// пользователя в контексте. Неверный токен - 401, чтобы клиент обновил его, а не
+// остался гостем.
+func optionalAuth(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			h(w, r)
+			return
+		}
+		authMiddleware(h).ServeHTTP(w, r)
+	})
+}
+
+// adminOnly оборачивает обработчик в authMiddleware + requireRole("admin").
+func adminOnly(h http.HandlerFunc) http.Handler {
+	return authMiddleware(requireRole(roleAdmin)(h))
+}
+
+// staffOnly пускает сотрудников ресторана и админов.
+func staffOnly(h http.HandlerFunc) http.Handler {
+	return authMiddleware(requireRole(roleStaff, roleAdmin)(h))
+}
+
+// canAccessUser разрешает доступ к данным пользователя ему самому и админам.
+func canAccessUser(current *User, userID uint, email string) bool {
+	if current.Role == roleAdmin {
+		return true
+	}
+	if userID != 0 {
+		return current.ID == userID
+	}
+	return strings.EqualFold(current.Email, email)
+}
@@ -23,8 +23,10 @@ loginForm.addEventListener('submit', async (e) => {
     const password = document.getElementById('loginPassword').value.trim();
 
     try {
+        // cookie гостевой корзины уходит вместе с входом - сервер перенесет корзину.
         const response = await fetch(`${SERVER_URL}/login`, {
             method: 'POST',
+            credentials: 'include',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ email, password }),
         });
@@ -35,6 +37,7 @@ loginForm.addEventListener('submit', async (e) => {
         if (response.ok) {
             // **Сохраняем токен и пользователя**
             localStorage.setItem('authToken', data.token);
+            localStorage.setItem('refreshToken', data.refresh_token);
             localStorage.setItem('currentUser', JSON.stringify(data));
 
             alert('Login successful');
@@ -76,7 +79,7 @@ registerForm.addEventListener('submit', async (e) => {
         const response = await fetch(`${SERVER_URL}/register`, {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ name, email, phone, password }),
+            body: JSON.stringify({ name, email, phone, password, language: navigator.language }),
         });
 
         const textResponse = await response.text();
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserJSONOmitsPasswordHash(t *testing.T) {
+	data, err := json.Marshal(User{Email: "user@example.com", Password: "$2a$10$hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "password") || strings.Contains(string(data), "hash") {
+		t.Errorf("user JSON leaks the password hash: %s", data)
+	}
+}
+
+func TestRegisterUserIgnoresServerFields(t *testing.T) {
+	openTestDB(t)
+	useTestKeys(t)
+	body := `{"id": 999, "name": "Eve", "email": "eve@example.com", "password": "secret123",
+		"role": "admin", "email_confirmed": true, "restaurant_id": 1, "orders": [{"id": 5}]}`
+	w := httptest.NewRecorder()
+	registerUser(w, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var user User
+	if err := db.Preload("Orders").Where("email = ?", "eve@example.com").First(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	if user.ID == 999 || user.Role != roleCustomer || user.EmailConfirmed || user.RestaurantID != nil || len(user.Orders) != 0 {
+		t.Errorf("registration honored server fields: %+v", user)
+	}
+	if user.Password == "secret123" {
+		t.Error("password stored in plain text")
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	admin := &User{Email: "admin@example.com", Role: roleAdmin}
+	staff := &User{Email: "staff@example.com", Role: roleStaff}
+	customer := &User{Email: "customer@example.com", Role: roleCustomer}
+	adminRoute := []string{roleAdmin}
+	staffRoute := []string{roleStaff, roleAdmin}
+	tests := []struct {
+		name  string
+		user  *User
+		roles []string
+		want  int
+	}{
+		{"no user", nil, adminRoute, http.StatusUnauthorized},
+		{"admin on admin route", admin, adminRoute, http.StatusOK},
+		{"staff on admin route", staff, adminRoute, http.StatusForbidden},
+		{"customer on admin route", customer, adminRoute, http.StatusForbidden},
+		{"staff on staff route", staff, staffRoute, http.StatusOK},
+		{"admin on staff route", admin, staffRoute, http.StatusOK},
+		{"customer on staff route", customer, staffRoute, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := requireRole(tt.roles...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tt.user != nil {
+				r = withTestUser(r, tt.user)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectsInvalidTokens(t *testing.T) {
+	useTestKeys(t)
+	claims := func(purpose string) *Claims {
+		return &Claims{Email: "admin@example.com", Purpose: purpose, SessionID: 1}
+	}
+	expired, err := issueToken(claims(purposeAccess), -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirm, err := issueToken(claims(purposeConfirm), confirmTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Токен с тем же kid, но подписанный другим секретом.
+	foreign, err := loadKeyRing("", "some-other-secret-0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := jwtKeys
+	jwtKeys = foreign
+	forged, err := issueToken(claims(purposeAccess), accessTokenTTL)
+	jwtKeys = prev
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"no token, only the old email header", "X-User-Email", "admin@example.com"},
+		{"not a bearer token", "Authorization", "Basic YWRtaW46YWRtaW4="},
+		{"empty bearer token", "Authorization", "Bearer "},
+		{"garbage", "Authorization", "Bearer not-a-jwt"},
+		{"expired", "Authorization", "Bearer " + expired},
+		{"confirmation link token", "Authorization", "Bearer " + confirm},
+		{"foreign signature", "Authorization", "Bearer " + forged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			h := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			r.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusUnauthorized || called {
+				t.Errorf("status = %d, handler called = %v, want 401 without calling the handler", w.Code, called)
+			}
+		})
+	}
+}
+
+func TestAdminOnlyUsesRoleFromDatabase(t *testing.T) {
+	openTestDB(t)
+	useTestKeys(t)
+	h := adminOnly(func(w http.ResponseWriter, r *http.Request) {})
+	call := func(token string) int {
+		r := httptest.NewRequest(http.MethodGet, "/users", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	user := User{Name: "Mallory", Email: "mallory@example.com", Role: roleCustomer}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := startSession(&user, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := call(tokens.AccessToken); code != http.StatusForbidden {
+		t.Errorf("customer: status = %d, want 403", code)
+	}
+	// Роль читается из базы при каждом запросе, поэтому повышение действует без нового токена.
+	if err := db.Model(&user).Update("role", roleAdmin).Error; err != nil {
+		t.Fatal(err)
+	}
+	if code := call(tokens.AccessToken); code != http.StatusOK {
+		t.Errorf("admin: status = %d, want 200", code)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // часовые пояса правил работают и без tzdata в системе
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const maxAvailabilityRules = 20
+
+// Weekdays - дни недели правила доступности, бит на день (бит 0 - воскресенье,
+// как time.Weekday). 0 - каждый день. В JSON - список вида ["mon", "tue"].
+type Weekdays uint8
+
+var weekdayNames = [...]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func (d Weekdays) has(day time.Weekday) bool {
+	return d == 0 || d&(1<<uint(day)) != 0
+}
+
+func (d Weekdays) MarshalJSON() ([]byte, error) {
+	days := []string{}
+	for i, name := range weekdayNames {
+		if d&(1<<uint(i)) != 0 {
+			days = append(days, name)
+		}
+	}
+	return json.Marshal(days)
+}
+
+func (d *Weekdays) UnmarshalJSON(data []byte) error {
+	var days []string
+	if err := json.Unmarshal(data, &days); err != nil {
+		return err
+	}
+	*d = 0
+	for _, day := range days {
+		found := false
+		for i, name := range weekdayNames {
+			if strings.EqualFold(strings.TrimSpace(day), name) {
+				*d |= 1 << uint(i)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown day %q, use sun, mon, ..., sat", day)
+		}
+	}
+	return nil
+}
+
+// AvailabilityRule - окно, в которое блюдо (FoodItemID) или вся категория
+// (Category) ресторана RestaurantID продается, или часы работы ресторана
+// (RestaurantID без Category): дни недели и
+// время "08:00"–"11:30" в часовом поясе Timezone. Если End раньше Start, окно
+// заканчивается на следующий день; Start = End - весь день. Правила блюда заменяют
+// правила его категории, несколько правил складываются. Без правил блюдо доступно
+// всегда. Пока ресторан закрыт, его блюда недоступны независимо от своих правил.
+type AvailabilityRule struct {
+	ID           uint     `json:"id" gorm:"primaryKey"`
+	FoodItemID   *uint    `json:"food_item_id,omitempty" gorm:"index"`
+	Category     *string  `json:"category,omitempty" gorm:"index"`
+	RestaurantID *uint    `json:"restaurant_id,omitempty" gorm:"index"`
+	Days         Weekdays `json:"days" gorm:"not null;default:0"`
+	Start        string   `json:"start" gorm:"not null"`
+	End          string   `json:"end" gorm:"not null"`
+	Timezone     string   `json:"timezone" gorm:"not null"`
+}
+
+var errUnavailable = errors.New("menu item is not available now")
+
+// locations кэширует часовые пояса: time.LoadLocation каждый раз читает tzdata.
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// parseClock разбирает "HH:MM" в минуты от полуночи.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("time %q must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func validateAvailabilityRule(rule *AvailabilityRule) error {
+	if rule.Timezone == "" {
+		rule.Timezone = "UTC"
+	}
+	if _, err := loadLocation(rule.Timezone); err != nil || rule.Timezone == "Local" {
+		return fmt.Errorf("unknown timezone %q", rule.Timezone)
+	}
+	if _, err := parseClock(rule.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(rule.End); err != nil {
+		return err
+	}
+	return nil
+}
+
+// check сообщает, открыто ли окно правила в момент now, и когда оно откроется
+// в следующий раз, если закрыто.
+func (rule *AvailabilityRule) check(now time.Time) (bool, *time.Time) {
+	loc, err := loadLocation(rule.Timezone)
+	if err != nil {
+		return false, nil
+	}
+	start, err1 := parseClock(rule.Start)
+	end, err2 := parseClock(rule.End)
+	if err1 != nil || err2 != nil {
+		return false, nil
+	}
+	t := now.In(loc)
+	var next *time.Time
+	// С -1, чтобы учесть ночное окно, начавшееся вчера.
+	for offset := -1; offset <= 7; offset++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, loc)
+		if !rule.Days.has(day.Weekday()) {
+			continue
+		}
+		opens := time.Date(day.Year(), day.Month(), day.Day(), start/60, start%60, 0, 0, loc)
+		closes := time.Date(day.Year(), day.Month(), day.Day(), end/60, end%60, 0, 0, loc)
+		if !closes.After(opens) {
+			closes = closes.AddDate(0, 0, 1)
+		}
+		if !t.Before(opens) && t.Before(closes) {
+			return true, nil
+		}
+		if opens.After(t) && next == nil {
+			next = &opens
+		}
+	}
+	return false, next
+}
+
+// categoryKey - категория в меню одного ресторана.
+type categoryKey struct {
+	restaurantID uint
+	category     string
+}
+
+// menuSchedule - все правила доступности, сгруппированные по блюду, категории и ресторану.
+type menuSchedule struct {
+	byItem       map[uint][]AvailabilityRule
+	byCategory   map[categoryKey][]AvailabilityRule
+	byRestaurant map[uint][]AvailabilityRule
+}
+
+func loadMenuSchedule() (*menuSchedule, error) {
+	var rules []AvailabilityRule
+	if err := db.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return newMenuSchedule(rules), nil
+}
+
+func newMenuSchedule(rules []AvailabilityRule) *menuSchedule {
+	schedule := &menuSchedule{
+		byItem:       map[uint][]AvailabilityRule{},
+		byCategory:   map[categoryKey][]AvailabilityRule{},
+		byRestaurant: map[uint][]AvailabilityRule{},
+	}
+	for _, rule := range rules {
+		switch {
+		case rule.FoodItemID != nil:
+			schedule.byItem[*rule.FoodItemID] = append(schedule.byItem[*rule.FoodItemID], rule)
+		case rule.Category != nil && rule.RestaurantID != nil:
+			key := categoryKey{*rule.RestaurantID, *rule.Category}
+			schedule.byCategory[key] = append(schedule.byCategory[key], rule)
+		case rule.RestaurantID != nil:
+			schedule.byRestaurant[*rule.RestaurantID] = append(schedule.byRestaurant[*rule.RestaurantID], rule)
+		}
+	}
+	return schedule
+}
+
+// availability - доступно ли блюдо в момент now и с какого времени, если нет.
+func (s *menuSchedule) availability(item *FoodItem, now time.Time) (bool, *time.Time) {
+	if open, from := openWindow(s.byRestaurant[item.RestaurantID], now); !open {
+		return false, from
+	}
+	rules, ok := s.byItem[item.ID]
+	if !ok {
+		rules = s.byCategory[categoryKey{item.RestaurantID, item.Category}]
+	}
+	return openWindow(rules, now)
+}
+
+// openWindow - открыто ли хотя бы одно окно rules в момент now и когда откроется
+// ближайшее, если нет. Без правил - открыто всегда.
+func openWindow(rules []AvailabilityRule, now time.Time) (bool, *time.Time) {
+	if len(rules) == 0 {
+		return true, nil
+	}
+	var from *time.Time
+	for i := range rules {
+		open, next := rules[i].check(now)
+		if open {
+			return true, nil
+		}
+		if next != nil && (from == nil || next.Before(*from)) {
+			from = next
+		}
+	}
+	return false, from
+}
+
+// apply заполняет Available и AvailableFrom у загруженных блюд.
+func (s *menuSchedule) apply(items []FoodItem, now time.Time) {
+	for i := range items {
+		items[i].Available, items[i].AvailableFrom = s.availability(&items[i], now)
+	}
+}
+
+// unavailableIDs - блюда, которые сейчас не продаются по расписанию. Проверяются
+// только блюда, у которых, у чьей категории или у чьего ресторана есть правила.
+func (s *menuSchedule) unavailableIDs(now time.Time) ([]uint, error) {
+	if len(s.byItem) == 0 && len(s.byCategory) == 0 && len(s.byRestaurant) == 0 {
+		return nil, nil
+	}
+	itemIDs := make([]uint, 0, len(s.byItem))
+	for id := range s.byItem {
+		itemIDs = append(itemIDs, id)
+	}
+	// Выборка по имени категории шире нужной, лишнее отсекает availability.
+	categories := make([]string, 0, len(s.byCategory))
+	for key := range s.byCategory {
+		categories = append(categories, key.category)
+	}
+	restaurantIDs := make([]uint, 0, len(s.byRestaurant))
+	for id := range s.byRestaurant {
+		restaurantIDs = append(restaurantIDs, id)
+	}
+	var items []FoodItem
+	err := db.Select("id", "category", "restaurant_id").
+		Where("id IN ? OR category IN ? OR restaurant_id IN ?", append(itemIDs, 0), append(categories, ""), append(restaurantIDs, 0)).
+		Find(&items).Error
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for i := range items {
+		if open, _ := s.availability(&items[i], now); !open {
+			ids = append(ids, items[i].ID)
+		}
+	}
+	return ids, nil
+}
+
+// applySchedule - общая часть списков меню. Без ?show_unavailable=true блюда вне
+// своего расписания не попадают в выборку; с ним возвращаются с available = false
+// и available_from. Вызывать до Count и пагинации.
+func applySchedule(q *gorm.DB, r *http.Request, schedule *menuSchedule, now time.Time) (*gorm.DB, error) {
+	if show, _ := strconv.ParseBool(r.URL.Query().Get("show_unavailable")); show {
+		return q, nil
+	}
+	ids, err := schedule.unavailableIDs(now)
+	if err != nil || len(ids) == 0 {
+		return q, err
+	}
+	return q.Where("food_items.id NOT IN ?", ids), nil
+}
+
+// checkAvailable возвращает errUnavailable, если блюда items сейчас не продаются.
+func checkAvailable(items []FoodItem, now time.Time) error {
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		open, from := schedule.availability(&items[i], now)
+		if open {
+			continue
+		}
+		if from != nil {
+			return fmt.Errorf("%w: %s is available from %s", errUnavailable, items[i].Name, from.Format("Mon 15:04 MST"))
+		}
+		return fmt.Errorf("%w: %s", errUnavailable, items[i].Name)
+	}
+	return nil
+}
+
+// ruleOwner - чьи правила: блюдо, категория ресторана (category и restaurantID)
+// или сам ресторан (только restaurantID).
+type ruleOwner struct {
+	itemID       *uint
+	category     *string
+	restaurantID *uint
+}
+
+// availabilityScope - владелец правил из URL: блюдо /menu/{id}, категория
+// /restaurants/{rid}/categories/{category} или ресторан /restaurants/{rid}/hours.
+// /categories/{category} без ?restaurant_id= - категория ресторана по умолчанию.
+func availabilityScope(r *http.Request) (ruleOwner, error) {
+	vars := mux.Vars(r)
+	if category, ok := vars["category"]; ok {
+		id, err := restaurantFromRequest(r)
+		if err == nil && id == 0 {
+			id, err = defaultRestaurantID(db)
+		}
+		return ruleOwner{category: &category, restaurantID: &id}, err
+	}
+	if _, ok := vars["rid"]; ok {
+		id, err := restaurantFromRequest(r)
+		return ruleOwner{restaurantID: &id}, err
+	}
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return ruleOwner{}, err
+	}
+	itemID := uint(id)
+	return ruleOwner{itemID: &itemID}, nil
+}
+
+func scopeRules(q *gorm.DB, owner ruleOwner) *gorm.DB {
+	switch {
+	case owner.itemID != nil:
+		return q.Where("food_item_id = ?", *owner.itemID)
+	case owner.category != nil:
+		return q.Where("restaurant_id = ? AND category = ?", *owner.restaurantID, *owner.category)
+	}
+	return q.Where("restaurant_id = ? AND category IS NULL", *owner.restaurantID)
+}
+
+// getAvailability - GET /menu/{id}/availability, /categories/{category}/availability,
+// /restaurants/{rid}/categories/{category}/availability и /restaurants/{rid}/hours.
+func getAvailability(w http.ResponseWriter, r *http.Request) {
+	owner, err := availabilityScope(r)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	rules := []AvailabilityRule{}
+	if err := scopeRules(db, owner).Order("id").Find(&rules).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch availability", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// putAvailability - PUT /menu/{id}/availability, /categories/{category}/availability,
+// /restaurants/{rid}/categories/{category}/availability и /restaurants/{rid}/hours:
+// заменяет все правила блюда, категории или ресторана;
+// пустой список - доступно (открыт) всегда.
+func putAvailability(w http.ResponseWriter, r *http.Request) {
+	owner, err := availabilityScope(r)
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var rules []AvailabilityRule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid input", err)
+		return
+	}
+	if len(rules) > maxAvailabilityRules {
+		http.Error(w, fmt.Sprintf("At most %d rules are allowed", maxAvailabilityRules), http.StatusBadRequest)
+		return
+	}
+	for i := range rules {
+		if err := validateAvailabilityRule(&rules[i]); err != nil {
+			handleError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		rules[i].ID, rules[i].FoodItemID, rules[i].Category, rules[i].RestaurantID = 0, owner.itemID, owner.category, owner.restaurantID
+	}
+	if owner.itemID != nil {
+		var exists int64
+		if err := db.Model(&FoodItem{}).Where("id = ?", *owner.itemID).Count(&exists).Error; err != nil || exists == 0 {
+			http.Error(w, "Menu item not found", http.StatusNotFound)
+			return
+		}
+	}
+	if owner.restaurantID != nil {
+		if ok, err := restaurantExists(*owner.restaurantID); err != nil || !ok {
+			http.Error(w, "Restaurant not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := scopeRules(tx, owner).Delete(&AvailabilityRule{}).Error; err != nil {
+			return err
+		}
+		if len(rules) > 0 {
+			if err := tx.Create(&rules).Error; err != nil {
+				return err
+			}
+		}
+		if owner.itemID != nil {
+			return bumpFoodItemVersion(tx, *owner.itemID)
+		}
+		return nil
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to update availability", err)
+		return
+	}
+	if rules == nil {
+		rules = []AvailabilityRule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
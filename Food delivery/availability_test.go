@@ -0,0 +1,187 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAvailabilityRuleCheck(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	const (
+		fri Weekdays = 1 << time.Friday
+		sat Weekdays = 1 << time.Saturday
+	)
+	tests := []struct {
+		name     string
+		rule     AvailabilityRule
+		now      string
+		wantOpen bool
+		wantNext string // пусто - next не ожидается
+	}{
+		{
+			name:     "inside daily window",
+			rule:     AvailabilityRule{Start: "08:00", End: "11:30", Timezone: "UTC"},
+			now:      "2026-03-04T09:00:00Z",
+			wantOpen: true,
+		},
+		{
+			name:     "end is exclusive",
+			rule:     AvailabilityRule{Start: "08:00", End: "11:30", Timezone: "UTC"},
+			now:      "2026-03-04T11:30:00Z",
+			wantNext: "2026-03-05T08:00:00Z",
+		},
+		{
+			name:     "before window opens today",
+			rule:     AvailabilityRule{Start: "08:00", End: "11:30", Timezone: "UTC"},
+			now:      "2026-03-04T07:59:00Z",
+			wantNext: "2026-03-04T08:00:00Z",
+		},
+		{
+			name:     "start equals end is all day",
+			rule:     AvailabilityRule{Start: "00:00", End: "00:00", Timezone: "UTC"},
+			now:      "2026-03-04T23:59:00Z",
+			wantOpen: true,
+		},
+		{
+			name:     "overnight window after midnight belongs to previous day",
+			rule:     AvailabilityRule{Days: fri, Start: "22:00", End: "02:00", Timezone: "UTC"},
+			now:      "2026-03-07T01:00:00Z", // суббота, окно пятницы
+			wantOpen: true,
+		},
+		{
+			name:     "overnight window of a day not in the rule",
+			rule:     AvailabilityRule{Days: fri, Start: "22:00", End: "02:00", Timezone: "UTC"},
+			now:      "2026-03-06T01:00:00Z", // пятница, окно четверга
+			wantNext: "2026-03-06T22:00:00Z",
+		},
+		{
+			name:     "weekday rule waits for next matching day",
+			rule:     AvailabilityRule{Days: sat, Start: "10:00", End: "12:00", Timezone: "UTC"},
+			now:      "2026-03-08T11:00:00Z", // воскресенье
+			wantNext: "2026-03-14T10:00:00Z",
+		},
+		{
+			name:     "local time after spring forward",
+			rule:     AvailabilityRule{Start: "08:00", End: "11:00", Timezone: "America/New_York"},
+			now:      "2026-03-08T12:30:00Z", // 08:30 EDT, до перехода было бы 07:30
+			wantOpen: true,
+		},
+		{
+			name:     "local time before spring forward",
+			rule:     AvailabilityRule{Start: "08:00", End: "11:00", Timezone: "America/New_York"},
+			now:      "2026-03-07T12:30:00Z", // 07:30 EST
+			wantNext: "2026-03-07T13:00:00Z",
+		},
+		{
+			name:     "overnight window across spring forward is still open",
+			rule:     AvailabilityRule{Start: "22:00", End: "06:00", Timezone: "America/New_York"},
+			now:      "2026-03-08T09:30:00Z", // 05:30 EDT, окно открылось в 22:00 EST
+			wantOpen: true,
+		},
+		{
+			name:     "overnight window across spring forward closes at local 06:00",
+			rule:     AvailabilityRule{Start: "22:00", End: "06:00", Timezone: "America/New_York"},
+			now:      "2026-03-08T10:30:00Z", // 06:30 EDT
+			wantNext: "2026-03-09T02:00:00Z", // 22:00 EDT
+		},
+		{
+			name:     "local time after fall back",
+			rule:     AvailabilityRule{Start: "08:00", End: "11:00", Timezone: "America/New_York"},
+			now:      "2026-11-01T12:30:00Z", // 07:30 EST
+			wantNext: "2026-11-01T13:00:00Z",
+		},
+		{
+			name: "unknown timezone is closed",
+			rule: AvailabilityRule{Start: "08:00", End: "11:00", Timezone: "Mars/Olympus"},
+			now:  "2026-03-04T09:00:00Z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open, next := tt.rule.check(utc(tt.now))
+			if open != tt.wantOpen {
+				t.Fatalf("open = %v, want %v", open, tt.wantOpen)
+			}
+			switch {
+			case tt.wantNext == "" && next != nil:
+				t.Errorf("next = %v, want nil", next)
+			case tt.wantNext != "" && next == nil:
+				t.Errorf("next = nil, want %s", tt.wantNext)
+			case tt.wantNext != "" && !next.Equal(utc(tt.wantNext)):
+				t.Errorf("next = %s, want %s", next.UTC().Format(time.RFC3339), tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestValidateAvailabilityRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    AvailabilityRule
+		wantErr bool
+	}{
+		{"defaults to UTC", AvailabilityRule{Start: "08:00", End: "11:00"}, false},
+		{"named zone", AvailabilityRule{Start: "22:00", End: "02:00", Timezone: "Europe/Moscow"}, false},
+		{"Local is rejected", AvailabilityRule{Start: "08:00", End: "11:00", Timezone: "Local"}, true},
+		{"unknown zone", AvailabilityRule{Start: "08:00", End: "11:00", Timezone: "Nowhere/City"}, true},
+		{"bad clock", AvailabilityRule{Start: "8am", End: "11:00"}, true},
+		{"hour out of range", AvailabilityRule{Start: "08:00", End: "24:00"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			if err := validateAvailabilityRule(&rule); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOpenWindowPicksEarliestNext(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	rules := []AvailabilityRule{
+		{Start: "18:00", End: "22:00", Timezone: "UTC"},
+		{Start: "14:00", End: "15:00", Timezone: "UTC"},
+	}
+	open, next := openWindow(rules, now)
+	if open || next == nil || !next.Equal(time.Date(2026, 3, 4, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("openWindow = %v, %v; want closed until 14:00", open, next)
+	}
+	if open, _ := openWindow(nil, now); !open {
+		t.Error("no rules must mean always open")
+	}
+}
+
+func TestMenuScheduleScopesCategoriesByRestaurant(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	first, second, item := uint(1), uint(2), uint(30)
+	breakfast := "Breakfast"
+	schedule := newMenuSchedule([]AvailabilityRule{
+		{Category: &breakfast, RestaurantID: &first, Start: "07:00", End: "11:00", Timezone: "UTC"},
+		{RestaurantID: &second, Start: "10:00", End: "22:00", Timezone: "UTC"},
+		{FoodItemID: &item, Start: "11:00", End: "15:00", Timezone: "UTC"},
+	})
+	tests := []struct {
+		name string
+		item FoodItem
+		want bool
+	}{
+		{"category rule applies in its restaurant", FoodItem{ID: 10, RestaurantID: first, Category: breakfast}, false},
+		{"same category elsewhere is not affected", FoodItem{ID: 20, RestaurantID: second, Category: breakfast}, true},
+		{"item rule replaces the category rule", FoodItem{ID: item, RestaurantID: first, Category: breakfast}, true},
+		{"other categories are always available", FoodItem{ID: 40, RestaurantID: first, Category: "Soups"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := schedule.availability(&tt.item, now); got != tt.want {
+				t.Errorf("available = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
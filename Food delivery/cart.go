@@ -0,0 +1,565 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Cart - корзина на сервере. У пользователя одна корзина; корзина гостя
+// (UserID = nil) находится по хэшу токена из cookie и живет до ExpiresAt.
+type Cart struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    *uint      `json:"user_id" gorm:"uniqueIndex"`
+	TokenHash *string    `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" gorm:"index"`
+	Items     []CartItem `json:"items" gorm:"foreignKey:CartID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// CartItem - позиция корзины. UnitPrice - цена, которую покупатель видел последней;
+// если блюдо подорожало или подешевело, refreshCart обновит ее и отметит PriceChanged.
+// FoodItemID обнуляется при удалении блюда из меню, такие позиции удаляются.
+// VariantID - выбранный размер (0 - у блюда нет вариантов), OptionsKey - выбранные
+// опции (см. optionsKey); UnitPrice включает цену размера и доплаты за опции.
+type CartItem struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CartID     uint      `json:"-" gorm:"not null;uniqueIndex:idx_cart_items_cart_food"`
+	FoodItemID *uint     `json:"food_item_id" gorm:"uniqueIndex:idx_cart_items_cart_food"`
+	VariantID  uint      `json:"variant_id,omitempty" gorm:"not null;default:0;uniqueIndex:idx_cart_items_cart_food"`
+	OptionsKey string    `json:"-" gorm:"not null;default:'';uniqueIndex:idx_cart_items_cart_food"`
+	Name       string    `json:"name" gorm:"not null"`
+	UnitPrice  Money     `json:"unit_price" gorm:"not null"`
+	Quantity   int       `json:"quantity" gorm:"not null"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	Options      []OrderLineOption `json:"options,omitempty" gorm:"-"`
+	LineTotal    Money             `json:"line_total" gorm:"-"`
+	PriceChanged bool              `json:"price_changed,omitempty" gorm:"-"`
+	SoldOut      bool              `json:"sold_out,omitempty" gorm:"-"`
+	// Unavailable - блюдо сейчас не продается по расписанию, см. AvailableFrom.
+	Unavailable   bool       `json:"unavailable,omitempty" gorm:"-"`
+	AvailableFrom *time.Time `json:"available_from,omitempty" gorm:"-"`
+	PreviousPrice *Money     `json:"previous_price,omitempty" gorm:"-"`
+	RestaurantID  uint       `json:"restaurant_id" gorm:"-"`
+}
+
+// CartView - ответ API корзины: позиции с актуальными ценами и расчет сервера.
+// Removed - названия позиций, убранных из корзины, потому что их больше нет в меню.
+// Позиции разных ресторанов оформляются отдельными заказами: Restaurants - расчет
+// каждого заказа (со своей доставкой), Pricing - их сумма.
+type CartView struct {
+	Items       []CartItem       `json:"items"`
+	Removed     []string         `json:"removed,omitempty"`
+	Restaurants []CartRestaurant `json:"restaurants,omitempty"`
+	Pricing     PriceBreakdown   `json:"pricing"`
+}
+
+// CartRestaurant - часть корзины, которая станет заказом одного ресторана.
+type CartRestaurant struct {
+	RestaurantID uint           `json:"restaurant_id"`
+	Name         string         `json:"name"`
+	Pricing      PriceBreakdown `json:"pricing"`
+}
+
+var (
+	errCartItemNotFound = errors.New("cart item not found")
+	errCartQuantity     = fmt.Errorf("quantity must be between 1 and %d", maxLineQuantity)
+	errCartEmpty        = errors.New("cart is empty")
+)
+
+// cartForUser возвращает корзину пользователя, создавая ее при первом обращении.
+func cartForUser(userID uint) (*Cart, error) {
+	cart := Cart{UserID: &userID}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&cart).Error; err != nil {
+		return nil, err
+	}
+	if cart.ID != 0 {
+		return &cart, nil
+	}
+	if err := db.Where("user_id = ?", userID).First(&cart).Error; err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
+
+// refreshCart сверяет корзину с меню: удаляет позиции, которых больше нет в меню
+// или чьи размер и опции больше нельзя выбрать, обновляет изменившиеся цены и считает итог
+// по правилам priceOrder отдельно для каждого ресторана.
+func refreshCart(cart *Cart, promoCode string) (*CartView, error) {
+	var items []CartItem
+	if err := db.Where("cart_id = ?", cart.ID).Order("id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for _, item := range items {
+		if item.FoodItemID != nil {
+			ids = append(ids, *item.FoodItemID)
+		}
+	}
+	menu := map[uint]FoodItem{}
+	if len(ids) > 0 {
+		var foods []FoodItem
+		if err := withMenuDetails(db).Where("id IN ?", ids).Find(&foods).Error; err != nil {
+			return nil, err
+		}
+		for _, food := range foods {
+			menu[food.ID] = food
+		}
+	}
+
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	view := &CartView{Items: []CartItem{}}
+	var lines []OrderLine
+	for _, item := range items {
+		var food FoodItem
+		var ok bool
+		if item.FoodItemID != nil {
+			food, ok = menu[*item.FoodItemID]
+		}
+		var variant *ItemVariant
+		var base, extra Money
+		if ok && food.Currency == cfg.Pricing.Currency {
+			var err error
+			variant, base, err = resolveVariant(&food, item.VariantID)
+			if err == nil {
+				item.Options, extra, err = resolveOptions(&food, parseOptionsKey(item.OptionsKey))
+			}
+			ok = err == nil
+		}
+		if !ok || food.Currency != cfg.Pricing.Currency {
+			if err := db.Delete(&CartItem{}, item.ID).Error; err != nil {
+				return nil, err
+			}
+			view.Removed = append(view.Removed, item.Name)
+			continue
+		}
+		price, name := base+extra, lineName(&food, variant)
+		if price != item.UnitPrice || name != item.Name {
+			if price != item.UnitPrice {
+				previous := item.UnitPrice
+				item.PriceChanged, item.PreviousPrice = true, &previous
+			}
+			err := db.Model(&CartItem{}).Where("id = ?", item.ID).
+				Updates(map[string]interface{}{"unit_price": price, "name": name}).Error
+			if err != nil {
+				return nil, err
+			}
+			item.UnitPrice, item.Name = price, name
+		}
+		item.SoldOut, item.RestaurantID = food.SoldOut, food.RestaurantID
+		if open, from := schedule.availability(&food, now); !open {
+			item.Unavailable, item.AvailableFrom = true, from
+		}
+		item.LineTotal = item.UnitPrice.times(item.Quantity)
+		view.Items = append(view.Items, item)
+		lines = append(lines, OrderLine{UnitPrice: item.UnitPrice, Quantity: item.Quantity, LineTotal: item.LineTotal, RestaurantID: food.RestaurantID})
+	}
+
+	view.Pricing = PriceBreakdown{Currency: cfg.Pricing.Currency}
+	if len(lines) == 0 {
+		return view, nil
+	}
+	groups := splitByRestaurant(lines)
+	restaurantIDs := make([]uint, 0, len(groups))
+	for _, group := range groups {
+		restaurantIDs = append(restaurantIDs, group[0].RestaurantID)
+	}
+	names, err := restaurantNames(restaurantIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		pricing, err := priceOrder(cfg.Pricing, group, promoCode)
+		if err != nil {
+			return nil, err
+		}
+		id := group[0].RestaurantID
+		view.Restaurants = append(view.Restaurants, CartRestaurant{RestaurantID: id, Name: names[id], Pricing: pricing})
+		view.Pricing = view.Pricing.add(pricing)
+	}
+	return view, nil
+}
+
+// hasChanges - корзина изменилась при сверке с меню или в ней есть закончившиеся
+// или недоступные сейчас блюда, покупатель должен ее проверить.
+func (v *CartView) hasChanges() bool {
+	if len(v.Removed) > 0 {
+		return true
+	}
+	for _, item := range v.Items {
+		if item.PriceChanged || item.SoldOut || item.Unavailable {
+			return true
+		}
+	}
+	return false
+}
+
+// addToCart добавляет quantity штук блюда размера variantID с опциями optionIDs,
+// складывая с уже лежащими в корзине с тем же размером и выбором опций. tx -
+// db или транзакция вызывающего, если добавление - часть большего изменения.
+func addToCart(tx *gorm.DB, cart *Cart, foodItemID, variantID uint, optionIDs []uint, quantity int) error {
+	if quantity <= 0 || quantity > maxLineQuantity {
+		return errCartQuantity
+	}
+	var food FoodItem
+	if err := withMenuDetails(tx).First(&food, foodItemID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %d", errFoodItemNotFound, foodItemID)
+		}
+		return err
+	}
+	if food.Currency != cfg.Pricing.Currency {
+		return fmt.Errorf("%w: item %d is priced in %s", errCurrencyMismatch, food.ID, food.Currency)
+	}
+	if food.SoldOut {
+		return fmt.Errorf("%w: %s", errSoldOut, food.Name)
+	}
+	if err := checkAvailable([]FoodItem{food}, time.Now()); err != nil {
+		return err
+	}
+	variant, price, err := resolveVariant(&food, variantID)
+	if err != nil {
+		return err
+	}
+	_, extra, err := resolveOptions(&food, optionIDs)
+	if err != nil {
+		return err
+	}
+	key := optionsKey(optionIDs)
+
+	return tx.Transaction(func(tx *gorm.DB) error {
+		item := CartItem{CartID: cart.ID, FoodItemID: &food.ID, VariantID: variantID, OptionsKey: key,
+			Name: lineName(&food, variant), UnitPrice: price + extra, Quantity: quantity}
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "cart_id"}, {Name: "food_item_id"}, {Name: "variant_id"}, {Name: "options_key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"quantity":   gorm.Expr("cart_items.quantity + excluded.quantity"),
+				"unit_price": gorm.Expr("excluded.unit_price"),
+				"name":       gorm.Expr("excluded.name"),
+				"updated_at": gorm.Expr("excluded.updated_at"),
+			}),
+		}).Create(&item).Error
+		if err != nil {
+			return err
+		}
+		var total int
+		if err := tx.Model(&CartItem{}).Where("cart_id = ? AND food_item_id = ? AND variant_id = ? AND options_key = ?", cart.ID, food.ID, variantID, key).
+			Select("quantity").Scan(&total).Error; err != nil {
+			return err
+		}
+		if total > maxLineQuantity {
+			return errCartQuantity
+		}
+		return nil
+	})
+}
+
+// setCartItemQuantity меняет количество позиции; 0 удаляет ее.
+func setCartItemQuantity(cart *Cart, itemID uint, quantity int) error {
+	if quantity < 0 || quantity > maxLineQuantity {
+		return errCartQuantity
+	}
+	var res *gorm.DB
+	if quantity == 0 {
+		res = db.Where("id = ? AND cart_id = ?", itemID, cart.ID).Delete(&CartItem{})
+	} else {
+		res = db.Model(&CartItem{}).Where("id = ? AND cart_id = ?", itemID, cart.ID).Update("quantity", quantity)
+	}
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errCartItemNotFound
+	}
+	return nil
+}
+
+func writeCartError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errCartItemNotFound), errors.Is(err, errFoodItemNotFound):
+		handleError(w, http.StatusNotFound, err.Error(), err)
+	case errors.Is(err, errCartQuantity), errors.Is(err, errCurrencyMismatch), errors.Is(err, errCartEmpty),
+		errors.Is(err, errInvalidOptions), errors.Is(err, errInvalidVariant):
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+	case errors.Is(err, errSoldOut), errors.Is(err, errUnavailable):
+		handleError(w, http.StatusConflict, err.Error(), err)
+	case errors.Is(err, errUnknownPromoCode):
+		handleError(w, http.StatusBadRequest, "Invalid promo code", err)
+	default:
+		handleError(w, http.StatusInternalServerError, "Failed to update cart", err)
+	}
+}
+
+// currentCart загружает корзину текущего пользователя или гостя (по cookie) и пишет
+// ответ об ошибке сам. create = false - гостю без корзины вернется nil, а не новая
+// корзина, чтобы простой просмотр не плодил пустые корзины.
+func currentCart(w http.ResponseWriter, r *http.Request, create bool) (*Cart, bool) {
+	var cart *Cart
+	var err error
+	if user, ok := userFromContext(r.Context()); ok {
+		cart, err = cartForUser(user.ID)
+	} else {
+		cart, err = guestCart(w, r, create)
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to load cart", err)
+		return nil, false
+	}
+	return cart, true
+}
+
+// writeCart отвечает текущим состоянием корзины; nil - пустая корзина гостя.
+func writeCart(w http.ResponseWriter, cart *Cart, promoCode string) {
+	view := &CartView{Items: []CartItem{}, Pricing: PriceBreakdown{Currency: cfg.Pricing.Currency}}
+	if cart != nil {
+		var err error
+		if view, err = refreshCart(cart, promoCode); err != nil {
+			writeCartError(w, err)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// getCart - GET /cart[?promo_code=]: корзина с ценами из меню и расчетом итога.
+func getCart(w http.ResponseWriter, r *http.Request) {
+	cart, ok := currentCart(w, r, false)
+	if !ok {
+		return
+	}
+	writeCart(w, cart, r.URL.Query().Get("promo_code"))
+}
+
+// addCartItem - POST /cart/items {"food_item_id", "variant_id", "options", "quantity"}; quantity
+// по умолчанию 1.
+func addCartItem(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		FoodItemID uint   `json:"food_item_id"`
+		VariantID  uint   `json:"variant_id"`
+		Options    []uint `json:"options"`
+		Quantity   *int   `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	quantity := 1
+	if input.Quantity != nil {
+		quantity = *input.Quantity
+	}
+	cart, ok := currentCart(w, r, true)
+	if !ok {
+		return
+	}
+	if err := addToCart(db, cart, input.FoodItemID, input.VariantID, input.Options, quantity); err != nil {
+		writeCartError(w, err)
+		return
+	}
+	writeCart(w, cart, "")
+}
+
+// updateCartItem - PATCH /cart/items/{id} {"quantity"}; 0 удаляет позицию.
+func updateCartItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid cart item ID", http.StatusBadRequest)
+		return
+	}
+	var input struct {
+		Quantity *int `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Quantity == nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	cart, ok := currentCart(w, r, false)
+	if !ok {
+		return
+	}
+	if cart == nil {
+		writeCartError(w, errCartItemNotFound)
+		return
+	}
+	if err := setCartItemQuantity(cart, uint(id), *input.Quantity); err != nil {
+		writeCartError(w, err)
+		return
+	}
+	writeCart(w, cart, "")
+}
+
+// removeCartItem - DELETE /cart/items/{id}.
+func removeCartItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid cart item ID", http.StatusBadRequest)
+		return
+	}
+	cart, ok := currentCart(w, r, false)
+	if !ok {
+		return
+	}
+	if cart == nil {
+		writeCartError(w, errCartItemNotFound)
+		return
+	}
+	if err := setCartItemQuantity(cart, uint(id), 0); err != nil {
+		writeCartError(w, err)
+		return
+	}
+	writeCart(w, cart, "")
+}
+
+// clearCart - DELETE /cart.
+func clearCart(w http.ResponseWriter, r *http.Request) {
+	cart, ok := currentCart(w, r, false)
+	if !ok {
+		return
+	}
+	if cart != nil {
+		if err := db.Where("cart_id = ?", cart.ID).Delete(&CartItem{}).Error; err != nil {
+			writeCartError(w, err)
+			return
+		}
+	}
+	writeCart(w, cart, "")
+}
+
+// writeCartConflict - 409 с актуальной корзиной, чтобы покупатель увидел изменения.
+func writeCartConflict(w http.ResponseWriter, view *CartView) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": "Cart changed since it was last viewed",
+		"cart":  view,
+	})
+}
+
+// checkoutCart - POST /cart/checkout: оформляет корзину и очищает ее. Позиции
+// разных ресторанов становятся отдельными заказами (каждый со своей доставкой),
+// которые создаются вместе или не создаются вовсе; ответ - {"orders", "pricing"}.
+// Если при сверке с меню корзина изменилась, заказы не создаются: 409 с новой
+// корзиной, чтобы покупатель увидел новые цены.
+func checkoutCart(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Customer  string   `json:"customer"`
+		Address   string   `json:"address"`
+		PromoCode string   `json:"promo_code"`
+		Total     *Money   `json:"total"`    // если передан, должен совпасть с суммой заказов
+		Latitude  *float64 `json:"latitude"` // точка доставки для проверки радиуса ресторанов
+		Longitude *float64 `json:"longitude"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	user, _ := userFromContext(r.Context())
+	cart, ok := currentCart(w, r, true)
+	if !ok {
+		return
+	}
+	view, err := refreshCart(cart, input.PromoCode)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if len(view.Items) == 0 {
+		writeCartError(w, errCartEmpty)
+		return
+	}
+	if view.hasChanges() {
+		writeCartConflict(w, view)
+		return
+	}
+
+	inputs := make([]orderLineInput, 0, len(view.Items))
+	itemIDs := make([]uint, 0, len(view.Items))
+	for _, item := range view.Items {
+		inputs = append(inputs, orderLineInput{FoodItemID: *item.FoodItemID, VariantID: item.VariantID, Options: parseOptionsKey(item.OptionsKey), Quantity: item.Quantity})
+		itemIDs = append(itemIDs, item.ID)
+	}
+	lines, _, err := buildOrderLines(inputs)
+	if err != nil {
+		writeOrderLinesError(w, err)
+		return
+	}
+	groups := splitByRestaurant(lines)
+	restaurantIDs := make([]uint, 0, len(groups))
+	for _, group := range groups {
+		restaurantIDs = append(restaurantIDs, group[0].RestaurantID)
+	}
+	if err := checkDeliveryArea(restaurantIDs, input.Latitude, input.Longitude); err != nil {
+		writeOrderLinesError(w, err)
+		return
+	}
+
+	now := time.Now()
+	orders := make([]*Order, 0, len(groups))
+	total := PriceBreakdown{Currency: cfg.Pricing.Currency}
+	for _, group := range groups {
+		breakdown, err := priceOrder(cfg.Pricing, group, input.PromoCode)
+		if err != nil {
+			handleError(w, http.StatusBadRequest, "Invalid promo code", err)
+			return
+		}
+		order := &Order{
+			Customer:        input.Customer,
+			Address:         input.Address,
+			Lines:           group,
+			UserID:          user.ID,
+			RestaurantID:    group[0].RestaurantID,
+			Status:          orderPlaced,
+			StatusUpdatedAt: now,
+		}
+		breakdown.apply(order)
+		orders = append(orders, order)
+		total = total.add(breakdown)
+	}
+	if input.Total != nil && *input.Total != total.Total {
+		writePriceMismatch(w, *input.Total, total)
+		return
+	}
+
+	var body []byte
+	err = saveNewOrders(orders, user, func(tx *gorm.DB) (err error) {
+		if err = tx.Where("cart_id = ? AND id IN ?", cart.ID, itemIDs).Delete(&CartItem{}).Error; err != nil {
+			return err
+		}
+		body, err = storeIdempotentResponse(r.Context(), tx, http.StatusCreated, map[string]interface{}{
+			"orders":  orders,
+			"pricing": total,
+		})
+		return err
+	})
+	if errors.Is(err, errSoldOut) {
+		// Блюдо закончилось, пока оформлялся заказ: показываем корзину с отметкой.
+		if view, err = refreshCart(cart, input.PromoCode); err != nil {
+			writeCartError(w, err)
+			return
+		}
+		writeCartConflict(w, view)
+		return
+	}
+	if err != nil {
+		writeSaveOrderError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withTestUser кладет user в контекст запроса, как это делает authMiddleware.
+func withTestUser(r *http.Request, user *User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey, user))
+}
+
+func TestUpdateUserCartKeepsCartOnFailure(t *testing.T) {
+	openTestDB(t)
+	user := User{Name: "Eve", Email: "eve@example.com", Role: roleCustomer}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	tea := createTestItem(t, FoodItem{Name: "Tea", Price: 200})
+	gone := createTestItem(t, FoodItem{Name: "Pie", Price: 300, Stock: intPtr(0)})
+	cart, err := cartForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addToCart(db, cart, soup.ID, 0, nil, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	body := fmt.Sprintf(`{"email": "eve@example.com", "cart": [{"id": %d}, {"id": %d}]}`, tea.ID, gone.ID)
+	w := httptest.NewRecorder()
+	updateUserCart(w, withTestUser(httptest.NewRequest(http.MethodPut, "/user/cart", strings.NewReader(body)), &user))
+	if w.Code == http.StatusOK {
+		t.Fatal("cart with a sold-out item was accepted")
+	}
+	var items []CartItem
+	if err := db.Where("cart_id = ?", cart.ID).Find(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || *items[0].FoodItemID != soup.ID || items[0].Quantity != 2 {
+		t.Errorf("cart after a failed replace = %+v, want the original 2 x Soup", items)
+	}
+}
+
+func createTestCart(t *testing.T) (*User, *Cart) {
+	t.Helper()
+	user := User{Name: "Eve", Email: "eve-" + newKeyID() + "@example.com", Role: roleCustomer}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	cart, err := cartForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &user, cart
+}
+
+func cartQuantities(t *testing.T, cartID uint) map[uint]int {
+	t.Helper()
+	var items []CartItem
+	if err := db.Where("cart_id = ?", cartID).Find(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	got := map[uint]int{}
+	for _, item := range items {
+		if item.FoodItemID != nil {
+			got[*item.FoodItemID] = item.Quantity
+		}
+	}
+	return got
+}
+
+func TestAddToCartSumsQuantities(t *testing.T) {
+	openTestDB(t)
+	_, cart := createTestCart(t)
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+
+	if err := addToCart(db, cart, soup.ID, 0, nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := addToCart(db, cart, soup.ID, 0, nil, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := cartQuantities(t, cart.ID)[soup.ID]; got != 5 {
+		t.Fatalf("quantity = %d, want 5", got)
+	}
+
+	for _, qty := range []int{0, -1, maxLineQuantity + 1, maxLineQuantity - 4} {
+		if err := addToCart(db, cart, soup.ID, 0, nil, qty); !errors.Is(err, errCartQuantity) {
+			t.Errorf("add %d: error = %v, want errCartQuantity", qty, err)
+		}
+	}
+	if got := cartQuantities(t, cart.ID)[soup.ID]; got != 5 {
+		t.Errorf("quantity after rejected adds = %d, want 5", got)
+	}
+	if err := addToCart(db, cart, soup.ID+1000, 0, nil, 1); !errors.Is(err, errFoodItemNotFound) {
+		t.Errorf("unknown item: error = %v, want errFoodItemNotFound", err)
+	}
+}
+
+func TestRefreshCartReportsMenuChanges(t *testing.T) {
+	openTestDB(t)
+	_, cart := createTestCart(t)
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	tea := createTestItem(t, FoodItem{Name: "Tea", Price: 200})
+	for _, id := range []uint{soup.ID, tea.ID} {
+		if err := addToCart(db, cart, id, 0, nil, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Model(&soup).Update("price", 650).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&tea).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	view, err := refreshCart(cart, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !view.hasChanges() {
+		t.Error("hasChanges = false after a price change and a removed item")
+	}
+	if len(view.Removed) != 1 || view.Removed[0] != "Tea" {
+		t.Errorf("removed = %v, want [Tea]", view.Removed)
+	}
+	if len(view.Items) != 1 {
+		t.Fatalf("items = %+v, want only Soup", view.Items)
+	}
+	item := view.Items[0]
+	if !item.PriceChanged || item.PreviousPrice == nil || *item.PreviousPrice != 500 || item.UnitPrice != 650 || item.LineTotal != 1300 {
+		t.Errorf("soup = %+v, want 2 x 6.50 with previous price 5.00", item)
+	}
+
+	// Новая цена сохранена: следующая сверка уже ничего не отмечает.
+	view, err = refreshCart(cart, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.hasChanges() {
+		t.Errorf("second refresh still reports changes: %+v", view)
+	}
+}
+
+func TestCheckoutCartRequiresReviewAfterPriceChange(t *testing.T) {
+	openTestDB(t)
+	user, cart := createTestCart(t)
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	if err := addToCart(db, cart, soup.ID, 0, nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(&soup).Update("price", 650).Error; err != nil {
+		t.Fatal(err)
+	}
+	checkout := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/cart/checkout", strings.NewReader(`{"address": "Main St 1"}`))
+		checkoutCart(w, withTestUser(r, user))
+		return w
+	}
+
+	if w := checkout(); w.Code != http.StatusConflict {
+		t.Fatalf("checkout after a price change: status = %d, want 409: %s", w.Code, w.Body)
+	}
+	var orders int64
+	db.Model(&Order{}).Where("user_id = ?", user.ID).Count(&orders)
+	if orders != 0 || cartQuantities(t, cart.ID)[soup.ID] != 2 {
+		t.Fatalf("rejected checkout created %d orders or changed the cart", orders)
+	}
+
+	if w := checkout(); w.Code != http.StatusCreated {
+		t.Fatalf("checkout after review: status = %d: %s", w.Code, w.Body)
+	}
+	var order Order
+	if err := db.Preload("Lines").Where("user_id = ?", user.ID).First(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(order.Lines) != 1 || order.Lines[0].UnitPrice != 650 || order.Lines[0].Quantity != 2 {
+		t.Errorf("order lines = %+v, want 2 x 6.50", order.Lines)
+	}
+	if n := len(cartQuantities(t, cart.ID)); n != 0 {
+		t.Errorf("%d items left in the cart after checkout", n)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigFile = "config.yaml"
+
+// Config - все настройки сервера. Значения берутся из defaultConfig, затем из
+// YAML-файла, затем из переменных окружения.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	Mail      MailConfig      `yaml:"mail"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Pricing   PricingConfig   `yaml:"pricing"`
+	Payments  PaymentsConfig  `yaml:"payments"`
+}
+
+// ServerConfig - адрес сервера и CORS. Фронтенд шлет запросы с credentials
+// (cookie гостевой корзины), а браузер не принимает такие ответы с
+// Access-Control-Allow-Origin: *, поэтому при CORSCredentials нужны явные origin.
+type ServerConfig struct {
+	Addr            string   `yaml:"addr"`
+	PublicBaseURL   string   `yaml:"public_base_url"`
+	CORSOrigins     []string `yaml:"cors_origins"`
+	CORSCredentials bool     `yaml:"cors_credentials"`
+}
+
+type DatabaseConfig struct {
+	DSN string `yaml:"dsn"`
+}
+
+type JWTConfig struct {
+	KeyFile string `yaml:"key_file"`
+	Secret  string `yaml:"secret"`
+}
+
+type MailConfig struct {
+	Backend      string     `yaml:"backend"`
+	Dir          string     `yaml:"dir"`
+	From         string     `yaml:"from"`
+	SupportInbox string     `yaml:"support_inbox"`
+	SMTP         SMTPConfig `yaml:"smtp"`
+}
+
+type SMTPConfig struct {
+	Host               string `yaml:"host"`
+	Port               int    `yaml:"port"`
+	User               string `yaml:"user"`
+	Password           string `yaml:"password"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+type RateLimitConfig struct {
+	Global        LimitConfig `yaml:"global"`
+	Order         LimitConfig `yaml:"order"`
+	PasswordReset LimitConfig `yaml:"password_reset"`
+}
+
+// PaymentsConfig - платежный шлюз. Required = false разрешает принимать
+// неоплаченные заказы (оплата при получении). WebhookSecret по умолчанию пуст:
+// fake шлюз сам подписывает свои вебхуки и получает случайный ключ на время
+// работы процесса, настоящему шлюзу ключ нужно задать явно.
+type PaymentsConfig struct {
+	Provider         string        `yaml:"provider"`
+	WebhookSecret    string        `yaml:"webhook_secret"`
+	Required         bool          `yaml:"required"`
+	FakeWebhookDelay time.Duration `yaml:"fake_webhook_delay"`
+}
+
+// LimitConfig - параметры token bucket. RPS = 0 означает без ограничения.
+type LimitConfig struct {
+	RPS   float64 `yaml:"rps"`
+	Burst int     `yaml:"burst"`
+}
+
+func (l LimitConfig) limiter() *rate.Limiter {
+	if l.RPS == 0 {
+		return rate.NewLimiter(rate.Inf, l.Burst)
+	}
+	return rate.NewLimiter(rate.Limit(l.RPS), l.Burst)
+}
+
+var cfg = defaultConfig()
+
+// leakedWebhookSecrets - ключи, которые когда-то были значением по умолчанию и
+// лежат в открытом репозитории.
+var leakedWebhookSecrets = map[string]bool{"dev-payment-webhook-secret": true}
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:          ":8080",
+			PublicBaseURL: "http://localhost:8080",
+			// Локальный статический сервер для фронтенда (python3 -m http.server 5500, Live Server).
+			CORSOrigins:     []string{"http://localhost:5500", "http://127.0.0.1:5500"},
+			CORSCredentials: true,
+		},
+		Database: DatabaseConfig{
+			DSN: "host=localhost user=postgres dbname=delivery port=27030 sslmode=disable",
+		},
+		JWT: JWTConfig{KeyFile: defaultKeyFile},
+		Mail: MailConfig{
+			Backend: "file",
+			Dir:     "mail",
+			From:    "no-reply@fooddelivery.local",
+			SMTP:    SMTPConfig{Host: "smtp.gmail.com", Port: 587},
+		},
+		RateLimit: RateLimitConfig{
+			Global: LimitConfig{RPS: 0, Burst: 50},
+			Order:  LimitConfig{RPS: 0.5, Burst: 2}, // 1 запрос каждые 2 секунды, максимум 2 одновременно
+			// Письма сброса пароля: не больше одного в 10 секунд на весь сервер.
+			PasswordReset: LimitConfig{RPS: 0.1, Burst: 3},
+		},
+		Pricing: PricingConfig{
+			Currency:         defaultCurrency,
+			DeliveryFee:      299,
+			FreeDeliveryOver: 3000,
+		},
+		Payments: PaymentsConfig{
+			Provider:         "fake",
+			Required:         true,
+			FakeWebhookDelay: 2 * time.Second,
+		},
+	}
+}
+
+// loadConfig читает файл path (если он есть), применяет переменные окружения и
+// проверяет результат.
+func loadConfig(path string) (*Config, error) {
+	c := defaultConfig()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && path == defaultConfigFile:
+		// Файл по умолчанию не обязателен: хватит значений по умолчанию и окружения.
+	default:
+		return nil, err
+	}
+
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) applyEnv() error {
+	stringVars := map[string]*string{
+		"LISTEN_ADDR":            &c.Server.Addr,
+		"PUBLIC_BASE_URL":        &c.Server.PublicBaseURL,
+		"DATABASE_DSN":           &c.Database.DSN,
+		"JWT_KEY_FILE":           &c.JWT.KeyFile,
+		"JWT_SECRET":             &c.JWT.Secret,
+		"MAIL_BACKEND":           &c.Mail.Backend,
+		"MAIL_DIR":               &c.Mail.Dir,
+		"MAIL_FROM":              &c.Mail.From,
+		"SUPPORT_EMAIL":          &c.Mail.SupportInbox,
+		"SMTP_HOST":              &c.Mail.SMTP.Host,
+		"SMTP_USER":              &c.Mail.SMTP.User,
+		"SMTP_PASSWORD":          &c.Mail.SMTP.Password,
+		"PAYMENT_PROVIDER":       &c.Payments.Provider,
+		"PAYMENT_WEBHOOK_SECRET": &c.Payments.WebhookSecret,
+	}
+	for key, dst := range stringVars {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+
+	if v, ok := os.LookupEnv("CORS_ALLOWED_ORIGINS"); ok {
+		c.Server.CORSOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				c.Server.CORSOrigins = append(c.Server.CORSOrigins, origin)
+			}
+		}
+	}
+	if v, ok := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); ok {
+		allow, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("CORS_ALLOW_CREDENTIALS: %w", err)
+		}
+		c.Server.CORSCredentials = allow
+	}
+	if v, ok := os.LookupEnv("SMTP_PORT"); ok {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("SMTP_PORT: %w", err)
+		}
+		c.Mail.SMTP.Port = port
+	}
+	if v, ok := os.LookupEnv("SMTP_INSECURE_SKIP_VERIFY"); ok {
+		skip, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("SMTP_INSECURE_SKIP_VERIFY: %w", err)
+		}
+		c.Mail.SMTP.InsecureSkipVerify = skip
+	}
+
+	limits := map[string]*LimitConfig{
+		"RATE_LIMIT_GLOBAL":         &c.RateLimit.Global,
+		"RATE_LIMIT_ORDER":          &c.RateLimit.Order,
+		"RATE_LIMIT_PASSWORD_RESET": &c.RateLimit.PasswordReset,
+	}
+	for prefix, dst := range limits {
+		if v, ok := os.LookupEnv(prefix + "_RPS"); ok {
+			rps, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("%s_RPS: %w", prefix, err)
+			}
+			dst.RPS = rps
+		}
+		if v, ok := os.LookupEnv(prefix + "_BURST"); ok {
+			burst, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s_BURST: %w", prefix, err)
+			}
+			dst.Burst = burst
+		}
+	}
+
+	if v, ok := os.LookupEnv("PAYMENTS_REQUIRED"); ok {
+		required, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("PAYMENTS_REQUIRED: %w", err)
+		}
+		c.Payments.Required = required
+	}
+	if v, ok := os.LookupEnv("PRICING_CURRENCY"); ok {
+		c.Pricing.Currency = strings.ToUpper(v)
+	}
+	if v, ok := os.LookupEnv("PRICING_TAX_RATE"); ok {
+		taxRate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("PRICING_TAX_RATE: %w", err)
+		}
+		c.Pricing.TaxRate = taxRate
+	}
+	amounts := map[string]*Money{
+		"PRICING_DELIVERY_FEE":       &c.Pricing.DeliveryFee,
+		"PRICING_FREE_DELIVERY_OVER": &c.Pricing.FreeDeliveryOver,
+	}
+	for key, dst := range amounts {
+		if v, ok := os.LookupEnv(key); ok {
+			amount, err := parseMoney(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*dst = amount
+		}
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	var problems []string
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr is required")
+	}
+	if u, err := url.Parse(c.Server.PublicBaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problems = append(problems, "server.public_base_url must be an absolute URL")
+	}
+	if len(c.Server.CORSOrigins) == 0 {
+		problems = append(problems, "server.cors_origins must not be empty")
+	}
+	for _, origin := range c.Server.CORSOrigins {
+		if origin == "*" && c.Server.CORSCredentials {
+			problems = append(problems, `server.cors_origins cannot contain "*" while server.cors_credentials is on, list the frontend origins`)
+		}
+	}
+	if c.Database.DSN == "" {
+		problems = append(problems, "database.dsn is required")
+	}
+	if c.JWT.Secret == "" && c.JWT.KeyFile == "" {
+		problems = append(problems, "jwt.key_file or jwt.secret is required")
+	}
+	if c.JWT.Secret != "" && len(c.JWT.Secret) < 32 {
+		problems = append(problems, "jwt.secret must be at least 32 characters")
+	}
+	if c.Mail.From == "" {
+		problems = append(problems, "mail.from is required")
+	}
+	switch c.Mail.Backend {
+	case "smtp":
+		if c.Mail.SMTP.Host == "" || c.Mail.SMTP.Port <= 0 {
+			problems = append(problems, "mail.smtp.host and mail.smtp.port are required for the smtp backend")
+		}
+	case "file":
+		if c.Mail.Dir == "" {
+			problems = append(problems, "mail.dir is required for the file backend")
+		}
+	case "memory":
+	default:
+		problems = append(problems, fmt.Sprintf("mail.backend %q must be smtp, file or memory", c.Mail.Backend))
+	}
+	for name, l := range map[string]LimitConfig{
+		"global":         c.RateLimit.Global,
+		"order":          c.RateLimit.Order,
+		"password_reset": c.RateLimit.PasswordReset,
+	} {
+		if l.RPS < 0 || l.Burst <= 0 {
+			problems = append(problems, fmt.Sprintf("rate_limit.%s needs rps >= 0 and burst > 0", name))
+		}
+	}
+	if !currencyRe.MatchString(c.Pricing.Currency) {
+		problems = append(problems, "pricing.currency must be an ISO 4217 code like USD")
+	}
+	if c.Pricing.TaxRate < 0 || c.Pricing.TaxRate >= 1 {
+		problems = append(problems, "pricing.tax_rate must be in [0, 1)")
+	}
+	if c.Pricing.DeliveryFee < 0 || c.Pricing.FreeDeliveryOver < 0 {
+		problems = append(problems, "pricing.delivery_fee and pricing.free_delivery_over must not be negative")
+	}
+	promoCodes := make(map[string]float64, len(c.Pricing.PromoCodes))
+	for code, percent := range c.Pricing.PromoCodes {
+		if percent <= 0 || percent > 100 {
+			problems = append(problems, fmt.Sprintf("pricing.promo_codes.%s must be in (0, 100]", code))
+		}
+		promoCodes[strings.ToUpper(code)] = percent
+	}
+	c.Pricing.PromoCodes = promoCodes
+	switch c.Payments.Provider {
+	case "fake":
+	default:
+		problems = append(problems, fmt.Sprintf("payments.provider %q must be fake", c.Payments.Provider))
+	}
+	if c.Payments.WebhookSecret == "" && c.Payments.Provider == "fake" {
+		c.Payments.WebhookSecret = newKeyID() + newKeyID()
+	}
+	switch {
+	case leakedWebhookSecrets[c.Payments.WebhookSecret]:
+		problems = append(problems, "payments.webhook_secret is a published sample value, generate your own")
+	case len(c.Payments.WebhookSecret) < 16:
+		problems = append(problems, "payments.webhook_secret must be at least 16 characters")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// configFilePath - путь к файлу настроек из CONFIG_FILE, по умолчанию config.yaml.
+func configFilePath() string {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+	return defaultConfigFile
+}
+
+func initConfig() {
+	c, err := loadConfig(configFilePath())
+	if err != nil {
+		logger.WithField("error", err).Fatal("Failed to load config")
+	}
+	cfg = c
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string // пусто - конфиг валиден
+	}{
+		{
+			name:   "defaults",
+			modify: func(c *Config) {},
+		},
+		{
+			name:   "explicit webhook secret",
+			modify: func(c *Config) { c.Payments.WebhookSecret = "0123456789abcdef0123" },
+		},
+		{
+			name:    "published sample webhook secret",
+			modify:  func(c *Config) { c.Payments.WebhookSecret = "dev-payment-webhook-secret" },
+			wantErr: "published sample value",
+		},
+		{
+			name:    "short webhook secret",
+			modify:  func(c *Config) { c.Payments.WebhookSecret = "short" },
+			wantErr: "at least 16 characters",
+		},
+		{
+			name:    "wildcard origin with credentials",
+			modify:  func(c *Config) { c.Server.CORSOrigins = []string{"*"} },
+			wantErr: "cors_origins",
+		},
+		{
+			name: "wildcard origin without credentials",
+			modify: func(c *Config) {
+				c.Server.CORSOrigins = []string{"*"}
+				c.Server.CORSCredentials = false
+			},
+		},
+		{
+			name:    "no origins",
+			modify:  func(c *Config) { c.Server.CORSOrigins = nil },
+			wantErr: "cors_origins",
+		},
+		{
+			name:    "short jwt secret",
+			modify:  func(c *Config) { c.JWT.Secret = "short" },
+			wantErr: "jwt.secret",
+		},
+		{
+			name:    "password reset limit without burst",
+			modify:  func(c *Config) { c.RateLimit.PasswordReset.Burst = 0 },
+			wantErr: "rate_limit.password_reset",
+		},
+		{
+			name:    "unknown mail backend",
+			modify:  func(c *Config) { c.Mail.Backend = "pigeon" },
+			wantErr: "mail.backend",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			tt.modify(c)
+			err := c.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFakeProviderGetsRandomWebhookSecret(t *testing.T) {
+	a, b := defaultConfig(), defaultConfig()
+	if a.Payments.WebhookSecret != "" {
+		t.Fatalf("default webhook secret must be empty, got %q", a.Payments.WebhookSecret)
+	}
+	if err := a.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Payments.WebhookSecret) < 16 || a.Payments.WebhookSecret == b.Payments.WebhookSecret {
+		t.Errorf("fake provider secrets %q and %q must be random", a.Payments.WebhookSecret, b.Payments.WebhookSecret)
+	}
+}
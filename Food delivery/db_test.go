@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// openTestDB подключает глобальный db к отдельной схеме тестовой базы из
+// TEST_DATABASE_DSN и применяет к ней все миграции. Без переменной тест пропускается.
+// Схема удаляется после теста, поэтому тесты с базой не мешают друг другу.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	quiet := &gorm.Config{TranslateError: true, Logger: gormlogger.Default.LogMode(gormlogger.Silent)}
+	admin, err := gorm.Open(postgres.Open(dsn), quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + newKeyID()
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	prev := db
+	db, err = gorm.Open(postgres.Open(withSearchPath(dsn, schema)), quiet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+		db = prev
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := migrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withSearchPath добавляет search_path к DSN в виде URL или key=value.
+func withSearchPath(dsn, schema string) string {
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return dsn
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return fmt.Sprintf("%s search_path=%s", dsn, schema)
+}
+
+// useMemoryMailer подменяет mailer на memoryMailer до конца теста.
+func useMemoryMailer(t *testing.T) *memoryMailer {
+	t.Helper()
+	prev := mailer
+	m := &memoryMailer{}
+	mailer = m
+	t.Cleanup(func() { mailer = prev })
+	return m
+}
+
+func createTestItem(t *testing.T, item FoodItem) FoodItem {
+	t.Helper()
+	if item.RestaurantID == 0 {
+		id, err := defaultRestaurantID(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item.RestaurantID = id
+	}
+	if err := db.Create(&item).Error; err != nil {
+		t.Fatal(err)
+	}
+	return item
+}
+
+func intPtr(v int) *int { return &v }
+
+// countingProvider - fake шлюз, который считает вызовы и может отказать
+// несколько раз подряд (failNext).
+type countingProvider struct {
+	fakePaymentProvider
+	mu       sync.Mutex
+	calls    map[string]int
+	failures map[string]int
+}
+
+func useCountingProvider(t *testing.T) *countingProvider {
+	t.Helper()
+	p := &countingProvider{calls: map[string]int{}, failures: map[string]int{}}
+	prev := paymentProvider
+	paymentProvider = p
+	t.Cleanup(func() { paymentProvider = prev })
+	return p
+}
+
+func (p *countingProvider) count(op string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls[op]
+}
+
+// failNext заставляет следующие n вызовов op вернуть ошибку.
+func (p *countingProvider) failNext(op string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failures[op] = n
+}
+
+func (p *countingProvider) record(op string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[op]++
+	if p.failures[op] > 0 {
+		p.failures[op]--
+		return fmt.Errorf("%s: provider unavailable", op)
+	}
+	return nil
+}
+
+func (p *countingProvider) Capture(ctx context.Context, reference string, amount Money, key string) (ProviderResult, error) {
+	if err := p.record("capture"); err != nil {
+		return ProviderResult{}, err
+	}
+	return p.fakePaymentProvider.Capture(ctx, reference, amount, key)
+}
+
+func (p *countingProvider) Void(ctx context.Context, reference string, key string) (ProviderResult, error) {
+	if err := p.record("void"); err != nil {
+		return ProviderResult{}, err
+	}
+	return p.fakePaymentProvider.Void(ctx, reference, key)
+}
+
+func (p *countingProvider) Refund(ctx context.Context, reference string, amount Money, key string) (ProviderResult, error) {
+	if err := p.record("refund"); err != nil {
+		return ProviderResult{}, err
+	}
+	return p.fakePaymentProvider.Refund(ctx, reference, amount, key)
+}
+
+// createTestOrder создает заказ в статусе placed с позициями lines и, если
+// paymentStatus не пуст, платеж на его сумму в этом статусе.
+func createTestOrder(t *testing.T, paymentStatus string, lines ...OrderLine) (*Order, *User) {
+	t.Helper()
+	user := User{Name: "Customer", Email: "customer-" + newKeyID() + "@example.com", Role: roleCustomer}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	restaurantID, err := defaultRestaurantID(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := Order{UserID: user.ID, RestaurantID: restaurantID, Status: orderPlaced, Currency: "USD", Lines: lines}
+	for _, line := range lines {
+		order.Subtotal += line.LineTotal
+	}
+	order.Total = order.Subtotal
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	if paymentStatus != "" {
+		payment := Payment{OrderID: order.ID, Provider: "fake", Reference: fmt.Sprintf("fake_pay_%d", order.ID),
+			Status: paymentStatus, Amount: order.Total, Currency: order.Currency}
+		if err := db.Create(&payment).Error; err != nil {
+			t.Fatal(err)
+		}
+		order.PaymentStatus = paymentStatus
+	}
+	return &order, &user
+}
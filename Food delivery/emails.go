@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+
+	"gorm.io/gorm"
+)
+
+//go:embed templates/email
+var emailTemplateFS embed.FS
+
+const defaultLanguage = "ru"
+
+var supportedLanguages = map[string]bool{"ru": true, "en": true}
+
+// Виды писем; для каждого есть templates/email/<lang>/<kind>.txt (subject + body)
+// и <kind>.html.
+const (
+	emailConfirmation  = "confirmation"
+	emailPasswordReset = "password_reset"
+	emailOrderReceipt  = "order_receipt"
+	emailSupportAck    = "support_ack"
+	emailSupport       = "support"
+	emailLowStock      = "low_stock"
+)
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var emailTemplates = loadEmailTemplates()
+
+func loadEmailTemplates() map[string]emailTemplate {
+	templates := map[string]emailTemplate{}
+	for lang := range supportedLanguages {
+		for _, kind := range []string{emailConfirmation, emailPasswordReset, emailOrderReceipt, emailSupportAck, emailLowStock} {
+			base := fmt.Sprintf("templates/email/%s/%s", lang, kind)
+			templates[lang+"/"+kind] = emailTemplate{
+				text: texttemplate.Must(texttemplate.ParseFS(emailTemplateFS, base+".txt")),
+				html: htmltemplate.Must(htmltemplate.ParseFS(emailTemplateFS, base+".html")),
+			}
+		}
+	}
+	return templates
+}
+
+// normalizeLanguage приводит "en-US,en;q=0.9" и подобное к поддерживаемому коду языка.
+func normalizeLanguage(lang string) string {
+	for _, part := range strings.Split(lang, ",") {
+		code := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		code = strings.SplitN(code, "-", 2)[0]
+		if supportedLanguages[code] {
+			return code
+		}
+	}
+	return defaultLanguage
+}
+
+// requestLanguage - язык из Accept-Language для писем тем, у кого нет профиля.
+func requestLanguage(r *http.Request) string {
+	return normalizeLanguage(r.Header.Get("Accept-Language"))
+}
+
+// renderEmail собирает письмо из шаблонов kind на языке lang. В data всегда
+// доступен BaseURL.
+func renderEmail(kind, lang, to string, data map[string]interface{}) (*Email, error) {
+	tmpl, ok := emailTemplates[normalizeLanguage(lang)+"/"+kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown email template %q", kind)
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["BaseURL"] = publicBaseURL()
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.ExecuteTemplate(&text, "body", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+	return &Email{
+		To:       []string{to},
+		Subject:  strings.TrimSpace(subject.String()),
+		TextBody: strings.TrimSpace(text.String()) + "\n",
+		HTMLBody: html.String(),
+	}, nil
+}
+
+// publicLink строит абсолютную ссылку на сервер для писем.
+func publicLink(path string, query url.Values) string {
+	link := strings.TrimRight(publicBaseURL(), "/") + path
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+	return link
+}
+
+// enqueueOrderReceipt ставит в очередь чек по заказу; вызывается в транзакции
+// создания заказа, после того как у заказа появился ID.
+func enqueueOrderReceipt(tx *gorm.DB, order *Order, user *User) error {
+	msg, err := renderEmail(emailOrderReceipt, user.Language, user.Email, map[string]interface{}{
+		"Order": order,
+	})
+	if err != nil {
+		return err
+	}
+	return enqueueEmail(tx, emailOrderReceipt, msg)
+}
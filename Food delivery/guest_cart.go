@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	guestCartCookie = "guest_cart"
+	// guestCartTTL - брошенная гостевая корзина удаляется через это время после
+	// последнего обращения.
+	guestCartTTL             = 7 * 24 * time.Hour
+	guestCartJanitorInterval = time.Hour
+)
+
+// setGuestCartCookie выдает браузеру токен гостевой корзины. В базе лежит только его хэш.
+func setGuestCartCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCartCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		MaxAge:   int(time.Until(expires).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearGuestCartCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     guestCartCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// guestCartFromRequest находит неистекшую гостевую корзину по cookie. Без cookie
+// или с неизвестным токеном возвращает nil без ошибки.
+func guestCartFromRequest(r *http.Request) (*Cart, string, error) {
+	cookie, err := r.Cookie(guestCartCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, "", nil
+	}
+	var cart Cart
+	err = db.Where("token_hash = ? AND user_id IS NULL AND expires_at > ?", hashToken(cookie.Value), time.Now()).
+		First(&cart).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &cart, cookie.Value, nil
+}
+
+// newGuestCart создает пустую гостевую корзину и возвращает ее токен.
+func newGuestCart() (*Cart, string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return nil, "", err
+	}
+	hash := hashToken(token)
+	expires := time.Now().Add(guestCartTTL)
+	cart := Cart{TokenHash: &hash, ExpiresAt: &expires}
+	if err := db.Create(&cart).Error; err != nil {
+		return nil, "", err
+	}
+	return &cart, token, nil
+}
+
+// guestCart возвращает корзину гостя и продлевает ей срок. create = false - не
+// создавать корзину, если ее нет (для чтения), тогда результат может быть nil.
+func guestCart(w http.ResponseWriter, r *http.Request, create bool) (*Cart, error) {
+	cart, token, err := guestCartFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	if cart == nil {
+		if !create {
+			return nil, nil
+		}
+		if cart, token, err = newGuestCart(); err != nil {
+			return nil, err
+		}
+	} else {
+		expires := time.Now().Add(guestCartTTL)
+		if err := db.Model(cart).Update("expires_at", expires).Error; err != nil {
+			return nil, err
+		}
+		cart.ExpiresAt = &expires
+	}
+	setGuestCartCookie(w, r, token, *cart.ExpiresAt)
+	return cart, nil
+}
+
+// mergeGuestCart переносит гостевую корзину в корзину пользователя и удаляет ее.
+// Количества одного блюда с одинаковыми размером и опциями складываются (не больше maxLineQuantity), цена и название
+// берутся из корзины пользователя - их все равно обновит refreshCart. Гостевая
+// корзина удаляется первой в той же транзакции, поэтому два одновременных входа с
+// одной cookie не перенесут ее дважды.
+func mergeGuestCart(guest *Cart, userID uint) (int, error) {
+	cart, err := cartForUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	var items []CartItem
+	if err := db.Where("cart_id = ? AND food_item_id IS NOT NULL", guest.ID).Order("id").Find(&items).Error; err != nil {
+		return 0, err
+	}
+	merged := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Позиции гостя удаляются каскадом.
+		res := tx.Delete(&Cart{}, guest.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		for _, item := range items {
+			line := CartItem{CartID: cart.ID, FoodItemID: item.FoodItemID, VariantID: item.VariantID, OptionsKey: item.OptionsKey, Name: item.Name, UnitPrice: item.UnitPrice, Quantity: item.Quantity}
+			err := tx.Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "cart_id"}, {Name: "food_item_id"}, {Name: "variant_id"}, {Name: "options_key"}},
+				DoUpdates: clause.Assignments(map[string]interface{}{
+					"quantity":   gorm.Expr("LEAST(cart_items.quantity + excluded.quantity, ?)", maxLineQuantity),
+					"updated_at": gorm.Expr("excluded.updated_at"),
+				}),
+			}).Create(&line).Error
+			if err != nil {
+				return err
+			}
+		}
+		merged = len(items)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return merged, nil
+}
+
+// mergeGuestCartOnLogin вызывается после успешного входа. Ошибка слияния не
+// мешает входу: корзина гостя останется и сольется при следующем входе.
+func mergeGuestCartOnLogin(w http.ResponseWriter, r *http.Request, user *User) {
+	guest, _, err := guestCartFromRequest(r)
+	if err == nil && guest != nil {
+		var merged int
+		merged, err = mergeGuestCart(guest, user.ID)
+		if err == nil {
+			logger.WithField("user_id", user.ID).WithField("items", merged).Info("Guest cart merged")
+		}
+	}
+	if err != nil {
+		logger.WithField("user_id", user.ID).WithField("error", err).Error("Failed to merge guest cart")
+		return
+	}
+	if _, cookieErr := r.Cookie(guestCartCookie); cookieErr == nil {
+		clearGuestCartCookie(w, r)
+	}
+}
+
+// runGuestCartJanitor удаляет брошенные гостевые корзины.
+func runGuestCartJanitor() {
+	ticker := time.NewTicker(guestCartJanitorInterval)
+	defer ticker.Stop()
+	for {
+		res := db.Where("user_id IS NULL AND expires_at < ?", time.Now()).Delete(&Cart{})
+		if res.Error != nil {
+			logger.WithField("error", res.Error).Error("Failed to delete expired guest carts")
+		} else if res.RowsAffected > 0 {
+			logger.WithField("carts", res.RowsAffected).Info("Expired guest carts deleted")
+		}
+		<-ticker.C
+	}
+}
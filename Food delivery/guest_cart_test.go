@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func guestCookie(t *testing.T, w *httptest.ResponseRecorder) *http.Cookie {
+	t.Helper()
+	for _, c := range w.Result().Cookies() {
+		if c.Name == guestCartCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestGuestCartCookie(t *testing.T) {
+	openTestDB(t)
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	get := func(cookie *http.Cookie) (*httptest.ResponseRecorder, CartView) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/cart", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		getCart(w, r)
+		var view CartView
+		if err := json.NewDecoder(w.Body).Decode(&view); err != nil {
+			t.Fatal(err)
+		}
+		return w, view
+	}
+
+	// Просмотр без cookie не создает корзину.
+	w, view := get(nil)
+	if len(view.Items) != 0 || guestCookie(t, w) != nil {
+		t.Fatalf("anonymous view: items %v, cookie %v, want an empty cart without a cookie", view.Items, guestCookie(t, w))
+	}
+	var carts int64
+	db.Model(&Cart{}).Count(&carts)
+	if carts != 0 {
+		t.Fatalf("%d carts created by a view", carts)
+	}
+
+	w = httptest.NewRecorder()
+	addCartItem(w, httptest.NewRequest(http.MethodPost, "/cart/items", strings.NewReader(fmt.Sprintf(`{"food_item_id": %d, "quantity": 2}`, soup.ID))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("add: status = %d: %s", w.Code, w.Body)
+	}
+	cookie := guestCookie(t, w)
+	if cookie == nil || cookie.Value == "" || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("guest cookie = %+v, want an HttpOnly SameSite=Lax token", cookie)
+	}
+	var cart Cart
+	if err := db.Where("user_id IS NULL").First(&cart).Error; err != nil {
+		t.Fatal(err)
+	}
+	if cart.TokenHash == nil || *cart.TokenHash != hashToken(cookie.Value) {
+		t.Error("the database must keep only the hash of the cookie token")
+	}
+
+	_, view = get(cookie)
+	if len(view.Items) != 1 || view.Items[0].Quantity != 2 {
+		t.Errorf("cart by cookie = %+v, want 2 x Soup", view.Items)
+	}
+	_, view = get(&http.Cookie{Name: guestCartCookie, Value: "forged"})
+	if len(view.Items) != 0 {
+		t.Errorf("unknown token opened a cart: %+v", view.Items)
+	}
+}
+
+func TestMergeGuestCartOnLogin(t *testing.T) {
+	openTestDB(t)
+	user, cart := createTestCart(t)
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	tea := createTestItem(t, FoodItem{Name: "Tea", Price: 200})
+	if err := addToCart(db, cart, soup.ID, 0, nil, 2); err != nil {
+		t.Fatal(err)
+	}
+	guest, token, err := newGuestCart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addToCart(db, guest, soup.ID, 0, nil, maxLineQuantity-1); err != nil {
+		t.Fatal(err)
+	}
+	if err := addToCart(db, guest, tea.ID, 0, nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.AddCookie(&http.Cookie{Name: guestCartCookie, Value: token})
+		w := httptest.NewRecorder()
+		mergeGuestCartOnLogin(w, r, user)
+		return w
+	}
+	w := login()
+	if c := guestCookie(t, w); c == nil || c.MaxAge >= 0 {
+		t.Errorf("guest cookie = %+v, want it cleared", c)
+	}
+	want := map[uint]int{soup.ID: maxLineQuantity, tea.ID: 1}
+	got := cartQuantities(t, cart.ID)
+	if len(got) != len(want) || got[soup.ID] != want[soup.ID] || got[tea.ID] != want[tea.ID] {
+		t.Errorf("merged cart = %v, want %v", got, want)
+	}
+	var left int64
+	db.Model(&Cart{}).Where("id = ?", guest.ID).Count(&left)
+	if left != 0 {
+		t.Error("guest cart was not deleted after the merge")
+	}
+
+	// Повторный вход с той же cookie ничего не добавляет.
+	login()
+	if got := cartQuantities(t, cart.ID); got[tea.ID] != 1 {
+		t.Errorf("second login changed the cart: %v", got)
+	}
+}
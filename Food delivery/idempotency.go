@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	maxIdempotencyKey = 255
+	// idempotencyKeyTTL - сколько хранится ответ; повтор после этого создаст новый заказ.
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyLockTimeout - запрос, который не завершился за это время (упал сервер),
+	// считается брошенным, и повтор с тем же ключом выполняется заново.
+	idempotencyLockTimeout = time.Minute
+)
+
+// IdempotencyKey - сохраненный результат запроса с заголовком Idempotency-Key.
+// Ключ уникален в пределах пользователя. StatusCode = 0 - запрос еще выполняется.
+type IdempotencyKey struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Key         string `gorm:"not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Endpoint    string `gorm:"not null"`
+	RequestHash string `gorm:"not null"`
+	StatusCode  int    `gorm:"not null"`
+	ContentType string `gorm:"not null"`
+	Response    []byte
+	ExpiresAt   time.Time `gorm:"index;not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// idempotencyClaim - ключ, занятый текущим запросом; лежит в контексте запроса.
+type idempotencyClaim struct {
+	id     uint
+	stored bool // ответ уже сохранен обработчиком, см. storeIdempotentResponse
+}
+
+const idempotencyContextKey contextKey = "idempotency"
+
+// storeIdempotentResponse кодирует ответ v и, если запрос идет с Idempotency-Key,
+// сохраняет его в транзакции tx, в которой обработчик создает заказ. Заказ и
+// ответ фиксируются вместе: ключ не может остаться без ответа при созданном
+// заказе, и повтор после таймаута не создаст заказ второй раз. Возвращает тело ответа.
+func storeIdempotentResponse(ctx context.Context, tx *gorm.DB, status int, v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	body = append(body, '\n')
+	claim, ok := ctx.Value(idempotencyContextKey).(*idempotencyClaim)
+	if !ok {
+		return body, nil
+	}
+	err = tx.Model(&IdempotencyKey{}).Where("id = ?", claim.id).Updates(map[string]interface{}{
+		"status_code":  status,
+		"content_type": "application/json",
+		"response":     body,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	claim.stored = true
+	return body, nil
+}
+
+func requestHash(endpoint string, body []byte) string {
+	sum := sha256.Sum256(append([]byte(endpoint+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// claimIdempotencyKey занимает ключ за текущим запросом. Возвращает true, если
+// запрос нужно выполнить, иначе существующую запись.
+func claimIdempotencyKey(userID uint, key, endpoint, hash string) (bool, *IdempotencyKey, error) {
+	now := time.Now()
+	db.Where("user_id = ? AND expires_at < ?", userID, now).Delete(&IdempotencyKey{})
+
+	row := IdempotencyKey{
+		UserID:      userID,
+		Key:         key,
+		Endpoint:    endpoint,
+		RequestHash: hash,
+		ExpiresAt:   now.Add(idempotencyKeyTTL),
+	}
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if res.Error != nil {
+		return false, nil, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return true, &row, nil
+	}
+
+	var existing IdempotencyKey
+	if err := db.Where("user_id = ? AND key = ?", userID, key).First(&existing).Error; err != nil {
+		return false, nil, err
+	}
+	if existing.StatusCode == 0 && existing.RequestHash == hash && existing.Endpoint == endpoint &&
+		existing.UpdatedAt.Before(now.Add(-idempotencyLockTimeout)) {
+		// Перехватываем брошенный запрос условным обновлением, чтобы это сделал только один.
+		res := db.Model(&IdempotencyKey{}).
+			Where("id = ? AND status_code = 0 AND updated_at = ?", existing.ID, existing.UpdatedAt).
+			Update("updated_at", now)
+		if res.Error != nil {
+			return false, nil, res.Error
+		}
+		if res.RowsAffected == 1 {
+			return true, &existing, nil
+		}
+	}
+	return false, &existing, nil
+}
+
+// idempotent выполняет обработчик не больше одного раза на Idempotency-Key. Повтор с
+// тем же телом получает сохраненный ответ, другое тело или другой адрес с тем же
+// ключом - 422. Обработчики, создающие заказы, сохраняют ответ сами в транзакции
+// заказа (storeIdempotentResponse); остальные ответы сохраняются здесь. Ответы 5xx
+// не сохраняются, такой запрос можно повторить; кроме 502 - ошибки шлюза, после
+// которой деньги могли уйти, и повтор не должен провести оплату второй раз.
+// Должен стоять после authMiddleware.
+func idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			return
+		}
+		user, ok := userFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		endpoint := r.Method + " " + r.URL.Path
+		hash := requestHash(endpoint, body)
+
+		run, row, err := claimIdempotencyKey(user.ID, key, endpoint, hash)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, "Failed to check Idempotency-Key", err)
+			return
+		}
+		if !run {
+			switch {
+			case row.Endpoint != endpoint || row.RequestHash != hash:
+				http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+			case row.StatusCode == 0:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+			default:
+				if row.ContentType != "" {
+					w.Header().Set("Content-Type", row.ContentType)
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(row.StatusCode)
+				w.Write(row.Response)
+			}
+			return
+		}
+
+		claim := &idempotencyClaim{id: row.ID}
+		rec := &responseRecorder{header: http.Header{}, code: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), idempotencyContextKey, claim)))
+
+		switch {
+		case claim.stored:
+		case rec.code >= http.StatusInternalServerError && rec.code != http.StatusBadGateway:
+			db.Delete(&IdempotencyKey{}, row.ID)
+		default:
+			err := db.Model(&IdempotencyKey{}).Where("id = ?", row.ID).Updates(map[string]interface{}{
+				"status_code":  rec.code,
+				"content_type": rec.header.Get("Content-Type"),
+				"response":     rec.body.Bytes(),
+			}).Error
+			if err != nil {
+				logger.WithField("error", err).WithField("key_id", row.ID).Error("Failed to store idempotent response")
+			}
+		}
+
+		for name, values := range rec.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(rec.code)
+		w.Write(rec.body.Bytes())
+	})
+}
+
+// responseRecorder буферизует ответ обработчика, чтобы сохранить его перед отправкой.
+type responseRecorder struct {
+	header      http.Header
+	body        bytes.Buffer
+	code        int
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.header }
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.code = code
+		rec.wroteHeader = true
+	}
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.body.Write(p)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentCreateOrder(t *testing.T) {
+	openTestDB(t)
+	user := User{Name: "Eve", Email: "eve@example.com", Role: roleCustomer}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	handler := idempotent(http.HandlerFunc(createOrder))
+	post := func(key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		r.Header.Set(idempotencyHeader, key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, withTestUser(r, &user))
+		return w
+	}
+	body := fmt.Sprintf(`{"address": "Main St 1", "lines": [{"food_item_id": %d, "quantity": 2}]}`, soup.ID)
+
+	first := post("key-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first status = %d: %s", first.Code, first.Body)
+	}
+	// Ответ сохранен в транзакции заказа.
+	var key IdempotencyKey
+	if err := db.Where("user_id = ? AND key = ?", user.ID, "key-1").First(&key).Error; err != nil {
+		t.Fatal(err)
+	}
+	if key.StatusCode != http.StatusCreated || string(key.Response) != first.Body.String() {
+		t.Errorf("stored response = %d %q, want the first response", key.StatusCode, key.Response)
+	}
+
+	replay := post("key-1", body)
+	if replay.Code != http.StatusCreated || replay.Body.String() != first.Body.String() || replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replay = %d %q (replayed %q), want the first response", replay.Code, replay.Body, replay.Header().Get("Idempotent-Replayed"))
+	}
+	if conflict := post("key-1", strings.Replace(body, `"quantity": 2`, `"quantity": 3`, 1)); conflict.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reuse with another body: status = %d, want 422", conflict.Code)
+	}
+
+	var orders int64
+	db.Model(&Order{}).Where("user_id = ?", user.ID).Count(&orders)
+	if orders != 1 {
+		t.Errorf("%d orders created, want 1", orders)
+	}
+}
+
+func TestIdempotentKeepsProviderErrors(t *testing.T) {
+	openTestDB(t)
+	user := User{Name: "Eve", Email: "eve@example.com", Role: roleCustomer}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		status   int
+		wantRuns int
+	}{
+		{"provider error is replayed", http.StatusBadGateway, 1},
+		{"internal error frees the key", http.StatusInternalServerError, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			handler := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				runs++
+				http.Error(w, "failed", tt.status)
+			}))
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest(http.MethodPost, "/orders/1/payments", strings.NewReader(`{"payment_token": "tok_approved"}`))
+				r.Header.Set(idempotencyHeader, "pay-"+tt.name)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, withTestUser(r, &user))
+				if w.Code != tt.status {
+					t.Errorf("attempt %d: status = %d, want %d", i+1, w.Code, tt.status)
+				}
+			}
+			if runs != tt.wantRuns {
+				t.Errorf("handler ran %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestIdempotentRejectsBadKeys(t *testing.T) {
+	handler := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not run")
+	}))
+	tests := []struct {
+		name string
+		key  string
+		user *User
+		want int
+	}{
+		{"key too long", strings.Repeat("k", maxIdempotencyKey+1), &User{ID: 1}, http.StatusBadRequest},
+		{"no user", "key-1", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+			r.Header.Set(idempotencyHeader, tt.key)
+			if tt.user != nil {
+				r = withTestUser(r, tt.user)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdempotentReplayAndConflicts(t *testing.T) {
+	openTestDB(t)
+	var users [2]User
+	for i := range users {
+		users[i] = User{Name: "Eve", Email: fmt.Sprintf("eve-%d@example.com", i), Role: roleCustomer}
+		if err := db.Create(&users[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs := 0
+	handler := idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		http.Error(w, fmt.Sprintf("run %d", runs), http.StatusBadRequest)
+	}))
+	send := func(user *User, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set(idempotencyHeader, "key-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, withTestUser(r, user))
+		return w
+	}
+
+	first := send(&users[0], "/orders", `{"a": 1}`)
+	// Ответы 4xx тоже сохраняются: повтор получает тот же отказ, обработчик не запускается.
+	replay := send(&users[0], "/orders", `{"a": 1}`)
+	if replay.Code != http.StatusBadRequest || replay.Body.String() != first.Body.String() || runs != 1 {
+		t.Errorf("replay = %d %q after %d runs, want the first response after 1 run", replay.Code, replay.Body, runs)
+	}
+	if w := send(&users[0], "/cart/checkout", `{"a": 1}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("same key on another endpoint: status = %d, want 422", w.Code)
+	}
+	// Ключ уникален в пределах пользователя.
+	if w := send(&users[1], "/orders", `{"a": 1}`); w.Body.String() == first.Body.String() || runs != 2 {
+		t.Errorf("another user's request was answered from the first user's key: %q", w.Body)
+	}
+
+	// Запрос с ключом еще выполняется: 409 с Retry-After, пока не истечет блокировка.
+	inFlight := IdempotencyKey{UserID: users[0].ID, Key: "key-2", Endpoint: "POST /orders",
+		RequestHash: requestHash("POST /orders", []byte(`{"a": 1}`)), ExpiresAt: time.Now().Add(idempotencyKeyTTL)}
+	if err := db.Create(&inFlight).Error; err != nil {
+		t.Fatal(err)
+	}
+	sendKey2 := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"a": 1}`))
+		r.Header.Set(idempotencyHeader, "key-2")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, withTestUser(r, &users[0]))
+		return w
+	}
+	if w := sendKey2(); w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Errorf("in-flight key: status = %d, Retry-After %q, want 409 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	// Брошенный запрос (сервер упал) перехватывается повтором.
+	if err := db.Model(&IdempotencyKey{}).Where("id = ?", inFlight.ID).
+		UpdateColumn("updated_at", time.Now().Add(-2*idempotencyLockTimeout)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if w := sendKey2(); w.Code != http.StatusBadRequest || runs != 3 {
+		t.Errorf("abandoned key: status = %d after %d runs, want the handler to run again", w.Code, runs)
+	}
+}
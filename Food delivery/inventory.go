@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// availableSQL - блюдо можно заказать: не снято вручную и остаток не закончился.
+const availableSQL = "NOT food_items.paused AND (food_items.stock IS NULL OR food_items.stock > 0)"
+
+var errSoldOut = errors.New("menu item is sold out")
+
+// AfterFind вычисляет SoldOut для ответов API. Available по умолчанию true,
+// расписание учитывает menuSchedule.apply там, где оно нужно.
+func (f *FoodItem) AfterFind(tx *gorm.DB) error {
+	f.SoldOut, f.Available = f.isSoldOut(), true
+	return nil
+}
+
+// isSoldOut - блюдо снято с продажи вручную ("86") или его остаток равен нулю.
+// Stock = nil - остаток не ведется.
+func (f *FoodItem) isSoldOut() bool {
+	return f.Paused || (f.Stock != nil && *f.Stock <= 0)
+}
+
+// hideSoldOut убирает из выборки закончившиеся блюда, если передан ?hide_sold_out=true.
+func hideSoldOut(q *gorm.DB, r *http.Request) *gorm.DB {
+	if hide, _ := strconv.ParseBool(r.URL.Query().Get("hide_sold_out")); hide {
+		return q.Where(availableSQL)
+	}
+	return q
+}
+
+// reserveStock списывает остатки блюд заказа в транзакции tx. Списание идет одним
+// условным UPDATE, поэтому два одновременных заказа не уйдут в минус: второй
+// получит errSoldOut. Блюда без учета остатков только проверяются на Paused.
+// Если остаток опустился до порога LowStockThreshold, админам уходит письмо.
+func reserveStock(tx *gorm.DB, lines []OrderLine) error {
+	quantities := map[uint]int{}
+	var ids []uint
+	for _, line := range lines {
+		if line.FoodItemID == nil {
+			continue
+		}
+		if _, seen := quantities[*line.FoodItemID]; !seen {
+			ids = append(ids, *line.FoodItemID)
+		}
+		quantities[*line.FoodItemID] += line.Quantity
+	}
+	// Одинаковый порядок блокировок у всех заказов - без взаимных блокировок.
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		quantity := quantities[id]
+		res := tx.Model(&FoodItem{}).
+			Where("id = ? AND NOT paused AND (stock IS NULL OR stock >= ?)", id, quantity).
+			Update("stock", gorm.Expr("stock - ?", quantity))
+		if res.Error != nil {
+			return res.Error
+		}
+		var item FoodItem
+		if err := tx.Select("id", "name", "stock", "paused", "low_stock_threshold").First(&item, id).Error; err != nil {
+			return err
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("%w: %s", errSoldOut, item.Name)
+		}
+		if item.Stock != nil && *item.Stock <= item.LowStockThreshold && *item.Stock+quantity > item.LowStockThreshold {
+			if err := enqueueLowStockAlert(tx, &item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseStock возвращает на склад quantities (блюдо -> штуки) в транзакции tx:
+// при отмене заказа и возврате по позициям. Блюда без учета остатков не трогаются.
+func releaseStock(tx *gorm.DB, quantities map[uint]int) error {
+	ids := make([]uint, 0, len(quantities))
+	for id, quantity := range quantities {
+		if quantity > 0 {
+			ids = append(ids, id)
+		}
+	}
+	// Тот же порядок блокировок, что и в reserveStock.
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		err := tx.Model(&FoodItem{}).
+			Where("id = ? AND stock IS NOT NULL", id).
+			Update("stock", gorm.Expr("stock + ?", quantities[id])).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseOrderStock возвращает на склад все еще не отмененные штуки заказа.
+func releaseOrderStock(tx *gorm.DB, orderID uint) error {
+	var lines []OrderLine
+	if err := tx.Select("food_item_id", "quantity", "cancelled_quantity").Where("order_id = ?", orderID).Find(&lines).Error; err != nil {
+		return err
+	}
+	quantities := map[uint]int{}
+	for _, line := range lines {
+		if line.FoodItemID != nil {
+			quantities[*line.FoodItemID] += line.Quantity - line.CancelledQuantity
+		}
+	}
+	return releaseStock(tx, quantities)
+}
+
+// releaseRefundedStock возвращает на склад штуки позиций частичного возврата.
+func releaseRefundedStock(tx *gorm.DB, refundLines []RefundLine) error {
+	if len(refundLines) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(refundLines))
+	for i, line := range refundLines {
+		ids[i] = line.OrderLineID
+	}
+	var lines []OrderLine
+	if err := tx.Select("id", "food_item_id").Where("id IN ?", ids).Find(&lines).Error; err != nil {
+		return err
+	}
+	items := make(map[uint]uint, len(lines))
+	for _, line := range lines {
+		if line.FoodItemID != nil {
+			items[line.ID] = *line.FoodItemID
+		}
+	}
+	quantities := map[uint]int{}
+	for _, line := range refundLines {
+		if itemID, ok := items[line.OrderLineID]; ok {
+			quantities[itemID] += line.Quantity
+		}
+	}
+	return releaseStock(tx, quantities)
+}
+
+// enqueueLowStockAlert пишет письмо о заканчивающемся блюде каждому админу.
+func enqueueLowStockAlert(tx *gorm.DB, item *FoodItem) error {
+	var admins []User
+	if err := tx.Where("role = ?", roleAdmin).Find(&admins).Error; err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		msg, err := renderEmail(emailLowStock, admin.Language, admin.Email, map[string]interface{}{
+			"Item": item,
+		})
+		if err != nil {
+			return err
+		}
+		if err := enqueueEmail(tx, emailLowStock, msg); err != nil {
+			return err
+		}
+	}
+	logger.WithField("food_item_id", item.ID).WithField("stock", *item.Stock).Warn("Menu item is low on stock")
+	return nil
+}
+
+// putInventory - PUT /menu/{id}/inventory {"stock", "paused", "low_stock_threshold"}.
+// Меняются только переданные поля. stock = null отключает учет остатков,
+// paused = true снимает блюдо с продажи.
+func putInventory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var input struct {
+		// RawMessage отличает отсутствующий stock от явного null.
+		Stock             json.RawMessage `json:"stock"`
+		Paused            *bool           `json:"paused"`
+		LowStockThreshold *int            `json:"low_stock_threshold"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	var stock *int
+	if input.Stock != nil {
+		if err := json.Unmarshal(input.Stock, &stock); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+	}
+	if (stock != nil && *stock < 0) || (input.LowStockThreshold != nil && *input.LowStockThreshold < 0) {
+		http.Error(w, "stock and low_stock_threshold must be non-negative", http.StatusBadRequest)
+		return
+	}
+	updates := map[string]interface{}{}
+	if input.Stock != nil {
+		updates["stock"] = stock
+	}
+	if input.Paused != nil {
+		updates["paused"] = *input.Paused
+	}
+	if input.LowStockThreshold != nil {
+		updates["low_stock_threshold"] = *input.LowStockThreshold
+	}
+	if len(updates) == 0 {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+	// Доступность - часть блюда, поэтому его ETag тоже меняется.
+	updates["version"] = gorm.Expr("version + 1")
+	res := db.Model(&FoodItem{}).Where("id = ?", id).Updates(updates)
+	if res.Error != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to update inventory", res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "Menu item not found", http.StatusNotFound)
+		return
+	}
+	var item FoodItem
+	if err := withMenuDetails(db).First(&item, id).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch menu item", err)
+		return
+	}
+	writeMenuItem(w, http.StatusOK, &item)
+}
+
+// getLowStock - GET /admin/inventory/low-stock: блюда с остатком не выше порога.
+func getLowStock(w http.ResponseWriter, r *http.Request) {
+	var items []FoodItem
+	err := db.Where("stock IS NOT NULL AND stock <= low_stock_threshold").
+		Order("stock, id").Find(&items).Error
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch inventory", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
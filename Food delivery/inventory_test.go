@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+func TestReserveStock(t *testing.T) {
+	openTestDB(t)
+	admin := User{Name: "Admin", Email: "admin@example.com", Role: roleAdmin, Language: "en"}
+	if err := db.Create(&admin).Error; err != nil {
+		t.Fatal(err)
+	}
+	tracked := createTestItem(t, FoodItem{Name: "Soup", Price: 500, Stock: intPtr(5), LowStockThreshold: 2})
+	untracked := createTestItem(t, FoodItem{Name: "Tea", Price: 200})
+	paused := createTestItem(t, FoodItem{Name: "Pie", Price: 300, Stock: intPtr(10)})
+	if err := db.Model(&paused).Update("paused", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	line := func(item FoodItem, qty int) OrderLine {
+		return OrderLine{FoodItemID: &item.ID, Name: item.Name, Quantity: qty}
+	}
+
+	tests := []struct {
+		name       string
+		lines      []OrderLine
+		wantErr    error
+		wantStock  int
+		wantAlerts int64
+	}{
+		{"untracked items are not counted", []OrderLine{line(untracked, 50)}, nil, 5, 0},
+		{"same item on two lines is summed", []OrderLine{line(tracked, 1), line(tracked, 1)}, nil, 3, 0},
+		{"crossing the threshold alerts once", []OrderLine{line(tracked, 1)}, nil, 2, 1},
+		{"more than left is sold out", []OrderLine{line(tracked, 3)}, errSoldOut, 2, 1},
+		{"paused item is sold out", []OrderLine{line(paused, 1)}, errSoldOut, 2, 1},
+		{"already below threshold does not alert again", []OrderLine{line(tracked, 2)}, nil, 0, 1},
+		{"zero stock is sold out", []OrderLine{line(tracked, 1)}, errSoldOut, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return reserveStock(tx, tt.lines)
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			var item FoodItem
+			if err := db.First(&item, tracked.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if *item.Stock != tt.wantStock {
+				t.Errorf("stock = %d, want %d", *item.Stock, tt.wantStock)
+			}
+			var alerts int64
+			db.Model(&OutboxEmail{}).Where("kind = ?", emailLowStock).Count(&alerts)
+			if alerts != tt.wantAlerts {
+				t.Errorf("low stock alerts = %d, want %d", alerts, tt.wantAlerts)
+			}
+		})
+	}
+}
+
+func TestReleaseStockOnRefundAndCancel(t *testing.T) {
+	openTestDB(t)
+	useCountingProvider(t)
+	tracked := createTestItem(t, FoodItem{Name: "Soup", Price: 500, Stock: intPtr(5)})
+	untracked := createTestItem(t, FoodItem{Name: "Tea", Price: 200})
+	order, user := createTestOrder(t, paymentCaptured,
+		OrderLine{FoodItemID: &tracked.ID, Name: tracked.Name, UnitPrice: 500, Quantity: 3, LineTotal: 1500},
+		OrderLine{FoodItemID: &untracked.ID, Name: untracked.Name, UnitPrice: 200, Quantity: 2, LineTotal: 400},
+	)
+	staff := &User{ID: user.ID, Role: roleStaff}
+	stock := func() *int {
+		t.Helper()
+		var item FoodItem
+		if err := db.First(&item, tracked.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return item.Stock
+	}
+
+	inputs := []refundLineInput{{OrderLineID: order.Lines[0].ID, Quantity: 1}}
+	if _, err := refundOrderLines(context.Background(), order, inputs, cancelOutOfStock, "", staff); err != nil {
+		t.Fatal(err)
+	}
+	if got := *stock(); got != 6 {
+		t.Errorf("stock after refund = %d, want 6", got)
+	}
+
+	if err := db.Preload("Lines").First(order, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := cancelOrder(context.Background(), order, staff, cancelCustomerRequest, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Возвращаются только не отмененные ранее штуки.
+	if got := *stock(); got != 8 {
+		t.Errorf("stock after cancel = %d, want 8", got)
+	}
+	var item FoodItem
+	if err := db.First(&item, untracked.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if item.Stock != nil {
+		t.Errorf("untracked item stock = %d, want nil", *item.Stock)
+	}
+}
+
+func TestPutInventoryUpdatesOnlyPresentFields(t *testing.T) {
+	openTestDB(t)
+	item := createTestItem(t, FoodItem{Name: "Soup", Price: 500, Stock: intPtr(7), LowStockThreshold: 3})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   int
+		wantStock  *int
+		wantPaused bool
+	}{
+		{"pause keeps stock", `{"paused": true}`, http.StatusOK, intPtr(7), true},
+		{"threshold keeps stock and pause", `{"low_stock_threshold": 1}`, http.StatusOK, intPtr(7), true},
+		{"stock keeps pause", `{"stock": 4}`, http.StatusOK, intPtr(4), true},
+		{"unpause", `{"paused": false}`, http.StatusOK, intPtr(4), false},
+		{"null stock turns tracking off", `{"stock": null}`, http.StatusOK, nil, false},
+		{"empty body is rejected", `{}`, http.StatusBadRequest, nil, false},
+		{"negative stock is rejected", `{"stock": -1}`, http.StatusBadRequest, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/menu/1/inventory", strings.NewReader(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"id": strconv.Itoa(int(item.ID))})
+			rec := httptest.NewRecorder()
+			putInventory(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			var got FoodItem
+			if err := db.First(&got, item.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			if (got.Stock == nil) != (tt.wantStock == nil) || (got.Stock != nil && *got.Stock != *tt.wantStock) {
+				t.Errorf("stock = %v, want %v", got.Stock, tt.wantStock)
+			}
+			if got.Paused != tt.wantPaused {
+				t.Errorf("paused = %v, want %v", got.Paused, tt.wantPaused)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const keyLockTimeout = 10 * time.Second
+
+// lockKeyFile берет эксклюзивную блокировку файла ключей: создает path+".lock"
+// с O_EXCL и ждет, пока его не удалит другой процесс.
+func lockKeyFile(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(keyLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("key file is locked, remove %s if no rotation is running", lock)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
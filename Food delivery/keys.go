@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultKeyFile    = "jwt_keys.json"
+	keyReloadInterval = 30 * time.Second
+	// keyRetention - сколько старый ключ нужен для проверки после ротации: самый
+	// долгоживущий JWT (ссылка подтверждения email) подписан им не позже момента ротации.
+	keyRetention = confirmTokenTTL
+)
+
+// signingKey - один HMAC-ключ для подписи JWT, идентифицируется заголовком kid.
+type signingKey struct {
+	ID        string    `json:"id"`
+	Secret    []byte    `json:"secret"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type keyFile struct {
+	Active string       `json:"active"`
+	Keys   []signingKey `json:"keys"`
+}
+
+// keyRing хранит активный ключ подписи и ключи, которыми еще можно проверять
+// токены, выданные до ротации.
+type keyRing struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	active  string
+	keys    map[string]signingKey
+}
+
+var jwtKeys *keyRing
+
+var errUnknownKey = errors.New("unknown signing key")
+
+func newKeyID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func generateSigningKey() (signingKey, error) {
+	secret := make([]byte, 32) // 256-битный ключ
+	if _, err := rand.Read(secret); err != nil {
+		return signingKey{}, err
+	}
+	return signingKey{ID: newKeyID(), Secret: secret, CreatedAt: time.Now().UTC()}, nil
+}
+
+// loadKeyRing читает ключи из jwt.secret или из файла ключей. Если файла нет,
+// он создается с одним новым ключом, чтобы токены переживали перезапуск.
+func loadKeyRing(path, secret string) (*keyRing, error) {
+	ring := &keyRing{path: path, keys: map[string]signingKey{}}
+	if secret != "" {
+		key := signingKey{ID: "env", Secret: []byte(secret)}
+		ring.active = key.ID
+		ring.keys[key.ID] = key
+		ring.path = ""
+		return ring, nil
+	}
+
+	if err := createKeyFile(path); err != nil {
+		return nil, err
+	}
+	if err := ring.reload(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// createKeyFile создает файл ключей с одним новым ключом, если его еще нет. Под
+// блокировкой, чтобы одновременно стартующие экземпляры не создали разные ключи.
+func createKeyFile(path string) error {
+	unlock, err := lockKeyFile(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	key, err := generateSigningKey()
+	if err != nil {
+		return err
+	}
+	if err := writeKeyFile(path, keyFile{Active: key.ID, Keys: []signingKey{key}}); err != nil {
+		return err
+	}
+	logger.WithField("kid", key.ID).Info("Created new JWT key file")
+	return nil
+}
+
+func readKeyFile(path string) (keyFile, error) {
+	var kf keyFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return kf, err
+	}
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return kf, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return kf, nil
+}
+
+func writeKeyFile(path string, kf keyFile) error {
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (k *keyRing) reload() error {
+	if k.path == "" {
+		return nil
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	kf, err := readKeyFile(k.path)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]signingKey, len(kf.Keys))
+	for _, key := range kf.Keys {
+		if key.ID == "" || len(key.Secret) < 32 {
+			return fmt.Errorf("invalid key %q in %s", key.ID, k.path)
+		}
+		keys[key.ID] = key
+	}
+	if _, ok := keys[kf.Active]; !ok {
+		return fmt.Errorf("active key %q not found in %s", kf.Active, k.path)
+	}
+
+	k.mu.Lock()
+	k.active = kf.Active
+	k.keys = keys
+	k.modTime = info.ModTime()
+	k.mu.Unlock()
+	return nil
+}
+
+// watch перечитывает файл ключей, когда его меняет команда rotate-keys
+// из другого процесса.
+func (k *keyRing) watch() {
+	if k.path == "" {
+		return
+	}
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		info, err := os.Stat(k.path)
+		if err != nil {
+			logger.WithField("error", err).Warn("Failed to stat JWT key file")
+			continue
+		}
+		k.mu.RLock()
+		changed := info.ModTime().After(k.modTime)
+		k.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := k.reload(); err != nil {
+			logger.WithField("error", err).Error("Failed to reload JWT keys")
+			continue
+		}
+		logger.WithField("kid", k.activeKey().ID).Info("JWT keys reloaded")
+	}
+}
+
+func (k *keyRing) activeKey() signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[k.active]
+}
+
+func (k *keyRing) lookup(kid string) (signingKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if kid == "" {
+		// Токены без kid выдавались до появления ротации.
+		return k.keys[k.active], nil
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return signingKey{}, errUnknownKey
+	}
+	return key, nil
+}
+
+// pruneKeys убирает ключи, которыми уже не может быть подписан ни один живой токен.
+// keys - от нового к старому; ключ перестал подписывать, когда создан следующий за
+// ним, и нужен еще retention после этого. Активный ключ остается всегда.
+func pruneKeys(keys []signingKey, now time.Time, retention time.Duration) []signingKey {
+	for i := 1; i < len(keys); i++ {
+		if now.Sub(keys[i-1].CreatedAt) >= retention {
+			return keys[:i]
+		}
+	}
+	return keys
+}
+
+// rotate создает новый активный ключ и оставляет старые ключи, пока ими могут быть
+// подписаны действующие токены (keyRetention). Файл меняется под блокировкой, чтобы
+// одновременная ротация в нескольких экземплярах не потеряла ключ.
+func (k *keyRing) rotate() (signingKey, error) {
+	if k.path == "" {
+		return signingKey{}, errors.New("keys are configured via jwt.secret and cannot be rotated")
+	}
+	unlock, err := lockKeyFile(k.path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	defer unlock()
+	kf, err := readKeyFile(k.path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	key, err := generateSigningKey()
+	if err != nil {
+		return signingKey{}, err
+	}
+	kf.Keys = pruneKeys(append([]signingKey{key}, kf.Keys...), key.CreatedAt, keyRetention)
+	kf.Active = key.ID
+	if err := writeKeyFile(k.path, kf); err != nil {
+		return signingKey{}, err
+	}
+	if err := k.reload(); err != nil {
+		return signingKey{}, err
+	}
+	return key, nil
+}
+
+func (k *keyRing) keyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// rotateKeysCommand - `go run . rotate-keys`. Запущенный сервер подхватит новый
+// ключ при следующей проверке файла.
+func rotateKeysCommand() error {
+	ring, err := loadKeyRing(cfg.JWT.KeyFile, "")
+	if err != nil {
+		return err
+	}
+	key, err := ring.rotate()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("New active JWT key: %s (created %s)\n", key.ID, key.CreatedAt.Format(time.RFC3339))
+	return nil
+}
+
+func initKeys(c JWTConfig) {
+	ring, err := loadKeyRing(c.KeyFile, c.Secret)
+	if err != nil {
+		logger.WithField("error", err).Fatal("Failed to load JWT keys")
+	}
+	jwtKeys = ring
+	go jwtKeys.watch()
+}
+
+func rotateKeys(w http.ResponseWriter, r *http.Request) {
+	key, err := jwtKeys.rotate()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to rotate keys", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active":     key.ID,
+		"created_at": key.CreatedAt,
+		"keys":       jwtKeys.keyIDs(),
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPruneKeys(t *testing.T) {
+	now := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	key := func(id string, age time.Duration) signingKey {
+		return signingKey{ID: id, CreatedAt: now.Add(-age)}
+	}
+	tests := []struct {
+		name string
+		keys []signingKey
+		want []string
+	}{
+		{"only active", []signingKey{key("a", 0)}, []string{"a"}},
+		{"rapid rotations keep every key", []signingKey{key("a", 0), key("b", time.Minute), key("c", 2*time.Minute), key("d", 3*time.Minute)}, []string{"a", "b", "c", "d"}},
+		{"retired key still within retention", []signingKey{key("a", 23*time.Hour), key("b", 48*time.Hour)}, []string{"a", "b"}},
+		{"retired key past retention", []signingKey{key("a", 0), key("b", 25*time.Hour), key("c", 72*time.Hour)}, []string{"a", "b"}},
+		{"old active key is kept", []signingKey{key("a", 30*24*time.Hour)}, []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pruneKeys(tt.keys, now, 24*time.Hour)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d keys, want %v", len(got), tt.want)
+			}
+			for i, id := range tt.want {
+				if got[i].ID != id {
+					t.Errorf("key %d = %s, want %s", i, got[i].ID, id)
+				}
+			}
+		})
+	}
+}
+
+func TestRotateConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jwt_keys.json")
+	const rotations = 16
+	rings := make([]*keyRing, rotations)
+	for i := range rings {
+		ring, err := loadKeyRing(path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rings[i] = ring
+	}
+
+	var wg sync.WaitGroup
+	for _, ring := range rings {
+		wg.Add(1)
+		go func(ring *keyRing) {
+			defer wg.Done()
+			if _, err := ring.rotate(); err != nil {
+				t.Error(err)
+			}
+		}(ring)
+	}
+	wg.Wait()
+
+	kf, err := readKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kf.Keys) != rotations+1 {
+		t.Fatalf("key file has %d keys, want %d", len(kf.Keys), rotations+1)
+	}
+	if kf.Active != kf.Keys[0].ID {
+		t.Errorf("active key %s is not the newest %s", kf.Active, kf.Keys[0].ID)
+	}
+}
+
+func TestConfirmEmailRequiresConfirmPurpose(t *testing.T) {
+	useTestKeys(t)
+	for _, purpose := range []string{"", purposeAccess, purposeStream} {
+		t.Run("purpose "+strconv.Quote(purpose), func(t *testing.T) {
+			token, err := issueToken(&Claims{Email: "user@example.com", Purpose: purpose}, confirmTokenTTL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			confirmEmail(w, httptest.NewRequest(http.MethodGet, "/confirm?token="+token, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", w.Code)
+			}
+		})
+	}
+}
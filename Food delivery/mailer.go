@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Email - письмо, независимое от способа доставки.
+type Email struct {
+	From        string
+	To          []string
+	Subject     string
+	HTMLBody    string
+	TextBody    string
+	Attachments []Attachment
+}
+
+// Attachment - вложение письма. Содержимое хранится целиком, а не путем к файлу,
+// чтобы письмо из outbox можно было отправить позже и с другого сервера.
+type Attachment struct {
+	Name string
+	Data []byte
+}
+
+// attachmentName оставляет от имени загруженного файла только последнюю часть пути
+// без управляющих символов: имя приходит от клиента и попадает в заголовок письма.
+func attachmentName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name = strings.TrimSpace(name); name == "" || name == "." || name == ".." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+// Mailer отправляет письма. Реализации: SMTP для продакшена, файлы .eml для
+// локальной разработки и память для тестов.
+type Mailer interface {
+	Send(msg *Email) error
+}
+
+var mailer Mailer
+
+func buildMessage(msg *Email) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", msg.From)
+	m.SetHeader("To", msg.To...)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetDateHeader("Date", time.Now())
+	switch {
+	case msg.TextBody != "" && msg.HTMLBody != "":
+		m.SetBody("text/plain", msg.TextBody)
+		m.AddAlternative("text/html", msg.HTMLBody)
+	case msg.HTMLBody != "":
+		m.SetBody("text/html", msg.HTMLBody)
+	default:
+		m.SetBody("text/plain", msg.TextBody)
+	}
+	for _, a := range msg.Attachments {
+		data := a.Data
+		m.Attach(a.Name, gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		}))
+	}
+	return m
+}
+
+type smtpMailer struct {
+	dialer *gomail.Dialer
+}
+
+func newSMTPMailer(host string, port int, username, password string, insecureSkipVerify bool) *smtpMailer {
+	d := gomail.NewDialer(host, port, username, password)
+	if insecureSkipVerify {
+		d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &smtpMailer{dialer: d}
+}
+
+func (s *smtpMailer) Send(msg *Email) error {
+	return s.dialer.DialAndSend(buildMessage(msg))
+}
+
+// fileMailer складывает письма в каталог в виде .eml файлов, их можно открыть
+// любым почтовым клиентом.
+type fileMailer struct {
+	dir string
+}
+
+func newFileMailer(dir string) (*fileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fileMailer{dir: dir}, nil
+}
+
+func (f *fileMailer) Send(msg *Email) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), newKeyID())
+	path := filepath.Join(f.dir, name)
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := buildMessage(msg).WriteTo(out); err != nil {
+		return err
+	}
+	logger.WithField("path", path).Info("Email written to file")
+	return nil
+}
+
+// memoryMailer запоминает отправленные письма.
+type memoryMailer struct {
+	mu   sync.Mutex
+	sent []Email
+}
+
+func (m *memoryMailer) Send(msg *Email) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, *msg)
+	return nil
+}
+
+func (m *memoryMailer) Messages() []Email {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Email(nil), m.sent...)
+}
+
+func (m *memoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = nil
+}
+
+// mailFrom - адрес отправителя для всех писем сервиса.
+func mailFrom() string {
+	return cfg.Mail.From
+}
+
+// publicBaseURL - адрес сервера, на который ведут ссылки в письмах.
+func publicBaseURL() string {
+	return cfg.Server.PublicBaseURL
+}
+
+// supportInbox - куда пересылаются обращения в поддержку.
+func supportInbox() string {
+	if cfg.Mail.SupportInbox != "" {
+		return cfg.Mail.SupportInbox
+	}
+	return cfg.Mail.From
+}
+
+// newMailer выбирает реализацию по mail.backend: smtp, file или memory.
+func newMailer(c MailConfig) (Mailer, error) {
+	switch c.Backend {
+	case "smtp":
+		return newSMTPMailer(c.SMTP.Host, c.SMTP.Port, c.SMTP.User, c.SMTP.Password, c.SMTP.InsecureSkipVerify), nil
+	case "file":
+		return newFileMailer(c.Dir)
+	case "memory":
+		return &memoryMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", c.Backend)
+	}
+}
+
+func initMailer(c MailConfig) {
+	m, err := newMailer(c)
+	if err != nil {
+		logger.WithField("error", err).Fatal("Failed to initialize mailer")
+	}
+	mailer = m
+	logger.WithField("backend", c.Backend).Info("Mailer initialized")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\receipt.pdf`, "receipt.pdf"},
+		{"/tmp/", "tmp"},
+		{"evil\r\nBcc: x@example.com.txt", "evilBcc: x@example.com.txt"},
+		{"", "attachment"},
+		{"..", "attachment"},
+		{"/", "attachment"},
+	}
+	for _, tt := range tests {
+		if got := attachmentName(tt.in); got != tt.want {
+			t.Errorf("attachmentName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBuildMessageAttachesContent(t *testing.T) {
+	msg := &Email{
+		From:        "shop@example.com",
+		To:          []string{"support@example.com"},
+		Subject:     "Support Request",
+		TextBody:    "hello",
+		Attachments: []Attachment{{Name: "note.txt", Data: []byte("attached text")}},
+	}
+	var out bytes.Buffer
+	if _, err := buildMessage(msg).WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	raw := out.String()
+	if !strings.Contains(raw, `filename="note.txt"`) {
+		t.Errorf("attachment name missing from message:\n%s", raw)
+	}
+	if !strings.Contains(raw, "YXR0YWNoZWQgdGV4dA==") { // base64("attached text")
+		t.Errorf("attachment content missing from message:\n%s", raw)
+	}
+}
+
+func TestNewMailer(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		backend string
+		want    Mailer
+	}{
+		{"smtp", &smtpMailer{}},
+		{"file", &fileMailer{}},
+		{"memory", &memoryMailer{}},
+	}
+	for _, tt := range tests {
+		m, err := newMailer(MailConfig{Backend: tt.backend, Dir: dir, SMTP: SMTPConfig{Host: "localhost", Port: 25}})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.backend, err)
+		}
+		if got, want := fmt.Sprintf("%T", m), fmt.Sprintf("%T", tt.want); got != want {
+			t.Errorf("backend %s: got %s, want %s", tt.backend, got, want)
+		}
+	}
+	if _, err := newMailer(MailConfig{Backend: "pigeon"}); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
+
+func TestFileMailerWritesEml(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m, err := newFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Email{From: "shop@example.com", To: []string{"user@example.com"}, Subject: "Welcome", TextBody: "hello"}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("%d .eml files written, want 2", len(files))
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Subject: Welcome", "To: user@example.com", "hello"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("%q missing from message:\n%s", want, raw)
+		}
+	}
+}
@@ -1,50 +1,38 @@
 package main
 
 import (
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
-	"crypto/rand"
-
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/time/rate"
-	"gopkg.in/gomail.v2"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-func generateSecretKey() []byte {
-	key := make([]byte, 32) // 256-битный ключ
-	_, err := rand.Read(key)
-	if err != nil {
-		log.Fatal("Failed to generate secret key:", err)
-	}
-	return key
-}
-
-var jwtKey = generateSecretKey()
-
 var (
 	db      *gorm.DB
 	logger  = logrus.New()
-	limiter = rate.NewLimiter(rate.Inf, 50)
+	limiter = cfg.RateLimit.Global.limiter()
 )
 
 type Claims struct {
-	Email string `json:"email"`
+	Email     string `json:"email"`
+	Purpose   string `json:"purpose,omitempty"`
+	SessionID uint   `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -58,35 +46,70 @@ type SupportMessage struct {
 }
 
 type User struct {
-	ID             uint       `json:"id" gorm:"primaryKey"`
-	Name           string     `json:"name"`
-	Email          string     `json:"email" gorm:"unique;not null"`
-	Phone          string     `json:"phone"`
-	Password       string     `json:"password"`
-	Role           string     `json:"role"`
-	Cart           []FoodItem `json:"cart" gorm:"many2many:user_cart_items;constraint:OnDelete:CASCADE"`
-	Orders         []Order    `json:"orders" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
-	EmailConfirmed bool       `json:"email_confirmed"`
+	ID             uint    `json:"id" gorm:"primaryKey"`
+	Name           string  `json:"name"`
+	Email          string  `json:"email" gorm:"unique;not null"`
+	Phone          string  `json:"phone"`
+	Password       string  `json:"-"` // bcrypt-хеш, наружу не отдается
+	Role           string  `json:"role"`
+	Orders         []Order `json:"orders" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	EmailConfirmed bool    `json:"email_confirmed"`
+	Language       string  `json:"language" gorm:"default:ru"`
+	// RestaurantID - ресторан сотрудника; nil - сотрудник видит заказы всех ресторанов.
+	RestaurantID *uint `json:"restaurant_id,omitempty"`
 }
 
 type FoodItem struct {
-	ID          uint    `json:"id" gorm:"primaryKey"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Category    string  `json:"category"`
-	PictureURL  string  `json:"picture_url"`
-	Orders      []Order `json:"-" gorm:"many2many:order_food_items;constraint:OnDelete:CASCADE;"`
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       Money  `json:"price"`
+	Currency    string `json:"currency" gorm:"not null;default:USD"`
+	Category    string `json:"category"`
+	PictureURL  string `json:"picture_url"`
+	// RestaurantID - ресторан, в меню которого блюдо; 0 при создании - ресторан по умолчанию.
+	RestaurantID uint `json:"restaurant_id" gorm:"index;not null"`
+	// Version растет с каждой правкой; ETag блюда строится из нее и тела ответа (encodeMenuItem).
+	Version   int       `json:"version" gorm:"not null;default:1"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Stock - остаток в штуках, nil - не ведется. Paused - блюдо снято с продажи
+	// вручную. SoldOut вычисляется при загрузке, см. inventory.go.
+	Stock             *int `json:"stock"`
+	Paused            bool `json:"paused" gorm:"not null;default:false"`
+	LowStockThreshold int  `json:"low_stock_threshold" gorm:"not null;default:5"`
+	SoldOut           bool `json:"sold_out" gorm:"-"`
+	// Available - блюдо продается сейчас по расписанию (см. availability.go);
+	// иначе AvailableFrom - когда откроется ближайшее окно.
+	Available     bool       `json:"available" gorm:"-"`
+	AvailableFrom *time.Time `json:"available_from,omitempty" gorm:"-"`
+	// Variants - размеры со своей ценой и артикулом, см. variants.go.
+	Variants []ItemVariant `json:"variants,omitempty" gorm:"foreignKey:FoodItemID;constraint:OnDelete:CASCADE"`
+	// OptionGroups - модификаторы блюда (соус, добавки), см. modifiers.go.
+	OptionGroups []OptionGroup `json:"option_groups,omitempty" gorm:"foreignKey:FoodItemID;constraint:OnDelete:CASCADE"`
 }
 
 type Order struct {
-	ID        uint       `json:"id" gorm:"primaryKey"`
-	Customer  string     `json:"customer"`
-	Address   string     `json:"address"`
-	Total     float64    `json:"total"`
-	FoodItems []FoodItem `json:"food_items" gorm:"many2many:order_food_items;constraint:OnDelete:CASCADE"`
-	UserID    uint       `json:"user_id"`
-	User      User       `json:"-" gorm:"foreignKey:UserID;references:ID"`
+	ID              uint        `json:"id" gorm:"primaryKey"`
+	Customer        string      `json:"customer"`
+	Address         string      `json:"address"`
+	Subtotal        Money       `json:"subtotal"`
+	Discount        Money       `json:"discount"`
+	Tax             Money       `json:"tax"`
+	DeliveryFee     Money       `json:"delivery_fee"`
+	Total           Money       `json:"total"`
+	Currency        string      `json:"currency" gorm:"not null;default:USD"`
+	PromoCode       string      `json:"promo_code,omitempty"`
+	Status          string      `json:"status" gorm:"not null;default:placed"`
+	PaymentStatus   string      `json:"payment_status" gorm:"not null;default:unpaid"`
+	StatusUpdatedAt time.Time   `json:"status_updated_at"`
+	CancelReason    string      `json:"cancel_reason,omitempty"`
+	RestaurantID    uint        `json:"restaurant_id" gorm:"index"`
+	Lines           []OrderLine `json:"lines" gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE"`
+	FoodItems       []FoodItem  `json:"food_items" gorm:"-"` // заполняется из Lines для старых клиентов
+	UserID          uint        `json:"user_id"`
+	User            User        `json:"-" gorm:"foreignKey:UserID;references:ID"`
+	// Заказы не удаляются физически: DELETE /orders/{id} только прячет их из списков.
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
 }
 
 func initLogger() {
@@ -120,7 +143,23 @@ func getFilteredSortedPaginatedItems(w http.ResponseWriter, r *http.Request) {
 	offset := (page - 1) * limit
 
 	// Базовый запрос
-	query := db.Model(&FoodItem{})
+	now := time.Now()
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		http.Error(w, "Failed to fetch items", http.StatusInternalServerError)
+		return
+	}
+	query, err := applySchedule(hideSoldOut(db.Model(&FoodItem{}), r), r, schedule, now)
+	if err != nil {
+		http.Error(w, "Failed to fetch items", http.StatusInternalServerError)
+		return
+	}
+	restaurantID, err := restaurantFromRequest(r) // /restaurants/{rid}/items или ?restaurant_id=
+	if err != nil {
+		http.Error(w, "Invalid restaurant_id", http.StatusBadRequest)
+		return
+	}
+	query = byRestaurant(query, restaurantID)
 
 	// Применение фильтрации
 	if filter != "" {
@@ -129,12 +168,13 @@ func getFilteredSortedPaginatedItems(w http.ResponseWriter, r *http.Request) {
 
 	// Применение сортировки
 	if sort != "" {
-		allowedSortFields := map[string]bool{"name": true, "price": true, "category": true}
-		if allowedSortFields[sort] {
+		// У блюд с размерами цена - самый дешевый вариант.
+		allowedSortFields := map[string]string{"name": "name", "price": effectivePriceSQL, "category": "category"}
+		if column, ok := allowedSortFields[sort]; ok {
 			if sortDir == "desc" {
-				query = query.Order(fmt.Sprintf("%s desc", sort)) // Сортировка по убыванию
+				query = query.Order(fmt.Sprintf("%s desc", column)) // Сортировка по убыванию
 			} else {
-				query = query.Order(fmt.Sprintf("%s asc", sort)) // Сортировка по возрастанию
+				query = query.Order(fmt.Sprintf("%s asc", column)) // Сортировка по возрастанию
 			}
 		} else {
 			http.Error(w, "Invalid sort parameter", http.StatusBadRequest)
@@ -151,10 +191,11 @@ func getFilteredSortedPaginatedItems(w http.ResponseWriter, r *http.Request) {
 
 	// Получение данных
 	var items []FoodItem
-	if err := query.Find(&items).Error; err != nil {
+	if err := withMenuDetails(query).Find(&items).Error; err != nil {
 		http.Error(w, "Failed to fetch items", http.StatusInternalServerError)
 		return
 	}
+	schedule.apply(items, now)
 
 	// Формирование ответа
 	response := map[string]interface{}{
@@ -189,7 +230,10 @@ func sendSupportMessage(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("Received email: %s, message: %s", email, message)
 
-	var attachmentPaths []string
+	// Вложения уходят в outbox вместе с письмом; на диск под именем от клиента
+	// ничего не пишется.
+	var attachments []Attachment
+	var attachmentNames []string
 	files := r.MultipartForm.File["attachments"]
 	for _, fileHeader := range files {
 		file, err := fileHeader.Open()
@@ -198,81 +242,78 @@ func sendSupportMessage(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to process attachment", http.StatusInternalServerError)
 			return
 		}
-		defer file.Close()
-
-		tempPath := filepath.Join(os.TempDir(), fileHeader.Filename)
-
-		out, err := os.Create(tempPath)
-		if err != nil {
-			log.Println("Failed to save attachment:", err)
-			http.Error(w, "Failed to save attachment", http.StatusInternalServerError)
-			return
-		}
-		defer out.Close()
-		_, err = io.Copy(out, file)
+		data, err := io.ReadAll(file)
+		file.Close()
 		if err != nil {
-			log.Println("Failed to write attachment to file:", err)
-			http.Error(w, "Failed to save attachment", http.StatusInternalServerError)
+			log.Println("Failed to read attachment:", err)
+			http.Error(w, "Failed to process attachment", http.StatusInternalServerError)
 			return
 		}
-		attachmentPaths = append(attachmentPaths, tempPath)
+		name := attachmentName(fileHeader.Filename)
+		attachments = append(attachments, Attachment{Name: name, Data: data})
+		attachmentNames = append(attachmentNames, name)
 	}
-	log.Printf("Attachments saved: %v", attachmentPaths)
+	log.Printf("Attachments received: %v", attachmentNames)
 
 	newMessage := SupportMessage{
 		Email:       email,
 		Message:     message,
-		Attachments: strings.Join(attachmentPaths, ","),
+		Attachments: strings.Join(attachmentNames, ","),
 	}
-	if err := db.Create(&newMessage).Error; err != nil {
-		log.Println("Failed to save message in database:", err)
+	supportEmail := &Email{
+		To:          []string{supportInbox()},
+		Subject:     "Support Request",
+		TextBody:    fmt.Sprintf("Message from %s:\n\n%s", email, message),
+		Attachments: attachments,
+	}
+	lang := requestLanguage(r)
+	var sender User
+	if db.Where("email = ?", email).First(&sender).Error == nil && sender.Language != "" {
+		lang = sender.Language
+	}
+	ack, err := renderEmail(emailSupportAck, lang, email, map[string]interface{}{"Message": message})
+	if err != nil {
+		log.Println("Failed to render support acknowledgement:", err)
 		http.Error(w, "Failed to save message", http.StatusInternalServerError)
 		return
 	}
-	log.Println("Message saved in database")
-
-	from := "turzhanovdanial@gmail.com"
-	password := "dpyr fkuf jocf bcam" //нужно заполнить
-	to := "turzhanovdanial@gmail.com" //нужно заполнить
-	m := gomail.NewMessage()
-	m.SetHeader("From", from)
-	m.SetHeader("To", to)
-	m.SetHeader("Subject", "Support Request")
-	m.SetBody("text/plain", fmt.Sprintf("Message from %s:\n\n%s", email, message))
 
-	for _, path := range attachmentPaths {
-		m.Attach(path)
-	}
-
-	d := gomail.NewDialer("smtp.gmail.com", 587, from, password)
-	if err := d.DialAndSend(m); err != nil {
-		log.Println("Failed to send email:", err)
-		http.Error(w, "Failed to send email", http.StatusInternalServerError)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newMessage).Error; err != nil {
+			return err
+		}
+		if err := enqueueEmail(tx, emailSupport, supportEmail); err != nil {
+			return err
+		}
+		return enqueueEmail(tx, emailSupportAck, ack)
+	})
+	if err != nil {
+		log.Println("Failed to save message in database:", err)
+		http.Error(w, "Failed to save message", http.StatusInternalServerError)
 		return
 	}
-	log.Println("Email sent successfully")
+	log.Println("Message saved in database, email queued")
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Message sent successfully"))
 }
 
-func initDatabase() {
+func connectDatabase(c DatabaseConfig) {
 	var err error
-	dsn := "host=localhost user=postgres password=Nurlan25 dbname=delivery port=27030 sslmode=disable"
-	db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	// TranslateError превращает ошибки уникальности в gorm.ErrDuplicatedKey.
+	db, err = gorm.Open(postgres.Open(c.DSN), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	fmt.Println("Database connected!")
-	err = db.AutoMigrate(&User{}, &FoodItem{}, &Order{})
-	if err != nil {
-		log.Fatal("Failed to auto-migrate database:", err)
-	}
-	fmt.Println("Database migrated (tables ensured)!")
-	err = db.AutoMigrate(&SupportMessage{})
-	if err != nil {
-		log.Fatal("Failed to auto-migrate SupportMessage:", err)
+}
+
+func initDatabase(c DatabaseConfig) {
+	connectDatabase(c)
+	if err := checkSchemaVersion(); err != nil {
+		log.Fatal("Schema version mismatch: ", err)
 	}
+	fmt.Println("Database schema is up to date!")
 
 	var count int64
 	db.Model(&FoodItem{}).Count(&count)
@@ -282,7 +323,23 @@ func initDatabase() {
 }
 func getFilteredFoodItems(w http.ResponseWriter, r *http.Request) {
 	var foodItems []FoodItem
-	query := db.Model(&FoodItem{})
+	now := time.Now()
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		http.Error(w, "Ошибка загрузки еды", http.StatusInternalServerError)
+		return
+	}
+	query, err := applySchedule(db.Model(&FoodItem{}), r, schedule, now)
+	if err != nil {
+		http.Error(w, "Ошибка загрузки еды", http.StatusInternalServerError)
+		return
+	}
+	restaurantID, err := restaurantFromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid restaurant_id", http.StatusBadRequest)
+		return
+	}
+	query = byRestaurant(query, restaurantID)
 	category := r.URL.Query().Get("category")
 	minPrice := r.URL.Query().Get("minPrice")
 	maxPrice := r.URL.Query().Get("maxPrice")
@@ -299,8 +356,8 @@ func getFilteredFoodItems(w http.ResponseWriter, r *http.Request) {
 		query = query.Where("category = ?", category)
 	}
 	if minPrice != "" {
-		if min, err := strconv.ParseFloat(minPrice, 64); err == nil {
-			query = query.Where("price >= ?", min)
+		if min, err := parseMoney(minPrice); err == nil {
+			query = query.Where(effectivePriceSQL+" >= ?", min)
 		} else {
 			http.Error(w, "Invalid minPrice value", http.StatusBadRequest)
 			return
@@ -308,8 +365,8 @@ func getFilteredFoodItems(w http.ResponseWriter, r *http.Request) {
 	}
 	if maxPrice != "" {
 
-		if max, err := strconv.ParseFloat(maxPrice, 64); err == nil {
-			query = query.Where("price <= ?", max)
+		if max, err := parseMoney(maxPrice); err == nil {
+			query = query.Where(effectivePriceSQL+" <= ?", max)
 		} else {
 			http.Error(w, "Invalid maxPrice value", http.StatusBadRequest)
 			return
@@ -325,10 +382,11 @@ func getFilteredFoodItems(w http.ResponseWriter, r *http.Request) {
 	offset := (page - 1) * limit
 	query = query.Offset(offset).Limit(limit)
 
-	if err := query.Find(&foodItems).Error; err != nil {
+	if err := withMenuDetails(query).Find(&foodItems).Error; err != nil {
 		http.Error(w, "Ошибка загрузки еды", http.StatusInternalServerError)
 		return
 	}
+	schedule.apply(foodItems, now)
 
 	response := map[string]interface{}{
 		"data":  foodItems,
@@ -342,29 +400,13 @@ func getFilteredFoodItems(w http.ResponseWriter, r *http.Request) {
 }
 
 func checkAuth(w http.ResponseWriter, r *http.Request) {
-	tokenHeader := r.Header.Get("Authorization")
-	if tokenHeader == "" {
+	user, ok := userFromContext(r.Context())
+	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	tokenString := strings.TrimPrefix(tokenHeader, "Bearer ")
-	claims := &Claims{}
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	var user User
-	if err := db.Where("email = ?", claims.Email).First(&user).Error; err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
-		return
-	}
-
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(user)
 }
 
@@ -380,8 +422,8 @@ func rateLimitMiddleware(next http.Handler) http.Handler {
 }
 
 var (
-	generalLimiter = rate.NewLimiter(1, 5)   // 1 запрос в секунду, максимум 5 одновременно
-	orderLimiter   = rate.NewLimiter(0.5, 2) // 1 запрос каждые 2 секунды, максимум 2 одновременно
+	orderLimiter         = cfg.RateLimit.Order.limiter()
+	passwordResetLimiter = cfg.RateLimit.PasswordReset.limiter()
 )
 
 func rateLimitByRouteMiddleware(limiter *rate.Limiter, next http.Handler) http.Handler {
@@ -397,57 +439,86 @@ func rateLimitByRouteMiddleware(limiter *rate.Limiter, next http.Handler) http.H
 
 func seedMenu() {
 	items := []FoodItem{
-		{Name: "Classic Burger", Description: "Juicy beef patty with fresh lettuce, tomato, and our special sauce", Price: 9.99, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1568901346375-23c9450c58cd?auto=format&fit=crop&w=1170&q=80"},
-		{Name: "Margherita Pizza", Description: "Traditional Italian pizza with tomato sauce, mozzarella, and basil", Price: 12.99, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1604068549290-dea0e4a305ca?auto=format&fit=crop&w=1074&q=80"},
-		{Name: "Caesar Salad", Description: "Crisp romaine lettuce, croutons, and parmesan cheese with Caesar dressing", Price: 7.99, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1550304943-4f24f54ddde9?auto=format&fit=crop&w=1170&q=80"},
-		{Name: "Chicken Wings", Description: "Crispy chicken wings tossed in your choice of sauce", Price: 8.99, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1567620832903-9fc6debc209f?auto=format&fit=crop&w=1080&q=80"},
-		{Name: "Chocolate Lava Cake", Description: "Decadent chocolate cake with a gooey molten center", Price: 6.99, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1624353365286-3f8d62daad51?auto=format&fit=crop&w=1170&q=80"},
-		{Name: "Iced Latte", Description: "Smooth espresso with cold milk over ice", Price: 3.99, Category: "drinks", PictureURL: "https://images.unsplash.com/photo-1517701550927-30cf4ba1dba5?auto=format&fit=crop&w=1170&q=80"},
-		{Name: "Grilled Chicken Sandwich", Description: "Grilled chicken breast with lettuce and mayo", Price: 10.49, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1597579018905-8c807adfbed4?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8R3JpbGxlZCUyMENoaWNrZW4lMjBTYW5kd2ljaHxlbnwwfHwwfHx8MA%3D%3D"},
-		{Name: "Vegetarian Wrap", Description: "Fresh vegetables wrapped in a soft tortilla", Price: 8.49, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1592044903782-9836f74027c0?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8VmVnZXRhcmlhbiUyMFdyYXB8ZW58MHx8MHx8fDA%3D"},
-		{Name: "Pepperoni Pizza", Description: "Classic pizza with spicy pepperoni and cheese", Price: 13.99, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1628840042765-356cda07504e?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8M3x8UGVwcGVyb25pJTIwcGl6emF8ZW58MHx8MHx8fDA%3D"},
-		{Name: "Garden Salad", Description: "Fresh garden vegetables with balsamic vinaigrette", Price: 6.99, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1605291535126-2d71fea483c1?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8Z2FyZGVuJTIwc2FsYWQlMjBkaXNofGVufDB8fDB8fHww"},
-		{Name: "Spaghetti Carbonara", Description: "Classic Italian pasta with creamy sauce", Price: 14.99, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1674511582428-58ce834ce172?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NXx8U3BhZ2hldHRpJTIwQ2FyYm9uYXJhfGVufDB8fDB8fHww"},
-		{Name: "Beef Tacos", Description: "Spiced beef with fresh toppings in a crispy shell", Price: 9.49, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1661730314652-911662c0d86e?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8YmVlZiUyMHRhY29zfGVufDB8fDB8fHww"},
-		{Name: "Shrimp Cocktail", Description: "Chilled shrimp with tangy cocktail sauce", Price: 11.99, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1691201659377-978b28daa417?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8N3x8U2hyaW1wJTIwQ29ja3RhaWx8ZW58MHx8MHx8fDA%3D"},
-		{Name: "Tomato Soup", Description: "Rich and creamy tomato soup with croutons", Price: 5.49, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1629978444632-9f63ba0eff47?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8VG9tYXRvJTIwU291cHxlbnwwfHwwfHx8MA%3D%3D"},
-		{Name: "Berry Smoothie", Description: "Mixed berry smoothie with a touch of honey", Price: 4.99, Category: "drinks", PictureURL: "https://images.unsplash.com/photo-1553177595-4de2bb0842b9?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MTV8fEJlcnJ5JTIwU21vb3RoaWV8ZW58MHx8MHx8fDA%3D"},
-		{Name: "Grilled Salmon", Description: "Perfectly grilled salmon with lemon butter", Price: 17.99, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1580476262798-bddd9f4b7369?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MTB8fEdyaWxsZWQlMjBTYWxtb258ZW58MHx8MHx8fDA%3D"},
-		{Name: "Margarita", Description: "Classic margarita with a salted rim", Price: 8.99, Category: "drinks", PictureURL: "https://images.unsplash.com/photo-1558017487-ce249cab792c?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MTF8fG1hcmdhcml0YXxlbnwwfHwwfHx8MA%3D%3D"},
-		{Name: "French Fries", Description: "Crispy golden fries with a side of ketchup", Price: 3.49, Category: "appetizers", PictureURL: "https://plus.unsplash.com/premium_photo-1672774750509-bc9ff226f3e8?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8RnJlbmNoJTIwZnJpZXN8ZW58MHx8MHx8fDA%3D"},
-		{Name: "BBQ Ribs", Description: "Tender ribs glazed with BBQ sauce", Price: 19.99, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1723437395525-77b08e41e53c?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Nnx8QkJRJTIwcmlic3xlbnwwfHwwfHx8MA%3D%3D"},
-		{Name: "Cheesecake", Description: "Classic cheesecake with a graham cracker crust", Price: 6.49, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1702925614886-50ad13c88d3f?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8M3x8Q2hlZXNlY2FrZXxlbnwwfHwwfHx8MA%3D%3D"},
-		{Name: "Veggie Pizza", Description: "Pizza topped with a variety of fresh vegetables", Price: 12.49, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1690056321981-dfe9e75e0247?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8dmVnZ2llJTIwcGl6emF8ZW58MHx8MHx8fDA%3D"},
-		{Name: "Chicken Alfredo", Description: "Pasta with creamy Alfredo sauce and grilled chicken", Price: 15.99, Category: "main-courses", PictureURL: "https://media.istockphoto.com/id/2161825710/photo/creamy-alfredo-pasto-in-a-white-plate.webp?a=1&b=1&s=612x612&w=0&k=20&c=Y89KirhVAKgVHcNgP8qzMxXDciCUBjHoccIG4chL6pU="},
-		{Name: "Mac and Cheese", Description: "Creamy mac and cheese topped with breadcrumbs", Price: 9.49, Category: "main-courses", PictureURL: "https://media.istockphoto.com/id/516078243/photo/macaroni.webp?a=1&b=1&s=612x612&w=0&k=20&c=qNzQK0rx_YcG4qPT8dnvItdpkoImlEkGQ0mIoIWRHAo="},
-		{Name: "Fish and Chips", Description: "Golden fried fish with crispy chips", Price: 14.49, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1695758774479-faae1180b078?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8ZmlzaCUyMGFuZCUyMGNoaXBzfGVufDB8fDB8fHww"},
-		{Name: "Mango Lassi", Description: "Refreshing mango yogurt drink", Price: 4.49, Category: "drinks", PictureURL: "https://plus.unsplash.com/premium_photo-1667251757355-b3db687473bc?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NXx8bWFuZ28lMjBsYXNzaSUyMGp1aWNlfGVufDB8fDB8fHww"},
-		{Name: "Panna Cotta", Description: "Italian dessert with a creamy texture", Price: 5.99, Category: "desserts", PictureURL: "https://plus.unsplash.com/premium_photo-1713913281130-4f8c78cdd02b?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8OXx8cGFubmElMjBjb3R0YXxlbnwwfHwwfHx8MA%3D%3D"},
-		{Name: "Ice Cream Sundae", Description: "Vanilla ice cream with toppings", Price: 5.49, Category: "desserts", PictureURL: "https://plus.unsplash.com/premium_photo-1664391744509-2a96af429dc4?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NXx8aWNlJTIwY3JlYW0lMjBzdW5kYWV8ZW58MHx8MHx8fDA%3D"}, {Name: "Spring Rolls", Description: "Crispy rolls filled with fresh vegetables", Price: 7.99, Category: "appetizers", PictureURL: "https://plus.unsplash.com/premium_photo-1663850685033-a8557389963e?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8OXx8U3ByaW5nJTIwUm9sbHN8ZW58MHx8MHx8fDA%3D"}, {Name: "Lemon Tart", Description: "Tart with a tangy lemon filling", Price: 6.49, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1614174486496-344ef3e9d870?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8TGVtb24lMjBUYXJ0fGVufDB8fDB8fHww"}, {Name: "Tuna Salad", Description: "Mixed greens with tuna and a light dressing", Price: 8.49, Category: "appetizers", PictureURL: "https://plus.unsplash.com/premium_photo-1695399566146-ed0214b5b883?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8VHVuYSUyMHNhbGFkfGVufDB8fDB8fHww"}, {Name: "Avocado Toast", Description: "Toasted bread topped with fresh avocado", Price: 6.99, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1687276287139-88f7333c8ca4?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8YXZvY2FkbyUyMHRvYXN0fGVufDB8fDB8fHww"}, {Name: "Veggie Stir Fry", Description: "Mixed vegetables stir-fried with soy sauce", Price: 11.99, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1599297915779-0dadbd376d49?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Nnx8VmVnZ2llJTIwU3RpciUyMEZyeXxlbnwwfHwwfHx8MA%3D%3D"}, {Name: "Grilled Shrimp", Description: "Marinated shrimp grilled to perfection", Price: 15.99, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1723325697529-6e2679650b39?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8Z3JpbGxlZCUyMHNocmltcHxlbnwwfHwwfHx8MA%3D%3D"}, {Name: "Pancakes", Description: "Fluffy pancakes with maple syrup", Price: 7.99, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1497445702960-c21c96af4c68?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8UGFuY2FrZXN8ZW58MHx8MHx8fDA%3D"}}
-
+		{Name: "Classic Burger", Description: "Juicy beef patty with fresh lettuce, tomato, and our special sauce", Price: 999, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1568901346375-23c9450c58cd?auto=format&fit=crop&w=1170&q=80", OptionGroups: []OptionGroup{
+			{Name: "Extras", MaxSelect: 3, Options: []MenuOption{{Name: "Cheese", Price: 100}, {Name: "Bacon", Price: 150, Position: 1}, {Name: "Jalapeños", Price: 75, Position: 2}}},
+		}},
+		{Name: "Margherita Pizza", Description: "Traditional Italian pizza with tomato sauce, mozzarella, and basil", Price: 1299, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1604068549290-dea0e4a305ca?auto=format&fit=crop&w=1074&q=80", Variants: []ItemVariant{{Name: "Small", SKU: "PZ-MARG-S", Price: 999}, {Name: "Medium", SKU: "PZ-MARG-M", Price: 1299, Position: 1}, {Name: "Large", SKU: "PZ-MARG-L", Price: 1599, Position: 2}}},
+		{Name: "Caesar Salad", Description: "Crisp romaine lettuce, croutons, and parmesan cheese with Caesar dressing", Price: 799, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1550304943-4f24f54ddde9?auto=format&fit=crop&w=1170&q=80"},
+		{Name: "Chicken Wings", Description: "Crispy chicken wings tossed in your choice of sauce", Price: 899, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1567620832903-9fc6debc209f?auto=format&fit=crop&w=1080&q=80", OptionGroups: []OptionGroup{
+			{Name: "Sauce", Required: true, MinSelect: 1, MaxSelect: 1, Options: []MenuOption{{Name: "Buffalo"}, {Name: "BBQ", Position: 1}, {Name: "Honey Garlic", Price: 50, Position: 2}}},
+			{Name: "Dip", MaxSelect: 2, Position: 1, Options: []MenuOption{{Name: "Ranch", Price: 75}, {Name: "Blue Cheese", Price: 75, Position: 1}}},
+		}},
+		{Name: "Chocolate Lava Cake", Description: "Decadent chocolate cake with a gooey molten center", Price: 699, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1624353365286-3f8d62daad51?auto=format&fit=crop&w=1170&q=80"},
+		{Name: "Iced Latte", Description: "Smooth espresso with cold milk over ice", Price: 399, Category: "drinks", PictureURL: "https://images.unsplash.com/photo-1517701550927-30cf4ba1dba5?auto=format&fit=crop&w=1170&q=80", Variants: []ItemVariant{{Name: "Small", SKU: "DR-LATTE-S", Price: 349}, {Name: "Medium", SKU: "DR-LATTE-M", Price: 399, Position: 1}, {Name: "Large", SKU: "DR-LATTE-L", Price: 449, Position: 2}}},
+		{Name: "Grilled Chicken Sandwich", Description: "Grilled chicken breast with lettuce and mayo", Price: 1049, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1597579018905-8c807adfbed4?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8R3JpbGxlZCUyMENoaWNrZW4lMjBTYW5kd2ljaHxlbnwwfHwwfHx8MA%3D%3D"},
+		{Name: "Vegetarian Wrap", Description: "Fresh vegetables wrapped in a soft tortilla", Price: 849, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1592044903782-9836f74027c0?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8VmVnZXRhcmlhbiUyMFdyYXB8ZW58MHx8MHx8fDA%3D"},
+		{Name: "Pepperoni Pizza", Description: "Classic pizza with spicy pepperoni and cheese", Price: 1399, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1628840042765-356cda07504e?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8M3x8UGVwcGVyb25pJTIwcGl6emF8ZW58MHx8MHx8fDA%3D", Variants: []ItemVariant{{Name: "Small", SKU: "PZ-PEPP-S", Price: 1099}, {Name: "Medium", SKU: "PZ-PEPP-M", Price: 1399, Position: 1}, {Name: "Large", SKU: "PZ-PEPP-L", Price: 1699, Position: 2}}},
+		{Name: "Garden Salad", Description: "Fresh garden vegetables with balsamic vinaigrette", Price: 699, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1605291535126-2d71fea483c1?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8Z2FyZGVuJTIwc2FsYWQlMjBkaXNofGVufDB8fDB8fHww"},
+		{Name: "Spaghetti Carbonara", Description: "Classic Italian pasta with creamy sauce", Price: 1499, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1674511582428-58ce834ce172?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NXx8U3BhZ2hldHRpJTIwQ2FyYm9uYXJhfGVufDB8fDB8fHww"},
+		{Name: "Beef Tacos", Description: "Spiced beef with fresh toppings in a crispy shell", Price: 949, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1661730314652-911662c0d86e?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8YmVlZiUyMHRhY29zfGVufDB8fDB8fHww"},
+		{Name: "Shrimp Cocktail", Description: "Chilled shrimp with tangy cocktail sauce", Price: 1199, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1691201659377-978b28daa417?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8N3x8U2hyaW1wJTIwQ29ja3RhaWx8ZW58MHx8MHx8fDA%3D"},
+		{Name: "Tomato Soup", Description: "Rich and creamy tomato soup with croutons", Price: 549, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1629978444632-9f63ba0eff47?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8VG9tYXRvJTIwU291cHxlbnwwfHwwfHx8MA%3D%3D"},
+		{Name: "Berry Smoothie", Description: "Mixed berry smoothie with a touch of honey", Price: 499, Category: "drinks", PictureURL: "https://images.unsplash.com/photo-1553177595-4de2bb0842b9?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MTV8fEJlcnJ5JTIwU21vb3RoaWV8ZW58MHx8MHx8fDA%3D", Variants: []ItemVariant{{Name: "Regular", SKU: "DR-BERRY-R", Price: 499}, {Name: "Large", SKU: "DR-BERRY-L", Price: 649, Position: 1}}},
+		{Name: "Grilled Salmon", Description: "Perfectly grilled salmon with lemon butter", Price: 1799, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1580476262798-bddd9f4b7369?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MTB8fEdyaWxsZWQlMjBTYWxtb258ZW58MHx8MHx8fDA%3D"},
+		{Name: "Margarita", Description: "Classic margarita with a salted rim", Price: 899, Category: "drinks", PictureURL: "https://images.unsplash.com/photo-1558017487-ce249cab792c?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MTF8fG1hcmdhcml0YXxlbnwwfHwwfHx8MA%3D%3D"},
+		{Name: "French Fries", Description: "Crispy golden fries with a side of ketchup", Price: 349, Category: "appetizers", PictureURL: "https://plus.unsplash.com/premium_photo-1672774750509-bc9ff226f3e8?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8RnJlbmNoJTIwZnJpZXN8ZW58MHx8MHx8fDA%3D"},
+		{Name: "BBQ Ribs", Description: "Tender ribs glazed with BBQ sauce", Price: 1999, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1723437395525-77b08e41e53c?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Nnx8QkJRJTIwcmlic3xlbnwwfHwwfHx8MA%3D%3D"},
+		{Name: "Cheesecake", Description: "Classic cheesecake with a graham cracker crust", Price: 649, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1702925614886-50ad13c88d3f?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8M3x8Q2hlZXNlY2FrZXxlbnwwfHwwfHx8MA%3D%3D"},
+		{Name: "Veggie Pizza", Description: "Pizza topped with a variety of fresh vegetables", Price: 1249, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1690056321981-dfe9e75e0247?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8dmVnZ2llJTIwcGl6emF8ZW58MHx8MHx8fDA%3D", Variants: []ItemVariant{{Name: "Small", SKU: "PZ-VEG-S", Price: 949}, {Name: "Medium", SKU: "PZ-VEG-M", Price: 1249, Position: 1}, {Name: "Large", SKU: "PZ-VEG-L", Price: 1549, Position: 2}}},
+		{Name: "Chicken Alfredo", Description: "Pasta with creamy Alfredo sauce and grilled chicken", Price: 1599, Category: "main-courses", PictureURL: "https://media.istockphoto.com/id/2161825710/photo/creamy-alfredo-pasto-in-a-white-plate.webp?a=1&b=1&s=612x612&w=0&k=20&c=Y89KirhVAKgVHcNgP8qzMxXDciCUBjHoccIG4chL6pU="},
+		{Name: "Mac and Cheese", Description: "Creamy mac and cheese topped with breadcrumbs", Price: 949, Category: "main-courses", PictureURL: "https://media.istockphoto.com/id/516078243/photo/macaroni.webp?a=1&b=1&s=612x612&w=0&k=20&c=qNzQK0rx_YcG4qPT8dnvItdpkoImlEkGQ0mIoIWRHAo="},
+		{Name: "Fish and Chips", Description: "Golden fried fish with crispy chips", Price: 1449, Category: "main-courses", PictureURL: "https://plus.unsplash.com/premium_photo-1695758774479-faae1180b078?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8ZmlzaCUyMGFuZCUyMGNoaXBzfGVufDB8fDB8fHww"},
+		{Name: "Mango Lassi", Description: "Refreshing mango yogurt drink", Price: 449, Category: "drinks", PictureURL: "https://plus.unsplash.com/premium_photo-1667251757355-b3db687473bc?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NXx8bWFuZ28lMjBsYXNzaSUyMGp1aWNlfGVufDB8fDB8fHww", Variants: []ItemVariant{{Name: "Regular", SKU: "DR-LASSI-R", Price: 449}, {Name: "Large", SKU: "DR-LASSI-L", Price: 599, Position: 1}}},
+		{Name: "Panna Cotta", Description: "Italian dessert with a creamy texture", Price: 599, Category: "desserts", PictureURL: "https://plus.unsplash.com/premium_photo-1713913281130-4f8c78cdd02b?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8OXx8cGFubmElMjBjb3R0YXxlbnwwfHwwfHx8MA%3D%3D"},
+		{Name: "Ice Cream Sundae", Description: "Vanilla ice cream with toppings", Price: 549, Category: "desserts", PictureURL: "https://plus.unsplash.com/premium_photo-1664391744509-2a96af429dc4?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NXx8aWNlJTIwY3JlYW0lMjBzdW5kYWV8ZW58MHx8MHx8fDA%3D"}, {Name: "Spring Rolls", Description: "Crispy rolls filled with fresh vegetables", Price: 799, Category: "appetizers", PictureURL: "https://plus.unsplash.com/premium_photo-1663850685033-a8557389963e?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8OXx8U3ByaW5nJTIwUm9sbHN8ZW58MHx8MHx8fDA%3D"}, {Name: "Lemon Tart", Description: "Tart with a tangy lemon filling", Price: 649, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1614174486496-344ef3e9d870?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8TGVtb24lMjBUYXJ0fGVufDB8fDB8fHww"}, {Name: "Tuna Salad", Description: "Mixed greens with tuna and a light dressing", Price: 849, Category: "appetizers", PictureURL: "https://plus.unsplash.com/premium_photo-1695399566146-ed0214b5b883?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8MXx8VHVuYSUyMHNhbGFkfGVufDB8fDB8fHww"}, {Name: "Avocado Toast", Description: "Toasted bread topped with fresh avocado", Price: 699, Category: "appetizers", PictureURL: "https://images.unsplash.com/photo-1687276287139-88f7333c8ca4?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8YXZvY2FkbyUyMHRvYXN0fGVufDB8fDB8fHww"}, {Name: "Veggie Stir Fry", Description: "Mixed vegetables stir-fried with soy sauce", Price: 1199, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1599297915779-0dadbd376d49?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Nnx8VmVnZ2llJTIwU3RpciUyMEZyeXxlbnwwfHwwfHx8MA%3D%3D"}, {Name: "Grilled Shrimp", Description: "Marinated shrimp grilled to perfection", Price: 1599, Category: "main-courses", PictureURL: "https://images.unsplash.com/photo-1723325697529-6e2679650b39?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8NHx8Z3JpbGxlZCUyMHNocmltcHxlbnwwfHwwfHx8MA%3D%3D"}, {Name: "Pancakes", Description: "Fluffy pancakes with maple syrup", Price: 799, Category: "desserts", PictureURL: "https://images.unsplash.com/photo-1497445702960-c21c96af4c68?w=500&auto=format&fit=crop&q=60&ixlib=rb-4.0.3&ixid=M3wxMjA3fDB8MHxzZWFyY2h8Mnx8UGFuY2FrZXN8ZW58MHx8MHx8fDA%3D"}}
+
+	// Меню по умолчанию принадлежит первому ресторану, его создает миграция.
+	restaurantID, err := defaultRestaurantID(db)
+	if err != nil {
+		log.Println("❌ Нет ресторана для меню:", err)
+		return
+	}
 	for _, item := range items {
+		item.Currency = cfg.Pricing.Currency
+		item.Version = 1
+		item.RestaurantID = restaurantID
 		db.Create(&item)
+		// Завтрак продается только утром.
+		if item.Name == "Pancakes" {
+			db.Create(&AvailabilityRule{FoodItemID: &item.ID, Start: "07:00", End: "11:30", Timezone: "UTC"})
+		}
 	}
 	fmt.Println("✅ Initial menu items added!")
 }
 
+// registrationInput - поля, которые клиент может задать при регистрации;
+// роль, ресторан и подтверждение email выставляет сервер.
+type registrationInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Phone    string `json:"phone"`
+	Password string `json:"password"`
+	Language string `json:"language"`
+}
+
 func registerUser(w http.ResponseWriter, r *http.Request) {
-	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var input registrationInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		log.Println("Ошибка декодирования JSON:", err)
 		http.Error(w, "Неверный формат данных", http.StatusBadRequest)
 		return
 	}
 
-	log.Println("📌 Данные для регистрации получены:", user)
+	log.Println("📌 Данные для регистрации получены:", input.Email)
 
+	user := User{Name: input.Name, Email: input.Email, Phone: input.Phone, Language: input.Language}
 	if user.Email == "" {
 		log.Println("❌ Ошибка: email пуст!")
 		http.Error(w, "Email не может быть пустым", http.StatusBadRequest)
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Println("❌ Ошибка хеширования пароля:", err)
 		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
@@ -455,76 +526,53 @@ func registerUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user.Password = string(hashedPassword)
-	user.EmailConfirmed = false
+	user.Role = roleCustomer
+	if user.Language == "" {
+		user.Language = requestLanguage(r)
+	}
+	user.Language = normalizeLanguage(user.Language)
 
-	if err := db.Create(&user).Error; err != nil {
-		log.Println("❌ Ошибка сохранения в БД:", err)
-		http.Error(w, "Ошибка регистрации", http.StatusInternalServerError)
+	// **Генерация токена**
+	tokenString, err := issueToken(&Claims{Email: user.Email, Purpose: purposeConfirm}, confirmTokenTTL)
+	if err != nil {
+		log.Println("❌ Ошибка генерации токена:", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("✅ Пользователь зарегистрирован:", user.Email)
-
-	// **Генерация токена**
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": user.Email,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(),
+	confirmation, err := renderEmail(emailConfirmation, user.Language, user.Email, map[string]interface{}{
+		"Name": user.Name,
+		"Link": publicLink("/confirm", url.Values{"token": {tokenString}}),
 	})
-	tokenString, _ := token.SignedString(jwtKey)
-
-	log.Println("📌 Генерируем email подтверждение для:", user.Email)
-
-	// **Вызов функции отправки письма**
-	sendEmailConfirmation(user.Email, tokenString)
-
-	log.Println("📩 Письмо на email подтверждение отправлено!")
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "Регистрация успешна"})
-}
-
-func sendEmailConfirmation(email, token string) {
-	if email == "" {
-		log.Println("❌ Ошибка: email пуст при отправке подтверждения!")
+	if err != nil {
+		log.Println("❌ Ошибка шаблона письма:", err)
+		http.Error(w, "Ошибка сервера", http.StatusInternalServerError)
 		return
 	}
 
-	log.Println("📨 Начало отправки email на адрес:", email)
-
-	m := gomail.NewMessage()
-	m.SetHeader("From", "turzhanov.danial@gmail.com")
-	m.SetHeader("To", email)
-	m.SetHeader("Subject", "Подтвердите вашу регистрацию")
-	m.SetBody("text/html", fmt.Sprintf("<p>Для подтверждения регистрации нажмите <a href='http://localhost:8080/confirm?token=%s'>здесь</a></p>", token))
-
-	// Настройки SMTP-сервера
-	smtpServer := "smtp.gmail.com"
-	smtpPort := 587
-	smtpUser := "turzhanov.danial@gmail.com"
-	smtpPass := "dpyr fkuf jocf bcam" // ❗ Используйте пароль приложения Google!
-
-	d := gomail.NewDialer(smtpServer, smtpPort, smtpUser, smtpPass)
-	d.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	// Пользователь и письмо подтверждения сохраняются вместе: письмо отправит outbox-воркер.
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return enqueueEmail(tx, emailConfirmation, confirmation)
+	})
+	if err != nil {
+		log.Println("❌ Ошибка сохранения в БД:", err)
+		http.Error(w, "Ошибка регистрации", http.StatusInternalServerError)
+		return
+	}
 
-	log.Println("🔄 Подключение к SMTP-серверу...")
+	log.Println("✅ Пользователь зарегистрирован, письмо подтверждения в очереди:", user.Email)
 
-	// Пробуем отправить письмо
-	if err := d.DialAndSend(m); err != nil {
-		log.Printf("❌ Ошибка отправки письма на %s: %v", email, err)
-	} else {
-		log.Printf("✅ Письмо успешно отправлено на %s", email)
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Регистрация успешна"})
 }
 
 func confirmEmail(w http.ResponseWriter, r *http.Request) {
 	tokenString := r.URL.Query().Get("token")
-	claims := &Claims{}
-
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
-	if err != nil {
+	claims, err := parseToken(tokenString)
+	if err != nil || claims.Purpose != purposeConfirm {
 		http.Error(w, "Invalid token", http.StatusBadRequest)
 		return
 	}
@@ -552,11 +600,14 @@ func confirmEmail(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Email confirmed!"))
 }
 
+// loginInput - тело POST /login.
+type loginInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 func loginUser(w http.ResponseWriter, r *http.Request) {
-	var credentials struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-	}
+	var credentials loginInput
 
 	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
 		handleError(w, http.StatusBadRequest, "Неверный формат данных", err)
@@ -579,21 +630,24 @@ func loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// **Генерация JWT-токена**
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email": user.Email,
-		"exp":   time.Now().Add(time.Hour * 24).Unix(),
-	})
-	tokenString, _ := token.SignedString(jwtKey)
+	// **Создаем сессию и пару access/refresh токенов**
+	tokens, err := startSession(&user, r)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Ошибка сервера", err)
+		return
+	}
 
 	logger.WithField("email", user.Email).Info("Пользователь успешно вошел в систему")
+	mergeGuestCartOnLogin(w, r, &user)
 
 	// **Отправляем токен в ответе**
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"id":    user.ID,
-		"email": user.Email,
-		"role":  user.Role,
-		"token": tokenString, // Добавлен токен
+		"id":            user.ID,
+		"email":         user.Email,
+		"role":          user.Role,
+		"token":         tokens.AccessToken, // Добавлен токен
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
 	})
 }
 
@@ -612,16 +666,42 @@ func getAllUsers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
+// getUserCart - старый API корзины: блюда списком, по элементу на каждую штуку.
+// Новые клиенты используют GET /cart.
 func getUserCart(w http.ResponseWriter, r *http.Request) {
 	email := r.URL.Query().Get("email")
+	if current, _ := userFromContext(r.Context()); !canAccessUser(current, 0, email) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 	var user User
-	result := db.Where("email = ?", email).Preload("Cart").First(&user)
+	result := db.Where("email = ?", email).First(&user)
 	if result.Error != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-	json.NewEncoder(w).Encode(user.Cart)
+	cart, err := cartForUser(user.ID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to load cart", err)
+		return
+	}
+	view, err := refreshCart(cart, "")
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	items := []FoodItem{}
+	for _, line := range view.Items {
+		item := FoodItem{ID: *line.FoodItemID, Name: line.Name, Price: line.UnitPrice, Currency: view.Pricing.Currency}
+		for i := 0; i < line.Quantity; i++ {
+			items = append(items, item)
+		}
+	}
+	json.NewEncoder(w).Encode(items)
 }
+
+// updateUserCart - старый API корзины: заменяет корзину целиком. Из присланных
+// блюд берутся только ID, цены и названия - из меню; повторы складываются в количество.
 func updateUserCart(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Email string     `json:"email"`
@@ -632,6 +712,10 @@ func updateUserCart(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	if current, _ := userFromContext(r.Context()); !canAccessUser(current, 0, data.Email) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	var user User
 	result := db.Where("email = ?", data.Email).First(&user)
@@ -639,14 +723,53 @@ func updateUserCart(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
-
-	db.Model(&user).Association("Cart").Replace(data.Cart)
+	cart, err := cartForUser(user.ID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to load cart", err)
+		return
+	}
+	// Корзина заменяется целиком: если хоть одно блюдо не добавилось, остается старая.
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cart_id = ?", cart.ID).Delete(&CartItem{}).Error; err != nil {
+			return err
+		}
+		for _, item := range data.Cart {
+			if err := addToCart(tx, cart, item.ID, 0, nil, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// getMenu - GET /menu[?hide_sold_out=true][&show_unavailable=true]. Закончившиеся
+// блюда отмечены sold_out; блюда вне расписания скрыты, если не просили показать.
 func getMenu(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch menu", err)
+		return
+	}
+	query, err := applySchedule(hideSoldOut(db, r), r, schedule, now)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch menu", err)
+		return
+	}
+	restaurantID, err := restaurantFromRequest(r) // /restaurants/{rid}/menu или ?restaurant_id=
+	if err != nil {
+		http.Error(w, "Invalid restaurant_id", http.StatusBadRequest)
+		return
+	}
+	query = byRestaurant(query, restaurantID)
 	var items []FoodItem
-	db.Find(&items)
+	withMenuDetails(query).Find(&items)
+	schedule.apply(items, now)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(items)
 }
@@ -659,13 +782,28 @@ func getMenuItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var item FoodItem
-	result := db.First(&item, id)
+	result := withMenuDetails(db).First(&item, id)
 	if result.Error != nil {
 		http.Error(w, "Item not found", http.StatusNotFound)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(item)
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch menu item", err)
+		return
+	}
+	item.Available, item.AvailableFrom = schedule.availability(&item, time.Now())
+	body, etag, err := encodeMenuItem(&item)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to encode menu item", err)
+		return
+	}
+	if r.Header.Get("If-None-Match") == etag {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeMenuItemBody(w, http.StatusOK, body, etag)
 }
 
 func addMenuItem(w http.ResponseWriter, r *http.Request) {
@@ -675,9 +813,61 @@ func addMenuItem(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
-	db.Create(&item)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(item)
+	if item.Currency == "" {
+		item.Currency = cfg.Pricing.Currency
+	}
+	// Ресторан - из /restaurants/{rid}/menu (проверен withRestaurant), из тела или
+	// ресторан по умолчанию.
+	if _, ok := mux.Vars(r)["rid"]; ok {
+		item.RestaurantID, _ = restaurantFromRequest(r)
+	}
+	exists := true
+	if item.RestaurantID == 0 {
+		item.RestaurantID, err = defaultRestaurantID(db)
+	} else {
+		exists, err = restaurantExists(item.RestaurantID)
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to create menu item", err)
+		return
+	}
+	if !exists {
+		http.Error(w, "Restaurant not found", http.StatusBadRequest)
+		return
+	}
+	if err := validateFoodItem(&item); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	// Варианты и группы модификаторов можно передать сразу; gorm создаст их вместе с блюдом.
+	if err := validateVariants(item.Variants); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	for i := range item.Variants {
+		item.Variants[i].ID, item.Variants[i].FoodItemID = 0, 0
+	}
+	for i := range item.OptionGroups {
+		group := &item.OptionGroups[i]
+		if err := validateOptionGroup(group); err != nil {
+			handleError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+		group.ID, group.FoodItemID = 0, 0
+		for j := range group.Options {
+			group.Options[j].ID, group.Options[j].GroupID = 0, 0
+		}
+	}
+	item.ID, item.Version = 0, 1
+	item.SoldOut, item.Available = item.isSoldOut(), true
+	if err := db.Create(&item).Error; errors.Is(err, gorm.ErrDuplicatedKey) {
+		handleError(w, http.StatusConflict, errDuplicateSKU.Error(), err)
+		return
+	} else if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to create menu item", err)
+		return
+	}
+	writeMenuItem(w, http.StatusOK, &item)
 }
 
 func deleteMenuItem(w http.ResponseWriter, r *http.Request) {
@@ -696,15 +886,8 @@ func deleteMenuItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&item).Association("Orders").Clear(); err != nil {
-			return err
-		}
-		if err := tx.Delete(&item).Error; err != nil {
-			return err
-		}
-		return nil
-	})
+	// Позиции старых заказов хранят копию названия и цены, food_item_id у них обнулит база.
+	err = db.Delete(&item).Error
 	if err != nil {
 		log.Printf("Error deleting menu item with ID %d: %v", id, err)
 		http.Error(w, "Failed to delete menu item due to constraints", http.StatusInternalServerError)
@@ -720,10 +903,19 @@ func getOrdersByUserID(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "userId is required", http.StatusBadRequest)
 		return
 	}
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		http.Error(w, "Invalid userId", http.StatusBadRequest)
+		return
+	}
+	if current, _ := userFromContext(r.Context()); !canAccessUser(current, uint(id), "") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	var orders []Order
 
-	result := db.Preload("FoodItems").Where("user_id = ?", userID).Find(&orders)
+	result := db.Preload("Lines.Options").Where("user_id = ?", id).Find(&orders)
 	if result.Error != nil {
 		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
 		return
@@ -734,38 +926,80 @@ func getOrdersByUserID(w http.ResponseWriter, r *http.Request) {
 }
 
 func placeOrder(w http.ResponseWriter, r *http.Request) {
-	var order Order
-	err := json.NewDecoder(r.Body).Decode(&order)
+	var input struct {
+		Customer  string           `json:"customer"`
+		Address   string           `json:"address"`
+		Lines     []orderLineInput `json:"lines"`
+		FoodItems []FoodItem       `json:"food_items"` // старый формат: по элементу на штуку
+		PromoCode string           `json:"promo_code"`
+		Latitude  *float64         `json:"latitude"` // точка доставки для проверки радиуса ресторана
+		Longitude *float64         `json:"longitude"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&input)
 	if err != nil {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	current, _ := userFromContext(r.Context())
 
-	var total float64
-	var foodItems []FoodItem
-	for _, foodItem := range order.FoodItems {
-		var dbItem FoodItem
-		db.First(&dbItem, foodItem.ID)
-		if dbItem.ID == 0 {
-			http.Error(w, "Food item not found", http.StatusNotFound)
-			return
+	lineInputs := input.Lines
+	if len(lineInputs) == 0 {
+		for _, foodItem := range input.FoodItems {
+			lineInputs = append(lineInputs, orderLineInput{FoodItemID: foodItem.ID, Quantity: 1})
 		}
-		foodItems = append(foodItems, dbItem)
-		total += dbItem.Price
 	}
-	order.FoodItems = foodItems
-	order.Total = total
+	lines, _, err := buildOrderLines(lineInputs)
+	if err != nil {
+		writeOrderLinesError(w, err)
+		return
+	}
+	restaurantID, err := orderRestaurant(lines)
+	if err == nil {
+		err = checkDeliveryArea([]uint{restaurantID}, input.Latitude, input.Longitude)
+	}
+	if err != nil {
+		writeOrderLinesError(w, err)
+		return
+	}
+	breakdown, err := priceOrder(cfg.Pricing, lines, input.PromoCode)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid promo code", err)
+		return
+	}
 
-	db.Create(&order)
+	order := Order{
+		Customer:        input.Customer,
+		Address:         input.Address,
+		Lines:           lines,
+		UserID:          current.ID,
+		RestaurantID:    restaurantID,
+		Status:          orderPlaced,
+		StatusUpdatedAt: time.Now(),
+	}
+	breakdown.apply(&order)
+
+	var body []byte
+	err = saveNewOrder(&order, current, func(tx *gorm.DB) (err error) {
+		body, err = storeIdempotentResponse(r.Context(), tx, http.StatusOK, order)
+		return err
+	})
+	if err != nil {
+		writeSaveOrderError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(order)
+	w.Write(body)
 }
 func createOrder(w http.ResponseWriter, r *http.Request) {
 	var orderInput struct {
-		Customer  string  `json:"customer"`
-		Address   string  `json:"address"`
-		Total     float64 `json:"total"`
-		FoodItems []uint  `json:"food_items"` // Массив ID продуктов
+		Customer  string           `json:"customer"`
+		Address   string           `json:"address"`
+		Total     *Money           `json:"total"` // если передан, должен совпасть с расчетом сервера
+		Lines     []orderLineInput `json:"lines"`
+		FoodItems []uint           `json:"food_items"` // Массив ID продуктов (старый формат)
+		PromoCode string           `json:"promo_code"`
+		Latitude  *float64         `json:"latitude"` // точка доставки для проверки радиуса ресторана
+		Longitude *float64         `json:"longitude"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&orderInput); err != nil {
@@ -773,61 +1007,74 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user User
-	if err := db.Where("email = ?", orderInput.Customer).First(&user).Error; err != nil {
-		handleError(w, http.StatusBadRequest, "User not found", err)
+	user, _ := userFromContext(r.Context())
+
+	lineInputs := orderInput.Lines
+	if len(lineInputs) == 0 {
+		lineInputs = legacyLineInputs(orderInput.FoodItems)
+	}
+	lines, _, err := buildOrderLines(lineInputs)
+	if err != nil {
+		writeOrderLinesError(w, err)
 		return
 	}
-
-	var foodItems []FoodItem
-	if err := db.Where("id IN ?", orderInput.FoodItems).Find(&foodItems).Error; err != nil {
-		handleError(w, http.StatusInternalServerError, "Failed to find food items", err)
+	// Заказ - из одного ресторана; корзину из нескольких делит /cart/checkout.
+	restaurantID, err := orderRestaurant(lines)
+	if err == nil {
+		err = checkDeliveryArea([]uint{restaurantID}, orderInput.Latitude, orderInput.Longitude)
+	}
+	if err != nil {
+		writeOrderLinesError(w, err)
 		return
 	}
-
-	order := Order{
-		Customer:  orderInput.Customer,
-		Address:   orderInput.Address,
-		Total:     orderInput.Total,
-		FoodItems: foodItems,
-		UserID:    user.ID,
+	breakdown, err := priceOrder(cfg.Pricing, lines, orderInput.PromoCode)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid promo code", err)
+		return
+	}
+	if orderInput.Total != nil && *orderInput.Total != breakdown.Total {
+		writePriceMismatch(w, *orderInput.Total, breakdown)
+		return
 	}
 
-	if err := db.Create(&order).Error; err != nil {
-		handleError(w, http.StatusInternalServerError, "Failed to create order", err)
+	order := Order{
+		Customer:        orderInput.Customer,
+		Address:         orderInput.Address,
+		Lines:           lines,
+		UserID:          user.ID,
+		RestaurantID:    restaurantID,
+		Status:          orderPlaced,
+		StatusUpdatedAt: time.Now(),
+	}
+	breakdown.apply(&order)
+
+	var body []byte
+	err = saveNewOrder(&order, user, func(tx *gorm.DB) (err error) {
+		body, err = storeIdempotentResponse(r.Context(), tx, http.StatusCreated, order)
+		return err
+	})
+	if err != nil {
+		writeSaveOrderError(w, err)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(order)
-}
-func adminMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		email := r.Header.Get("X-User-Email")
-		if email == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		var user User
-		if err := db.Where("email = ?", email).First(&user).Error; err != nil || user.Role != "admin" {
-			http.Error(w, "Forbidden: Admins only", http.StatusForbidden)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
+	w.Write(body)
 }
-
 func getUserByEmail(w http.ResponseWriter, r *http.Request) {
 	email := r.URL.Query().Get("email")
 	if email == "" {
 		http.Error(w, "Email is required", http.StatusBadRequest)
 		return
 	}
+	if current, _ := userFromContext(r.Context()); !canAccessUser(current, 0, email) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	var user User
-	result := db.Preload("Orders.FoodItems").Where("email = ?", email).First(&user)
+	result := db.Preload("Orders.Lines.Options").Where("email = ?", email).First(&user)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			http.Error(w, "User not found", http.StatusNotFound)
@@ -842,7 +1089,7 @@ func getUserByEmail(w http.ResponseWriter, r *http.Request) {
 func rateLimitWithHeadersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		remaining := limiter.Burst() - int(limiter.Reserve().Delay())
-		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limiter.Limit()))
+		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%v", limiter.Limit()))
 		w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
 		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(limiter.Reserve().Delay())))
 
@@ -854,6 +1101,9 @@ func rateLimitWithHeadersMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// deleteOrder - DELETE /orders/{id}: архивирует завершенный заказ. Позиции, история
+// статусов, платежи и возвраты остаются в базе.
 func deleteOrder(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	id, err := strconv.Atoi(params["id"])
@@ -863,7 +1113,7 @@ func deleteOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var order Order
-	result := db.Preload("FoodItems").First(&order, id)
+	result := db.First(&order, id)
 	if result.Error != nil {
 		if result.Error == gorm.ErrRecordNotFound {
 			http.Error(w, "Order not found", http.StatusNotFound)
@@ -872,17 +1122,12 @@ func deleteOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to fetch order", http.StatusInternalServerError)
 		return
 	}
+	if len(orderTransitions[order.Status]) > 0 {
+		http.Error(w, "Only delivered or cancelled orders can be deleted", http.StatusConflict)
+		return
+	}
 
-	err = db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&order).Association("FoodItems").Clear(); err != nil {
-			return err
-		}
-		if err := tx.Delete(&order).Error; err != nil {
-			return err
-		}
-		return nil
-	})
-
+	err = db.Delete(&order).Error
 	if err != nil {
 		http.Error(w, "Failed to delete order", http.StatusInternalServerError)
 		return
@@ -892,9 +1137,34 @@ func deleteOrder(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Order %d deleted successfully", id)
 }
 
+// getAllOrders - список заказов для сотрудников и админов, ?status= фильтрует по статусу.
+// Админ может добавить удаленные заказы через ?include_deleted=true. Сотрудник
+// ресторана видит только его заказы, остальные могут выбрать ресторан ?restaurant_id=.
 func getAllOrders(w http.ResponseWriter, r *http.Request) {
 	var orders []Order
-	result := db.Preload("FoodItems").Find(&orders)
+	restaurantID, ok := ordersRestaurantFilter(w, r)
+	if !ok {
+		return
+	}
+	query := db.Preload("Lines.Options").Order("id DESC")
+	if restaurantID != 0 {
+		query = query.Where("restaurant_id = ?", restaurantID)
+	}
+	if r.URL.Query().Get("include_deleted") == "true" {
+		if user, _ := userFromContext(r.Context()); user.Role != roleAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		query = query.Unscoped()
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		if _, ok := orderTransitions[status]; !ok {
+			http.Error(w, "Unknown order status", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+	result := query.Find(&orders)
 	if result.Error != nil {
 		http.Error(w, "Failed to fetch orders", http.StatusInternalServerError)
 		return
@@ -946,47 +1216,167 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "User %d deleted successfully", id)
 }
 
+// setUserRole назначает роль пользователю (например, staff для сотрудников всего
+// маркетплейса; сотрудника одного ресторана назначает PUT /restaurants/{rid}/staff/{userId}).
+func setUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	switch input.Role {
+	case roleCustomer, roleStaff, roleAdmin:
+	default:
+		http.Error(w, "Unknown role", http.StatusBadRequest)
+		return
+	}
+	updates := map[string]interface{}{"role": input.Role}
+	if input.Role != roleStaff {
+		// Привязка к ресторану имеет смысл только для сотрудника.
+		updates["restaurant_id"] = nil
+	}
+	res := db.Model(&User{}).Where("id = ?", id).Updates(updates)
+	if res.Error != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to update role", res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func main() {
-	initDatabase()
+	initConfig()
+	limiter = cfg.RateLimit.Global.limiter()
+	orderLimiter = cfg.RateLimit.Order.limiter()
+	passwordResetLimiter = cfg.RateLimit.PasswordReset.limiter()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rotate-keys":
+			if err := rotateKeysCommand(); err != nil {
+				log.Fatal("Failed to rotate keys: ", err)
+			}
+			return
+		case "migrate":
+			if err := migrateCommand(os.Args[2:]); err != nil {
+				log.Fatal("Migration failed: ", err)
+			}
+			return
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+	}
+
+	initKeys(cfg.JWT)
+	initMailer(cfg.Mail)
+	initPayments(cfg.Payments)
+	initDatabase(cfg.Database)
+	go runOutboxWorker()
+	go runPaymentOperationWorker()
+	go runGuestCartJanitor()
 	r := mux.NewRouter()
 	r.HandleFunc("/items", getFilteredSortedPaginatedItems).Methods("GET")
 
 	r.HandleFunc("/menu", getMenu).Methods("GET")
 	r.HandleFunc("/menu/{id}", getMenuItem).Methods("GET")
-	r.HandleFunc("/menu", addMenuItem).Methods("POST")
-	r.HandleFunc("/menu/{id}", deleteMenuItem).Methods("DELETE")
-	r.HandleFunc("/order", placeOrder).Methods("POST")
-	r.Handle("/order", rateLimitByRouteMiddleware(orderLimiter, http.HandlerFunc(placeOrder))).Methods("POST")
-	r.HandleFunc("/orders/{id}", deleteOrder).Methods("DELETE")
-
-	r.HandleFunc("/users/{id}", deleteUser).Methods("DELETE")
-
-	r.HandleFunc("/orders", getAllOrders).Methods("GET")
+	r.Handle("/menu", adminOnly(addMenuItem)).Methods("POST")
+	r.Handle("/menu/{id}", adminOnly(deleteMenuItem)).Methods("DELETE")
+	r.Handle("/menu/{id}", adminOnly(putMenuItem)).Methods("PUT")
+	r.Handle("/menu/{id}", adminOnly(patchMenuItem)).Methods("PATCH")
+	r.Handle("/menu/{id}/variants", adminOnly(putItemVariants)).Methods("PUT")
+	r.Handle("/menu/{id}/inventory", adminOnly(putInventory)).Methods("PUT")
+	r.Handle("/admin/inventory/low-stock", adminOnly(getLowStock)).Methods("GET")
+	r.HandleFunc("/menu/{id}/availability", getAvailability).Methods("GET")
+	r.Handle("/menu/{id}/availability", adminOnly(putAvailability)).Methods("PUT")
+	r.HandleFunc("/categories/{category}/availability", getAvailability).Methods("GET")
+	r.Handle("/categories/{category}/availability", adminOnly(putAvailability)).Methods("PUT")
+	r.HandleFunc("/restaurants", getRestaurants).Methods("GET")
+	r.Handle("/restaurants", adminOnly(createRestaurant)).Methods("POST")
+	r.HandleFunc("/restaurants/{rid}", getRestaurant).Methods("GET")
+	r.Handle("/restaurants/{rid}", adminOnly(updateRestaurant)).Methods("PUT")
+	r.HandleFunc("/restaurants/{rid}/menu", withRestaurant(getMenu)).Methods("GET")
+	r.Handle("/restaurants/{rid}/menu", adminOnly(withRestaurant(addMenuItem))).Methods("POST")
+	r.HandleFunc("/restaurants/{rid}/items", withRestaurant(getFilteredSortedPaginatedItems)).Methods("GET")
+	r.HandleFunc("/restaurants/{rid}/hours", withRestaurant(getAvailability)).Methods("GET")
+	r.Handle("/restaurants/{rid}/hours", adminOnly(withRestaurant(putAvailability))).Methods("PUT")
+	r.HandleFunc("/restaurants/{rid}/categories/{category}/availability", withRestaurant(getAvailability)).Methods("GET")
+	r.Handle("/restaurants/{rid}/categories/{category}/availability", adminOnly(withRestaurant(putAvailability))).Methods("PUT")
+	r.Handle("/restaurants/{rid}/staff", adminOnly(withRestaurant(getRestaurantStaff))).Methods("GET")
+	r.Handle("/restaurants/{rid}/staff/{userId}", adminOnly(withRestaurant(assignRestaurantStaff))).Methods("PUT")
+	r.Handle("/restaurants/{rid}/staff/{userId}", adminOnly(withRestaurant(removeRestaurantStaff))).Methods("DELETE")
+	r.Handle("/menu/{id}/option-groups", adminOnly(createOptionGroup)).Methods("POST")
+	r.Handle("/menu/{id}/option-groups/{groupId}", adminOnly(updateOptionGroup)).Methods("PUT")
+	r.Handle("/menu/{id}/option-groups/{groupId}", adminOnly(deleteOptionGroup)).Methods("DELETE")
+	r.Handle("/order", rateLimitByRouteMiddleware(orderLimiter, authMiddleware(idempotent(http.HandlerFunc(placeOrder))))).Methods("POST")
+	r.Handle("/orders/{id}", adminOnly(deleteOrder)).Methods("DELETE")
+
+	r.Handle("/users/{id}", adminOnly(deleteUser)).Methods("DELETE")
+	r.Handle("/users/{id}/role", adminOnly(setUserRole)).Methods("PUT")
+
+	r.Handle("/orders", staffOnly(getAllOrders)).Methods("GET")
 	r.HandleFunc("/register", registerUser).Methods("POST")
 	r.HandleFunc("/confirm", confirmEmail).Methods("GET")
 	r.HandleFunc("/login", loginUser).Methods("POST")
-	r.HandleFunc("/user/cart", getUserCart).Methods("GET")
-	r.HandleFunc("/user/cart", updateUserCart).Methods("POST")
-	r.HandleFunc("/auth/check", checkAuth).Methods("GET")
-	r.HandleFunc("/register", registerUser).Methods("POST")
-	r.HandleFunc("/login", loginUser).Methods("POST")
-	r.HandleFunc("/users", getAllUsers).Methods("GET")
-	r.HandleFunc("/orders", createOrder).Methods("POST")
-	r.HandleFunc("/users/by-email", getUserByEmail).Methods("GET")
-	r.HandleFunc("/orders/by-user", getOrdersByUserID).Methods("GET")
+	r.Handle("/user/cart", authenticated(getUserCart)).Methods("GET")
+	r.Handle("/user/cart", authenticated(updateUserCart)).Methods("POST")
+	r.Handle("/cart", optionalAuth(getCart)).Methods("GET")
+	r.Handle("/cart", optionalAuth(clearCart)).Methods("DELETE")
+	r.Handle("/cart/items", optionalAuth(addCartItem)).Methods("POST")
+	r.Handle("/cart/items/{id}", optionalAuth(updateCartItem)).Methods("PATCH")
+	r.Handle("/cart/items/{id}", optionalAuth(removeCartItem)).Methods("DELETE")
+	r.Handle("/cart/checkout", authMiddleware(idempotent(http.HandlerFunc(checkoutCart)))).Methods("POST")
+	r.Handle("/auth/check", authenticated(checkAuth)).Methods("GET")
+	r.Handle("/auth/stream-token", authenticated(issueStreamToken)).Methods("POST")
+	r.HandleFunc("/auth/refresh", refreshSession).Methods("POST")
+	r.Handle("/auth/logout", authenticated(logout)).Methods("POST")
+	r.Handle("/auth/logout/all", authenticated(logoutAll)).Methods("POST")
+	r.Handle("/users/{id}/sessions", adminOnly(revokeUserSessions)).Methods("DELETE")
+	r.Handle("/auth/password/forgot", rateLimitByRouteMiddleware(passwordResetLimiter, http.HandlerFunc(forgotPassword))).Methods("POST")
+	r.HandleFunc("/auth/password/reset", passwordResetForm).Methods("GET")
+	r.HandleFunc("/auth/password/reset", resetPassword).Methods("POST")
+	r.Handle("/users", adminOnly(getAllUsers)).Methods("GET")
+	r.Handle("/orders", authMiddleware(idempotent(http.HandlerFunc(createOrder)))).Methods("POST")
+	r.HandleFunc("/orders/quote", quoteOrder).Methods("POST")
+	r.Handle("/orders/events", streamStaffOnly(streamOrderEvents)).Methods("GET")
+	r.Handle("/orders/events/ws", streamStaffOnly(streamOrderEventsWS)).Methods("GET")
+	r.Handle("/orders/{id:[0-9]+}/events", streamAuthenticated(streamOrderEvents)).Methods("GET")
+	r.Handle("/orders/{id:[0-9]+}/events/ws", streamAuthenticated(streamOrderEventsWS)).Methods("GET")
+	r.Handle("/orders/{id}/status", staffOnly(updateOrderStatus)).Methods("POST")
+	r.Handle("/orders/{id}/cancel", authenticated(cancelOwnOrder)).Methods("POST")
+	r.Handle("/orders/{id}/history", authenticated(getOrderHistory)).Methods("GET")
+	r.Handle("/orders/{id}/reject", staffOnly(rejectOrder)).Methods("POST")
+	r.Handle("/orders/{id}/refunds", staffOnly(createRefund)).Methods("POST")
+	r.Handle("/orders/{id}/refunds", authenticated(getOrderRefunds)).Methods("GET")
+	r.Handle("/orders/{id}/payments", authMiddleware(idempotent(http.HandlerFunc(payOrder)))).Methods("POST")
+	r.Handle("/orders/{id}/payments", authenticated(getOrderPayments)).Methods("GET")
+	r.HandleFunc("/payments/webhook", paymentWebhook).Methods("POST")
+	r.Handle("/users/by-email", authenticated(getUserByEmail)).Methods("GET")
+	r.Handle("/orders/by-user", authenticated(getOrdersByUserID)).Methods("GET")
 	r.HandleFunc("/support", sendSupportMessage).Methods("POST")
-	r.HandleFunc("/support/messages", getSupportMessages).Methods("GET")
-	r.HandleFunc("/support/messages", getSupportMessages).Methods("GET")
+	r.Handle("/support/messages", adminOnly(getSupportMessages)).Methods("GET")
+	r.Handle("/admin/keys/rotate", adminOnly(rotateKeys)).Methods("POST")
+	r.Handle("/admin/emails", adminOnly(getOutboxEmails)).Methods("GET")
+	r.Handle("/admin/emails/{id}/retry", adminOnly(retryOutboxEmail)).Methods("POST")
 	rateLimitedRouter := rateLimitMiddleware(r)
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Requested-With", "X-User-Email"},
-		ExposedHeaders:   []string{"Authorization"},
-		AllowCredentials: true,
+		AllowedOrigins:   cfg.Server.CORSOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "X-Requested-With", "Idempotency-Key", "Last-Event-ID", "If-Match", "If-None-Match"},
+		ExposedHeaders:   []string{"Authorization", "Retry-After", "Idempotent-Replayed", "ETag"},
+		AllowCredentials: cfg.Server.CORSCredentials,
 	})
 
 	handler := c.Handler(rateLimitedRouter)
-	fmt.Println("Server running on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	fmt.Println("Server running on", cfg.Server.Addr)
+	log.Fatal(http.ListenAndServe(cfg.Server.Addr, handler))
 }
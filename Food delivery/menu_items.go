@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	maxMenuNameLength     = 200
+	maxMenuCategoryLength = 100
+	maxMenuDescription    = 2000
+)
+
+var (
+	errMenuItemNotFound = errors.New("menu item not found")
+	errVersionMismatch  = errors.New("menu item was changed by someone else")
+	errVersionRequired  = errors.New("If-Match header or version is required")
+)
+
+// menuItemPatch - поля FoodItem, которые можно менять. nil - поле не передано.
+type menuItemPatch struct {
+	ID          *uint   `json:"id"`
+	Version     *int    `json:"version"`
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Price       *Money  `json:"price"`
+	Currency    *string `json:"currency"`
+	Category    *string `json:"category"`
+	PictureURL  *string `json:"picture_url"`
+
+	// Поля только для чтения: их присылают клиенты, которые отправляют обратно
+	// весь объект из GET. Варианты меняются через /menu/{id}/variants, модификаторы -
+	// через /menu/{id}/option-groups, остатки - через /menu/{id}/inventory; ресторан
+	// блюда не меняется.
+	RestaurantID json.RawMessage `json:"restaurant_id"`
+	UpdatedAt    json.RawMessage `json:"updated_at"`
+	Stock        json.RawMessage `json:"stock"`
+	Paused       json.RawMessage `json:"paused"`
+	LowStock     json.RawMessage `json:"low_stock_threshold"`
+	SoldOut      json.RawMessage `json:"sold_out"`
+	Available    json.RawMessage `json:"available"`
+	AvailableAt  json.RawMessage `json:"available_from"`
+	Variants     json.RawMessage `json:"variants"`
+	OptionGroups json.RawMessage `json:"option_groups"`
+}
+
+// encodeMenuItem кодирует блюдо и строит его ETag "id-version-hash". If-Match
+// сверяет только версию, а хеш тела нужен для If-None-Match: sold_out, stock и
+// available меняются без новой версии (заказы, время, правила категории и ресторана).
+func encodeMenuItem(item *FoodItem) ([]byte, string, error) {
+	body, err := json.Marshal(item)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(body)
+	return body, fmt.Sprintf("\"%d-%d-%x\"", item.ID, item.Version, sum[:8]), nil
+}
+
+// parseETagVersion достает версию из If-Match вида "id-version-hash" или старого
+// "id-version" (W/ допускается).
+func parseETagVersion(header string, id uint) (int, bool) {
+	tag := strings.TrimPrefix(strings.TrimSpace(header), "W/")
+	tag = strings.Trim(tag, "\"")
+	idPart, rest, ok := strings.Cut(tag, "-")
+	if !ok || idPart != strconv.FormatUint(uint64(id), 10) {
+		return 0, false
+	}
+	versionPart, _, _ := strings.Cut(rest, "-")
+	version, err := strconv.Atoi(versionPart)
+	return version, err == nil
+}
+
+// validateFoodItem проверяет поля блюда перед сохранением.
+func validateFoodItem(item *FoodItem) error {
+	item.Name = strings.TrimSpace(item.Name)
+	item.Category = strings.TrimSpace(item.Category)
+	switch {
+	case item.Name == "":
+		return errors.New("name is required")
+	case utf8.RuneCountInString(item.Name) > maxMenuNameLength:
+		return fmt.Errorf("name must be at most %d characters", maxMenuNameLength)
+	case utf8.RuneCountInString(item.Description) > maxMenuDescription:
+		return fmt.Errorf("description must be at most %d characters", maxMenuDescription)
+	case utf8.RuneCountInString(item.Category) > maxMenuCategoryLength:
+		return fmt.Errorf("category must be at most %d characters", maxMenuCategoryLength)
+	case item.Price < 0:
+		return errors.New("price must be non-negative")
+	case item.Stock != nil && *item.Stock < 0, item.LowStockThreshold < 0:
+		return errors.New("stock and low_stock_threshold must be non-negative")
+	case item.Currency != cfg.Pricing.Currency:
+		return fmt.Errorf("currency must be %s", cfg.Pricing.Currency)
+	}
+	if item.PictureURL != "" {
+		u, err := url.Parse(item.PictureURL)
+		if err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("picture_url must be an http(s) URL or a relative path")
+		}
+	}
+	return nil
+}
+
+// apply переносит переданные поля в блюдо. full = true (PUT) требует все
+// обязательные поля, остальные непереданные поля очищаются.
+func (p *menuItemPatch) apply(item *FoodItem, full bool) error {
+	if full {
+		if p.Name == nil || p.Price == nil {
+			return errors.New("name and price are required")
+		}
+		*item = FoodItem{ID: item.ID, Version: item.Version, Currency: cfg.Pricing.Currency, RestaurantID: item.RestaurantID}
+	}
+	if p.Name != nil {
+		item.Name = *p.Name
+	}
+	if p.Description != nil {
+		item.Description = *p.Description
+	}
+	if p.Price != nil {
+		item.Price = *p.Price
+	}
+	if p.Currency != nil {
+		item.Currency = strings.ToUpper(*p.Currency)
+	}
+	if p.Category != nil {
+		item.Category = *p.Category
+	}
+	if p.PictureURL != nil {
+		item.PictureURL = *p.PictureURL
+	}
+	return validateFoodItem(item)
+}
+
+// updateFoodItem сохраняет блюдо, если его версия в базе все еще expected.
+// Цены в корзинах обновятся при следующем просмотре, позиции старых заказов
+// хранят свою копию и не меняются.
+func updateFoodItem(item *FoodItem, expected int) error {
+	res := db.Model(&FoodItem{}).
+		Where("id = ? AND version = ?", item.ID, expected).
+		Updates(map[string]interface{}{
+			"name":        item.Name,
+			"description": item.Description,
+			"price":       item.Price,
+			"currency":    item.Currency,
+			"category":    item.Category,
+			"picture_url": item.PictureURL,
+			"version":     gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var exists int64
+		if err := db.Model(&FoodItem{}).Where("id = ?", item.ID).Count(&exists).Error; err != nil {
+			return err
+		}
+		if exists == 0 {
+			return errMenuItemNotFound
+		}
+		return errVersionMismatch
+	}
+	return withMenuDetails(db).First(item, item.ID).Error
+}
+
+// writeMenuItem отвечает блюдом с его ETag.
+func writeMenuItem(w http.ResponseWriter, status int, item *FoodItem) {
+	body, etag, err := encodeMenuItem(item)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to encode menu item", err)
+		return
+	}
+	writeMenuItemBody(w, status, body, etag)
+}
+
+func writeMenuItemBody(w http.ResponseWriter, status int, body []byte, etag string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// editMenuItem - общая часть PUT и PATCH /menu/{id}. Версия берется из If-Match
+// или из поля version; без нее - 428, с устаревшей - 412 и текущее блюдо.
+func editMenuItem(w http.ResponseWriter, r *http.Request, full bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var patch menuItemPatch
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patch); err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid input", err)
+		return
+	}
+	if patch.ID != nil && *patch.ID != uint(id) {
+		http.Error(w, "ID in body does not match URL", http.StatusBadRequest)
+		return
+	}
+
+	var item FoodItem
+	if err := db.First(&item, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Menu item not found", http.StatusNotFound)
+			return
+		}
+		handleError(w, http.StatusInternalServerError, "Failed to fetch menu item", err)
+		return
+	}
+
+	expected, ok := 0, false
+	if header := r.Header.Get("If-Match"); header != "" {
+		if expected, ok = parseETagVersion(header, item.ID); !ok {
+			// Чужой или испорченный ETag не совпадает ни с одной версией.
+			writeMenuItem(w, http.StatusPreconditionFailed, &item)
+			return
+		}
+	} else if patch.Version != nil {
+		expected, ok = *patch.Version, true
+	}
+	if !ok {
+		handleError(w, http.StatusPreconditionRequired, errVersionRequired.Error(), errVersionRequired)
+		return
+	}
+	if expected != item.Version {
+		writeMenuItem(w, http.StatusPreconditionFailed, &item)
+		return
+	}
+
+	if err := patch.apply(&item, full); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	switch err := updateFoodItem(&item, expected); {
+	case errors.Is(err, errMenuItemNotFound):
+		http.Error(w, "Menu item not found", http.StatusNotFound)
+	case errors.Is(err, errVersionMismatch):
+		var current FoodItem
+		if err := db.First(&current, id).Error; err != nil {
+			handleError(w, http.StatusInternalServerError, "Failed to fetch menu item", err)
+			return
+		}
+		writeMenuItem(w, http.StatusPreconditionFailed, &current)
+	case err != nil:
+		handleError(w, http.StatusInternalServerError, "Failed to update menu item", err)
+	default:
+		writeMenuItem(w, http.StatusOK, &item)
+	}
+}
+
+// putMenuItem - PUT /menu/{id}: полная замена полей блюда.
+func putMenuItem(w http.ResponseWriter, r *http.Request) {
+	editMenuItem(w, r, true)
+}
+
+// patchMenuItem - PATCH /menu/{id}: меняет только переданные поля.
+func patchMenuItem(w http.ResponseWriter, r *http.Request) {
+	editMenuItem(w, r, false)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMenuItemETagCoversComputedFields(t *testing.T) {
+	base := FoodItem{ID: 7, Name: "Soup", Price: 500, Version: 3, Stock: intPtr(5), Available: true}
+	etag := func(item FoodItem) string {
+		t.Helper()
+		_, tag, err := encodeMenuItem(&item)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tag
+	}
+	opens := time.Date(2026, 3, 4, 8, 0, 0, 0, time.UTC)
+	changes := map[string]func(*FoodItem){
+		"stock":          func(i *FoodItem) { i.Stock = intPtr(4) },
+		"sold out":       func(i *FoodItem) { i.SoldOut = true },
+		"available":      func(i *FoodItem) { i.Available = false },
+		"available from": func(i *FoodItem) { i.AvailableFrom = &opens },
+		"version":        func(i *FoodItem) { i.Version++ },
+	}
+	for name, change := range changes {
+		t.Run(name, func(t *testing.T) {
+			item := base
+			change(&item)
+			if etag(item) == etag(base) {
+				t.Errorf("ETag did not change when %s changed", name)
+			}
+			if version, ok := parseETagVersion(etag(item), item.ID); !ok || version != item.Version {
+				t.Errorf("parseETagVersion = %d, %v; want %d", version, ok, item.Version)
+			}
+		})
+	}
+	if etag(base) != etag(base) {
+		t.Error("ETag is not stable for the same item")
+	}
+}
+
+func TestParseETagVersion(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int
+		wantOK bool
+	}{
+		{`"7-3-0011223344556677"`, 3, true},
+		{`W/"7-3-0011223344556677"`, 3, true},
+		{`"7-3"`, 3, true},
+		{`"8-3-0011223344556677"`, 0, false},
+		{`"7-x-0011223344556677"`, 0, false},
+		{`*`, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseETagVersion(tt.header, 7)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseETagVersion(%s) = %d, %v; want %d, %v", tt.header, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestEditMenuItemPreconditions(t *testing.T) {
+	openTestDB(t)
+	soup := createTestItem(t, FoodItem{Name: "Soup", Description: "Hot", Price: 500})
+	if err := db.First(&soup, soup.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	_, etag, err := encodeMenuItem(&soup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit := func(handler http.HandlerFunc, ifMatch, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/menu/%d", soup.ID), strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(int(soup.ID))})
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	stored := func() FoodItem {
+		t.Helper()
+		var item FoodItem
+		if err := db.First(&item, soup.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		return item
+	}
+
+	if w := edit(patchMenuItem, "", `{"price": 600}`); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("no version: status = %d, want 428", w.Code)
+	}
+	stale := fmt.Sprintf(`"%d-%d"`, soup.ID, soup.Version-1)
+	foreign := fmt.Sprintf(`"%d-%d"`, soup.ID+1, soup.Version)
+	for name, tag := range map[string]string{"stale": stale, "foreign": foreign, "garbage": `"soup"`} {
+		w := edit(patchMenuItem, tag, `{"price": 600}`)
+		if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != etag {
+			t.Errorf("%s If-Match: status = %d, ETag %s, want 412 with the current ETag %s", name, w.Code, w.Header().Get("ETag"), etag)
+		}
+	}
+	if item := stored(); item.Price != 500 || item.Version != soup.Version {
+		t.Fatalf("rejected edits changed the item: %+v", item)
+	}
+
+	// PATCH меняет только переданное поле и версию.
+	w := edit(patchMenuItem, etag, `{"price": 600}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch: status = %d: %s", w.Code, w.Body)
+	}
+	item := stored()
+	if item.Price != 600 || item.Description != "Hot" || item.Version != soup.Version+1 {
+		t.Errorf("after patch = %+v, want price 6.00, description kept, version %d", item, soup.Version+1)
+	}
+	if w.Header().Get("ETag") == etag {
+		t.Error("ETag did not change after the update")
+	}
+
+	// Второй редактор с тем же ETag опоздал.
+	if w := edit(patchMenuItem, etag, `{"name": "Borscht"}`); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("lost update: status = %d, want 412", w.Code)
+	}
+	if stored().Name != "Soup" {
+		t.Error("lost update was saved")
+	}
+
+	// Версию можно передать в теле вместо If-Match; PUT очищает непереданные поля.
+	w = edit(putMenuItem, "", fmt.Sprintf(`{"name": "Borscht", "price": 700, "version": %d}`, item.Version))
+	if w.Code != http.StatusOK {
+		t.Fatalf("put: status = %d: %s", w.Code, w.Body)
+	}
+	if item := stored(); item.Name != "Borscht" || item.Description != "" || item.Price != 700 {
+		t.Errorf("after put = %+v, want Borscht 7.00 without description", item)
+	}
+}
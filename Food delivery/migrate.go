@@ -0,0 +1,258 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// Файлы миграций: migrations/NNNN_name.up.sql и NNNN_name.down.sql.
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration - строка в schema_migrations для каждой примененной миграции.
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		m := migrationFileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		data, err := migrationFS.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down files", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func appliedMigrations() (map[int]SchemaMigration, error) {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	)`).Error
+	if err != nil {
+		return nil, err
+	}
+	var rows []SchemaMigration
+	if err := db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// migrateUp применяет до steps непримененных миграций (steps <= 0 - все).
+// Каждая миграция выполняется в своей транзакции вместе с записью в schema_migrations.
+func migrateUp(steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	done := 0
+	for _, mig := range migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		if steps > 0 && done == steps {
+			break
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mig.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s up: %w", mig.Version, mig.Name, err)
+		}
+		fmt.Printf("Applied %04d_%s\n", mig.Version, mig.Name)
+		done++
+	}
+	if done == 0 {
+		fmt.Println("Schema is up to date")
+	}
+	return nil
+}
+
+// migrateDown откатывает steps последних примененных миграций.
+func migrateDown(steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		mig := migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(mig.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, mig.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s down: %w", mig.Version, mig.Name, err)
+		}
+		fmt.Printf("Reverted %04d_%s\n", mig.Version, mig.Name)
+		steps--
+	}
+	return nil
+}
+
+func printMigrationStatus() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, mig := range migrations {
+		status := "pending"
+		if row, ok := applied[mig.Version]; ok {
+			status = "applied " + row.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d_%-40s %s\n", mig.Version, mig.Name, status)
+	}
+	for version, row := range applied {
+		if !hasMigration(migrations, version) {
+			fmt.Printf("%04d_%-40s applied, but missing from this binary\n", version, row.Name)
+		}
+	}
+	return nil
+}
+
+func hasMigration(migrations []migration, version int) bool {
+	for _, mig := range migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSchemaVersion не дает серверу стартовать, если схема базы не совпадает
+// с миграциями, вшитыми в бинарник.
+func checkSchemaVersion() error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+	var pending, unknown []string
+	for _, mig := range migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, fmt.Sprintf("%04d_%s", mig.Version, mig.Name))
+		}
+	}
+	for version, row := range applied {
+		if !hasMigration(migrations, version) {
+			unknown = append(unknown, fmt.Sprintf("%04d_%s", version, row.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind, run `migrate up` (pending: %s)", strings.Join(pending, ", "))
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("database schema is newer than this binary (unknown: %s)", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// migrateCommand - `go run . migrate up [N] | down [N] | status`.
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up [N] | down [N] | status")
+	}
+	steps := 0
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of steps %q", args[1])
+		}
+		steps = n
+	}
+
+	connectDatabase(cfg.Database)
+	switch args[0] {
+	case "up":
+		return migrateUp(steps)
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		return migrateDown(steps)
+	case "status":
+		return printMigrationStatus()
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
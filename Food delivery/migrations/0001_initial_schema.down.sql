@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS support_messages;
+DROP TABLE IF EXISTS user_cart_items;
+DROP TABLE IF EXISTS order_food_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS food_items;
+DROP TABLE IF EXISTS users;
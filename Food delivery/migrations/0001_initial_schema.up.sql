@@ -0,0 +1,48 @@
+-- Схема, которую раньше создавал AutoMigrate. IF NOT EXISTS позволяет
+-- применить миграцию к уже существующей базе.
+CREATE TABLE IF NOT EXISTS users (
+    id              BIGSERIAL PRIMARY KEY,
+    name            TEXT,
+    email           TEXT NOT NULL CONSTRAINT uni_users_email UNIQUE,
+    phone           TEXT,
+    password        TEXT,
+    role            TEXT,
+    email_confirmed BOOLEAN
+);
+
+CREATE TABLE IF NOT EXISTS food_items (
+    id          BIGSERIAL PRIMARY KEY,
+    name        TEXT,
+    description TEXT,
+    price       NUMERIC,
+    category    TEXT,
+    picture_url TEXT
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id       BIGSERIAL PRIMARY KEY,
+    customer TEXT,
+    address  TEXT,
+    total    NUMERIC,
+    user_id  BIGINT CONSTRAINT fk_users_orders REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS order_food_items (
+    order_id     BIGINT CONSTRAINT fk_order_food_items_order REFERENCES orders (id) ON DELETE CASCADE,
+    food_item_id BIGINT CONSTRAINT fk_order_food_items_food_item REFERENCES food_items (id) ON DELETE CASCADE,
+    PRIMARY KEY (order_id, food_item_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_cart_items (
+    user_id      BIGINT CONSTRAINT fk_user_cart_items_user REFERENCES users (id) ON DELETE CASCADE,
+    food_item_id BIGINT CONSTRAINT fk_user_cart_items_food_item REFERENCES food_items (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, food_item_id)
+);
+
+CREATE TABLE IF NOT EXISTS support_messages (
+    id          BIGSERIAL PRIMARY KEY,
+    email       TEXT,
+    message     TEXT,
+    attachments TEXT,
+    created_at  TIMESTAMPTZ
+);
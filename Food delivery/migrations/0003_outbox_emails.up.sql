@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS outbox_emails (
+    id              BIGSERIAL PRIMARY KEY,
+    kind            TEXT,
+    "from"          TEXT,
+    "to"            TEXT,
+    subject         TEXT,
+    html_body       TEXT,
+    text_body       TEXT,
+    attachments     TEXT,
+    status          TEXT NOT NULL DEFAULT 'pending',
+    attempts        BIGINT,
+    next_attempt_at TIMESTAMPTZ,
+    last_error      TEXT,
+    sent_at         TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_kind ON outbox_emails (kind);
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_status ON outbox_emails (status);
+CREATE INDEX IF NOT EXISTS idx_outbox_emails_next_attempt_at ON outbox_emails (next_attempt_at);
@@ -0,0 +1,12 @@
+CREATE TABLE order_food_items (
+    order_id     BIGINT CONSTRAINT fk_order_food_items_order REFERENCES orders (id) ON DELETE CASCADE,
+    food_item_id BIGINT CONSTRAINT fk_order_food_items_food_item REFERENCES food_items (id) ON DELETE CASCADE,
+    PRIMARY KEY (order_id, food_item_id)
+);
+
+INSERT INTO order_food_items (order_id, food_item_id)
+SELECT DISTINCT order_id, food_item_id
+FROM order_lines
+WHERE food_item_id IS NOT NULL;
+
+DROP TABLE order_lines;
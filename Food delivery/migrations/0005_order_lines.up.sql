@@ -0,0 +1,21 @@
+-- Позиции заказа с количеством и копией цены вместо many2many order_food_items.
+CREATE TABLE order_lines (
+    id           BIGSERIAL PRIMARY KEY,
+    order_id     BIGINT NOT NULL CONSTRAINT fk_orders_lines REFERENCES orders (id) ON DELETE CASCADE,
+    food_item_id BIGINT CONSTRAINT fk_order_lines_food_item REFERENCES food_items (id) ON DELETE SET NULL,
+    name         TEXT NOT NULL,
+    unit_price   NUMERIC NOT NULL,
+    quantity     BIGINT NOT NULL CHECK (quantity > 0),
+    line_total   NUMERIC NOT NULL,
+    created_at   TIMESTAMPTZ
+);
+CREATE INDEX idx_order_lines_order_id ON order_lines (order_id);
+CREATE INDEX idx_order_lines_food_item_id ON order_lines (food_item_id);
+
+-- Для старых заказов берем текущую цену блюда: другой истории у нас нет.
+INSERT INTO order_lines (order_id, food_item_id, name, unit_price, quantity, line_total, created_at)
+SELECT ofi.order_id, fi.id, COALESCE(fi.name, ''), COALESCE(fi.price, 0), 1, COALESCE(fi.price, 0), NOW()
+FROM order_food_items ofi
+JOIN food_items fi ON fi.id = ofi.food_item_id;
+
+DROP TABLE order_food_items;
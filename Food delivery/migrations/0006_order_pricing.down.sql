@@ -0,0 +1,6 @@
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS promo_code,
+    DROP COLUMN IF EXISTS delivery_fee,
+    DROP COLUMN IF EXISTS tax,
+    DROP COLUMN IF EXISTS discount,
+    DROP COLUMN IF EXISTS subtotal;
@@ -0,0 +1,11 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS subtotal     NUMERIC NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS discount     NUMERIC NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS tax          NUMERIC NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS delivery_fee NUMERIC NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS promo_code   TEXT NOT NULL DEFAULT '';
+
+-- Для старых заказов подытог считаем по позициям, остаток итога относим к доставке.
+UPDATE orders o
+SET subtotal = COALESCE((SELECT SUM(l.line_total) FROM order_lines l WHERE l.order_id = o.id), 0);
+UPDATE orders SET delivery_fee = GREATEST(total - subtotal, 0);
@@ -0,0 +1,15 @@
+ALTER TABLE order_lines
+    ALTER COLUMN unit_price TYPE NUMERIC USING unit_price / 100.0,
+    ALTER COLUMN line_total TYPE NUMERIC USING line_total / 100.0;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN subtotal     TYPE NUMERIC USING subtotal / 100.0,
+    ALTER COLUMN discount     TYPE NUMERIC USING discount / 100.0,
+    ALTER COLUMN tax          TYPE NUMERIC USING tax / 100.0,
+    ALTER COLUMN delivery_fee TYPE NUMERIC USING delivery_fee / 100.0,
+    ALTER COLUMN total        TYPE NUMERIC USING total / 100.0;
+
+ALTER TABLE food_items
+    DROP COLUMN IF EXISTS currency,
+    ALTER COLUMN price TYPE NUMERIC USING price / 100.0;
@@ -0,0 +1,16 @@
+-- Суммы хранятся в минимальных единицах валюты (центах), округление половиной вверх.
+ALTER TABLE food_items
+    ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+ALTER TABLE orders
+    ALTER COLUMN subtotal     TYPE BIGINT USING ROUND(subtotal * 100)::BIGINT,
+    ALTER COLUMN discount     TYPE BIGINT USING ROUND(discount * 100)::BIGINT,
+    ALTER COLUMN tax          TYPE BIGINT USING ROUND(tax * 100)::BIGINT,
+    ALTER COLUMN delivery_fee TYPE BIGINT USING ROUND(delivery_fee * 100)::BIGINT,
+    ALTER COLUMN total        TYPE BIGINT USING ROUND(total * 100)::BIGINT,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+
+ALTER TABLE order_lines
+    ALTER COLUMN unit_price TYPE BIGINT USING ROUND(unit_price * 100)::BIGINT,
+    ALTER COLUMN line_total TYPE BIGINT USING ROUND(line_total * 100)::BIGINT;
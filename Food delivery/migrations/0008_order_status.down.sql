@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS order_status_events;
+
+DROP INDEX IF EXISTS idx_orders_status;
+
+ALTER TABLE orders
+    DROP CONSTRAINT IF EXISTS orders_status_check,
+    DROP COLUMN IF EXISTS status_updated_at,
+    DROP COLUMN IF EXISTS status;
@@ -0,0 +1,28 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS status            TEXT NOT NULL DEFAULT 'placed',
+    ADD COLUMN IF NOT EXISTS status_updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'placed', 'accepted', 'preparing', 'ready', 'out_for_delivery', 'delivered', 'cancelled'
+));
+
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+
+CREATE TABLE IF NOT EXISTS order_status_events (
+    id          BIGSERIAL PRIMARY KEY,
+    order_id    BIGINT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    from_status TEXT NOT NULL DEFAULT '',
+    to_status   TEXT NOT NULL,
+    actor_id    BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    actor_role  TEXT NOT NULL DEFAULT '',
+    note        TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_events_order_id ON order_status_events (order_id);
+
+-- У существующих заказов история начинается с placed от имени покупателя.
+INSERT INTO order_status_events (order_id, from_status, to_status, actor_id, actor_role, created_at)
+SELECT o.id, '', 'placed', u.id, COALESCE(u.role, ''), NOW()
+FROM orders o
+LEFT JOIN users u ON u.id = o.user_id;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS processed_webhook_events;
+DROP TABLE IF EXISTS payments;
+ALTER TABLE orders DROP COLUMN IF EXISTS payment_status;
@@ -0,0 +1,27 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_status TEXT NOT NULL DEFAULT 'unpaid';
+
+CREATE TABLE IF NOT EXISTS payments (
+    id              BIGSERIAL PRIMARY KEY,
+    order_id        BIGINT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    provider        TEXT NOT NULL,
+    reference       TEXT NOT NULL DEFAULT '',
+    status          TEXT NOT NULL,
+    amount          BIGINT NOT NULL,
+    currency        CHAR(3) NOT NULL,
+    refunded_amount BIGINT NOT NULL DEFAULT 0,
+    failure_reason  TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ,
+    CONSTRAINT payments_status_check CHECK (status IN ('pending', 'authorized', 'captured', 'refunded', 'voided', 'failed'))
+);
+CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments (order_id);
+CREATE INDEX IF NOT EXISTS idx_payments_reference ON payments (provider, reference);
+-- Не больше одного активного платежа на заказ.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_active_order ON payments (order_id)
+    WHERE status IN ('pending', 'authorized', 'captured');
+
+CREATE TABLE IF NOT EXISTS processed_webhook_events (
+    id          TEXT PRIMARY KEY,
+    type        TEXT NOT NULL DEFAULT '',
+    received_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS refund_lines;
+DROP TABLE IF EXISTS refunds;
+
+UPDATE payments SET status = 'captured' WHERE status = 'partially_refunded';
+DROP INDEX IF EXISTS idx_payments_active_order;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_active_order ON payments (order_id)
+    WHERE status IN ('pending', 'authorized', 'captured');
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'authorized', 'captured', 'refunded', 'voided', 'failed'));
+
+ALTER TABLE order_lines DROP CONSTRAINT IF EXISTS order_lines_cancelled_quantity_check;
+ALTER TABLE order_lines DROP COLUMN IF EXISTS cancelled_quantity;
+
+DROP INDEX IF EXISTS idx_orders_deleted_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS cancel_reason;
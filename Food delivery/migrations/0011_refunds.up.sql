@@ -0,0 +1,40 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS cancel_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at);
+
+ALTER TABLE order_lines ADD COLUMN IF NOT EXISTS cancelled_quantity INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE order_lines ADD CONSTRAINT order_lines_cancelled_quantity_check
+    CHECK (cancelled_quantity >= 0 AND cancelled_quantity <= quantity);
+
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_status_check;
+ALTER TABLE payments ADD CONSTRAINT payments_status_check
+    CHECK (status IN ('pending', 'authorized', 'captured', 'partially_refunded', 'refunded', 'voided', 'failed'));
+DROP INDEX IF EXISTS idx_payments_active_order;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_active_order ON payments (order_id)
+    WHERE status IN ('pending', 'authorized', 'captured', 'partially_refunded');
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id             BIGSERIAL PRIMARY KEY,
+    payment_id     BIGINT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    order_id       BIGINT NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+    amount         BIGINT NOT NULL CHECK (amount > 0),
+    currency       CHAR(3) NOT NULL,
+    reason         TEXT NOT NULL,
+    note           TEXT NOT NULL DEFAULT '',
+    status         TEXT NOT NULL CHECK (status IN ('pending', 'succeeded', 'failed')),
+    failure_reason TEXT NOT NULL DEFAULT '',
+    actor_id       BIGINT,
+    created_at     TIMESTAMPTZ,
+    updated_at     TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_refunds_payment_id ON refunds (payment_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds (order_id);
+
+CREATE TABLE IF NOT EXISTS refund_lines (
+    id            BIGSERIAL PRIMARY KEY,
+    refund_id     BIGINT NOT NULL REFERENCES refunds (id) ON DELETE CASCADE,
+    order_line_id BIGINT NOT NULL REFERENCES order_lines (id) ON DELETE CASCADE,
+    quantity      INTEGER NOT NULL CHECK (quantity > 0),
+    amount        BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_refund_lines_refund_id ON refund_lines (refund_id);
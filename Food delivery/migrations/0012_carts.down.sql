@@ -0,0 +1,14 @@
+CREATE TABLE user_cart_items (
+    user_id      BIGINT CONSTRAINT fk_user_cart_items_user REFERENCES users (id) ON DELETE CASCADE,
+    food_item_id BIGINT CONSTRAINT fk_user_cart_items_food_item REFERENCES food_items (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, food_item_id)
+);
+
+INSERT INTO user_cart_items (user_id, food_item_id)
+SELECT DISTINCT c.user_id, ci.food_item_id
+FROM cart_items ci
+JOIN carts c ON c.id = ci.cart_id
+WHERE c.user_id IS NOT NULL AND ci.food_item_id IS NOT NULL;
+
+DROP TABLE cart_items;
+DROP TABLE carts;
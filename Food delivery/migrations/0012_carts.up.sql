@@ -0,0 +1,31 @@
+-- Серверная корзина с количеством и ценой вместо many2many user_cart_items.
+CREATE TABLE carts (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT CONSTRAINT fk_carts_user REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_carts_user_id ON carts (user_id);
+
+CREATE TABLE cart_items (
+    id           BIGSERIAL PRIMARY KEY,
+    cart_id      BIGINT NOT NULL CONSTRAINT fk_carts_items REFERENCES carts (id) ON DELETE CASCADE,
+    food_item_id BIGINT CONSTRAINT fk_cart_items_food_item REFERENCES food_items (id) ON DELETE SET NULL,
+    name         TEXT NOT NULL,
+    unit_price   BIGINT NOT NULL,
+    quantity     INTEGER NOT NULL CHECK (quantity > 0),
+    created_at   TIMESTAMPTZ,
+    updated_at   TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_cart_items_cart_food ON cart_items (cart_id, food_item_id);
+
+INSERT INTO carts (user_id, created_at, updated_at)
+SELECT DISTINCT user_id, NOW(), NOW() FROM user_cart_items WHERE user_id IS NOT NULL;
+
+INSERT INTO cart_items (cart_id, food_item_id, name, unit_price, quantity, created_at, updated_at)
+SELECT c.id, f.id, f.name, f.price, 1, NOW(), NOW()
+FROM user_cart_items uci
+JOIN carts c ON c.user_id = uci.user_id
+JOIN food_items f ON f.id = uci.food_item_id;
+
+DROP TABLE user_cart_items;
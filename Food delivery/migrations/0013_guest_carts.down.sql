@@ -0,0 +1,6 @@
+DELETE FROM carts WHERE user_id IS NULL;
+ALTER TABLE carts DROP CONSTRAINT IF EXISTS carts_owner_check;
+DROP INDEX IF EXISTS idx_carts_expires_at;
+DROP INDEX IF EXISTS idx_carts_token_hash;
+ALTER TABLE carts DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE carts DROP COLUMN IF EXISTS token_hash;
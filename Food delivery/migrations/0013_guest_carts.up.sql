@@ -0,0 +1,6 @@
+-- Корзины гостей: без пользователя, по хэшу токена из cookie, со сроком жизни.
+ALTER TABLE carts ADD COLUMN token_hash TEXT;
+ALTER TABLE carts ADD COLUMN expires_at TIMESTAMPTZ;
+CREATE UNIQUE INDEX idx_carts_token_hash ON carts (token_hash);
+CREATE INDEX idx_carts_expires_at ON carts (expires_at);
+ALTER TABLE carts ADD CONSTRAINT carts_owner_check CHECK (user_id IS NOT NULL OR token_hash IS NOT NULL);
@@ -0,0 +1,2 @@
+ALTER TABLE food_items DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE food_items DROP COLUMN IF EXISTS version;
@@ -0,0 +1,3 @@
+-- Версия блюда для If-Match/ETag: правка проходит, только если версия не изменилась.
+ALTER TABLE food_items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE food_items ADD COLUMN updated_at TIMESTAMPTZ;
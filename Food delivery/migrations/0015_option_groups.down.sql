@@ -0,0 +1,10 @@
+-- Без опций у блюда остается одна позиция на корзину: лишние удаляются.
+DELETE FROM cart_items ci USING cart_items other
+WHERE ci.cart_id = other.cart_id AND ci.food_item_id = other.food_item_id AND ci.id > other.id;
+DROP INDEX IF EXISTS idx_cart_items_cart_food;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS options_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_cart_food ON cart_items (cart_id, food_item_id);
+
+DROP TABLE IF EXISTS order_line_options;
+DROP TABLE IF EXISTS menu_options;
+DROP TABLE IF EXISTS option_groups;
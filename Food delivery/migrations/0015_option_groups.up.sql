@@ -0,0 +1,36 @@
+-- Модификаторы блюд: группы опций с правилами выбора и доплатой за опцию.
+CREATE TABLE option_groups (
+    id           BIGSERIAL PRIMARY KEY,
+    food_item_id BIGINT NOT NULL CONSTRAINT fk_food_items_option_groups REFERENCES food_items (id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    required     BOOLEAN NOT NULL DEFAULT FALSE,
+    min_select   INTEGER NOT NULL DEFAULT 0 CHECK (min_select >= 0),
+    max_select   INTEGER NOT NULL CHECK (max_select >= min_select),
+    position     INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_option_groups_food_item_id ON option_groups (food_item_id);
+
+CREATE TABLE menu_options (
+    id       BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL CONSTRAINT fk_option_groups_options REFERENCES option_groups (id) ON DELETE CASCADE,
+    name     TEXT NOT NULL,
+    price    BIGINT NOT NULL DEFAULT 0 CHECK (price >= 0),
+    position INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_menu_options_group_id ON menu_options (group_id);
+
+-- Выбранные опции позиции заказа: копия названия и доплаты на момент заказа.
+CREATE TABLE order_line_options (
+    id            BIGSERIAL PRIMARY KEY,
+    order_line_id BIGINT NOT NULL CONSTRAINT fk_order_lines_options REFERENCES order_lines (id) ON DELETE CASCADE,
+    option_id     BIGINT CONSTRAINT fk_order_line_options_option REFERENCES menu_options (id) ON DELETE SET NULL,
+    group_name    TEXT NOT NULL,
+    name          TEXT NOT NULL,
+    price         BIGINT NOT NULL
+);
+CREATE INDEX idx_order_line_options_order_line_id ON order_line_options (order_line_id);
+
+-- Одно блюдо с разными опциями - разные позиции корзины.
+ALTER TABLE cart_items ADD COLUMN options_key TEXT NOT NULL DEFAULT '';
+DROP INDEX idx_cart_items_cart_food;
+CREATE UNIQUE INDEX idx_cart_items_cart_food ON cart_items (cart_id, food_item_id, options_key);
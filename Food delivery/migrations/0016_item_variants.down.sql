@@ -0,0 +1,11 @@
+DELETE FROM cart_items ci USING cart_items other
+WHERE ci.cart_id = other.cart_id AND ci.food_item_id = other.food_item_id
+  AND ci.options_key = other.options_key AND ci.id > other.id;
+DROP INDEX IF EXISTS idx_cart_items_cart_food;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS variant_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_cart_food ON cart_items (cart_id, food_item_id, options_key);
+
+ALTER TABLE order_lines DROP COLUMN IF EXISTS sku;
+ALTER TABLE order_lines DROP COLUMN IF EXISTS variant_id;
+
+DROP TABLE IF EXISTS item_variants;
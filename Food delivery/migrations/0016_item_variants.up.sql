@@ -0,0 +1,19 @@
+-- Размеры блюд: у каждого варианта своя цена и артикул.
+CREATE TABLE item_variants (
+    id           BIGSERIAL PRIMARY KEY,
+    food_item_id BIGINT NOT NULL CONSTRAINT fk_food_items_variants REFERENCES food_items (id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    sku          TEXT NOT NULL,
+    price        BIGINT NOT NULL CHECK (price >= 0),
+    position     INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX idx_item_variants_food_item_id ON item_variants (food_item_id);
+CREATE UNIQUE INDEX idx_item_variants_sku ON item_variants (sku);
+
+ALTER TABLE order_lines ADD COLUMN variant_id BIGINT CONSTRAINT fk_order_lines_variant REFERENCES item_variants (id) ON DELETE SET NULL;
+ALTER TABLE order_lines ADD COLUMN sku TEXT NOT NULL DEFAULT '';
+
+-- 0 - блюдо без вариантов; NULL не подходит, потому что не участвует в уникальности.
+ALTER TABLE cart_items ADD COLUMN variant_id BIGINT NOT NULL DEFAULT 0;
+DROP INDEX idx_cart_items_cart_food;
+CREATE UNIQUE INDEX idx_cart_items_cart_food ON cart_items (cart_id, food_item_id, variant_id, options_key);
@@ -0,0 +1,3 @@
+ALTER TABLE food_items DROP COLUMN IF EXISTS low_stock_threshold;
+ALTER TABLE food_items DROP COLUMN IF EXISTS paused;
+ALTER TABLE food_items DROP COLUMN IF EXISTS stock;
@@ -0,0 +1,4 @@
+-- Остатки блюд: stock = NULL - учет не ведется; paused - блюдо снято с продажи вручную.
+ALTER TABLE food_items ADD COLUMN stock INTEGER CHECK (stock >= 0);
+ALTER TABLE food_items ADD COLUMN paused BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE food_items ADD COLUMN low_stock_threshold INTEGER NOT NULL DEFAULT 5 CHECK (low_stock_threshold >= 0);
@@ -0,0 +1,17 @@
+-- Расписание доступности блюд и категорий. days - битовая маска дней недели
+-- (бит 0 - воскресенье), 0 - каждый день; start/end - "HH:MM" в timezone.
+CREATE TABLE availability_rules (
+    id           BIGSERIAL PRIMARY KEY,
+    food_item_id BIGINT CONSTRAINT fk_availability_rules_food_item REFERENCES food_items (id) ON DELETE CASCADE,
+    category     TEXT,
+    days         SMALLINT NOT NULL DEFAULT 0 CHECK (days BETWEEN 0 AND 127),
+    start        TEXT NOT NULL,
+    "end"        TEXT NOT NULL,
+    timezone     TEXT NOT NULL,
+    CHECK ((food_item_id IS NULL) <> (category IS NULL))
+);
+CREATE INDEX idx_availability_rules_food_item_id ON availability_rules (food_item_id);
+CREATE INDEX idx_availability_rules_category ON availability_rules (category);
+
+INSERT INTO availability_rules (food_item_id, start, "end", timezone)
+SELECT id, '07:00', '11:30', 'UTC' FROM food_items WHERE name = 'Pancakes';
@@ -0,0 +1,10 @@
+DELETE FROM availability_rules WHERE restaurant_id IS NOT NULL;
+ALTER TABLE availability_rules DROP CONSTRAINT IF EXISTS availability_rules_owner_check;
+ALTER TABLE availability_rules DROP COLUMN IF EXISTS restaurant_id;
+ALTER TABLE availability_rules ADD CONSTRAINT availability_rules_check
+    CHECK ((food_item_id IS NULL) <> (category IS NULL));
+ALTER TABLE order_status_events DROP COLUMN IF EXISTS restaurant_id;
+ALTER TABLE users DROP COLUMN IF EXISTS restaurant_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS restaurant_id;
+ALTER TABLE food_items DROP COLUMN IF EXISTS restaurant_id;
+DROP TABLE IF EXISTS restaurants;
@@ -0,0 +1,41 @@
+-- Рестораны маркетплейса. Уже существующее меню и заказы переходят к ресторану
+-- по умолчанию; delivery_radius_km = 0 - доставка без ограничения расстояния.
+CREATE TABLE restaurants (
+    id                 BIGSERIAL PRIMARY KEY,
+    name               TEXT NOT NULL,
+    slug               TEXT NOT NULL UNIQUE,
+    address            TEXT NOT NULL DEFAULT '',
+    latitude           DOUBLE PRECISION NOT NULL DEFAULT 0,
+    longitude          DOUBLE PRECISION NOT NULL DEFAULT 0,
+    delivery_radius_km DOUBLE PRECISION NOT NULL DEFAULT 0 CHECK (delivery_radius_km >= 0),
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+INSERT INTO restaurants (name, slug) VALUES ('Main Kitchen', 'main-kitchen');
+
+ALTER TABLE food_items ADD COLUMN restaurant_id BIGINT
+    CONSTRAINT fk_food_items_restaurant REFERENCES restaurants (id) ON DELETE RESTRICT;
+UPDATE food_items SET restaurant_id = (SELECT MIN(id) FROM restaurants);
+ALTER TABLE food_items ALTER COLUMN restaurant_id SET NOT NULL;
+CREATE INDEX idx_food_items_restaurant_id ON food_items (restaurant_id);
+
+ALTER TABLE orders ADD COLUMN restaurant_id BIGINT
+    CONSTRAINT fk_orders_restaurant REFERENCES restaurants (id) ON DELETE RESTRICT;
+UPDATE orders SET restaurant_id = (SELECT MIN(id) FROM restaurants);
+CREATE INDEX idx_orders_restaurant_id ON orders (restaurant_id);
+
+-- Сотрудник с restaurant_id видит только заказы своего ресторана.
+ALTER TABLE users ADD COLUMN restaurant_id BIGINT
+    CONSTRAINT fk_users_restaurant REFERENCES restaurants (id) ON DELETE SET NULL;
+
+ALTER TABLE order_status_events ADD COLUMN restaurant_id BIGINT;
+UPDATE order_status_events e SET restaurant_id = o.restaurant_id FROM orders o WHERE o.id = e.order_id;
+CREATE INDEX idx_order_status_events_restaurant_id ON order_status_events (restaurant_id);
+
+-- Часы работы ресторана - те же правила доступности.
+ALTER TABLE availability_rules ADD COLUMN restaurant_id BIGINT
+    CONSTRAINT fk_availability_rules_restaurant REFERENCES restaurants (id) ON DELETE CASCADE;
+ALTER TABLE availability_rules DROP CONSTRAINT availability_rules_check;
+ALTER TABLE availability_rules ADD CONSTRAINT availability_rules_owner_check
+    CHECK (num_nonnulls(food_item_id, category, restaurant_id) = 1);
+CREATE INDEX idx_availability_rules_restaurant_id ON availability_rules (restaurant_id);
@@ -0,0 +1,11 @@
+-- Старый формат не умеет хранить содержимое вложений, поэтому откат не
+-- применяется, пока в очереди есть письма с вложениями.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM outbox_attachments) THEN
+        RAISE EXCEPTION 'outbox_attachments is not empty; deliver or delete those emails before reverting';
+    END IF;
+END $$;
+
+ALTER TABLE outbox_emails ADD COLUMN attachments TEXT;
+DROP TABLE IF EXISTS outbox_attachments;
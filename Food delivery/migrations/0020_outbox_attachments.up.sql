@@ -0,0 +1,22 @@
+-- Старая колонка attachments хранила пути к временным файлам на сервере
+-- приложения; из SQL их не прочитать. Чтобы неотправленные письма не ушли молча
+-- без вложений, миграция не применяется, пока такие письма есть: дождитесь их
+-- отправки старой версией или удалите их.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM outbox_emails
+               WHERE status <> 'sent' AND COALESCE(attachments, '') <> '') THEN
+        RAISE EXCEPTION 'outbox_emails has unsent emails with file attachments; deliver or delete them before migrating';
+    END IF;
+END $$;
+
+-- Вложения писем хранятся в базе рядом с письмом, а не путями к временным файлам:
+-- их может доставить воркер на любом сервере, и они удаляются после отправки.
+CREATE TABLE outbox_attachments (
+    id              BIGSERIAL PRIMARY KEY,
+    outbox_email_id BIGINT NOT NULL REFERENCES outbox_emails (id) ON DELETE CASCADE,
+    name            TEXT NOT NULL,
+    data            BYTEA NOT NULL
+);
+CREATE INDEX idx_outbox_attachments_outbox_email_id ON outbox_attachments (outbox_email_id);
+ALTER TABLE outbox_emails DROP COLUMN IF EXISTS attachments;
@@ -0,0 +1,6 @@
+DELETE FROM availability_rules
+    WHERE category IS NOT NULL AND restaurant_id <> (SELECT MIN(id) FROM restaurants);
+ALTER TABLE availability_rules DROP CONSTRAINT IF EXISTS availability_rules_owner_check;
+UPDATE availability_rules SET restaurant_id = NULL WHERE category IS NOT NULL;
+ALTER TABLE availability_rules ADD CONSTRAINT availability_rules_owner_check
+    CHECK (num_nonnulls(food_item_id, category, restaurant_id) = 1);
@@ -0,0 +1,7 @@
+-- Правила категорий принадлежат ресторану: "Breakfast" одного ресторана не
+-- влияет на меню другого. Уже заданные правила категорий переходят к ресторану
+-- по умолчанию, к которому относилось меню до маркетплейса.
+ALTER TABLE availability_rules DROP CONSTRAINT availability_rules_owner_check;
+UPDATE availability_rules SET restaurant_id = (SELECT MIN(id) FROM restaurants) WHERE category IS NOT NULL;
+ALTER TABLE availability_rules ADD CONSTRAINT availability_rules_owner_check
+    CHECK ((food_item_id IS NULL) <> (restaurant_id IS NULL) AND (category IS NULL OR restaurant_id IS NOT NULL));
@@ -0,0 +1,22 @@
+-- Вызовы шлюза после смены статуса заказа: списание, снятие резерва, возврат.
+-- Операция создается в транзакции перехода, шлюз вызывается после коммита, а
+-- не удавшиеся вызовы повторяет воркер. Не больше одной незавершенной операции
+-- на платеж: пока она идет, платеж считается занятым.
+CREATE TABLE payment_operations (
+    id              BIGSERIAL PRIMARY KEY,
+    payment_id      BIGINT NOT NULL REFERENCES payments (id) ON DELETE CASCADE,
+    refund_id       BIGINT REFERENCES refunds (id) ON DELETE CASCADE,
+    action          TEXT NOT NULL CHECK (action IN ('capture', 'void', 'refund')),
+    amount          BIGINT NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'done', 'dead')),
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_error      TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ,
+    CHECK ((action = 'refund') = (refund_id IS NOT NULL))
+);
+CREATE UNIQUE INDEX idx_payment_operations_pending_payment ON payment_operations (payment_id)
+    WHERE status = 'pending';
+CREATE INDEX idx_payment_operations_next_attempt_at ON payment_operations (next_attempt_at)
+    WHERE status = 'pending';
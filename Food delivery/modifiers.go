@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	maxOptionGroupName = 100
+	maxOptionsInGroup  = 50
+)
+
+// OptionGroup - группа модификаторов блюда ("Соус", "Добавки"). Покупатель выбирает
+// от MinSelect до MaxSelect опций; Required означает, что выбрать нужно хотя бы одну.
+type OptionGroup struct {
+	ID         uint         `json:"id" gorm:"primaryKey"`
+	FoodItemID uint         `json:"food_item_id" gorm:"index;not null"`
+	Name       string       `json:"name" gorm:"not null"`
+	Required   bool         `json:"required"`
+	MinSelect  int          `json:"min_select" gorm:"not null"`
+	MaxSelect  int          `json:"max_select" gorm:"not null"`
+	Position   int          `json:"position" gorm:"not null"`
+	Options    []MenuOption `json:"options" gorm:"foreignKey:GroupID;constraint:OnDelete:CASCADE"`
+}
+
+// MenuOption - опция группы с доплатой к цене блюда (может быть 0).
+type MenuOption struct {
+	ID       uint   `json:"id" gorm:"primaryKey"`
+	GroupID  uint   `json:"group_id" gorm:"index;not null"`
+	Name     string `json:"name" gorm:"not null"`
+	Price    Money  `json:"price" gorm:"not null"`
+	Position int    `json:"position" gorm:"not null"`
+}
+
+// OrderLineOption - выбранная опция в позиции заказа или корзины. Название и
+// доплата копируются, как и у самой позиции.
+type OrderLineOption struct {
+	ID          uint   `json:"-" gorm:"primaryKey"`
+	OrderLineID uint   `json:"-" gorm:"index;not null"`
+	OptionID    *uint  `json:"option_id"`
+	GroupName   string `json:"group" gorm:"not null"`
+	Name        string `json:"name" gorm:"not null"`
+	Price       Money  `json:"price" gorm:"not null"`
+}
+
+var errInvalidOptions = errors.New("invalid option selection")
+
+// withOptionGroups подгружает группы и опции блюд в порядке показа.
+func withOptionGroups(q *gorm.DB) *gorm.DB {
+	return q.
+		Preload("OptionGroups", func(tx *gorm.DB) *gorm.DB { return tx.Order("position, id") }).
+		Preload("OptionGroups.Options", func(tx *gorm.DB) *gorm.DB { return tx.Order("position, id") })
+}
+
+// optionsKey - канонический вид выбора опций: отсортированные ID через запятую.
+// Одно блюдо с разными опциями - разные позиции корзины.
+func optionsKey(ids []uint) string {
+	sorted := append([]uint(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	parts := make([]string, len(sorted))
+	for i, id := range sorted {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseOptionsKey(key string) []uint {
+	if key == "" {
+		return nil
+	}
+	var ids []uint
+	for _, part := range strings.Split(key, ",") {
+		if id, err := strconv.ParseUint(part, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// resolveOptions проверяет выбор опций по правилам групп блюда (группы должны быть
+// подгружены) и возвращает выбранные опции в порядке показа и сумму доплат.
+func resolveOptions(item *FoodItem, ids []uint) ([]OrderLineOption, Money, error) {
+	selected := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if selected[id] {
+			return nil, 0, fmt.Errorf("%w: option %d selected twice", errInvalidOptions, id)
+		}
+		selected[id] = true
+	}
+
+	var chosen []OrderLineOption
+	var extra Money
+	found := 0
+	for _, group := range item.OptionGroups {
+		count := 0
+		for _, option := range group.Options {
+			if !selected[option.ID] {
+				continue
+			}
+			optionID := option.ID
+			chosen = append(chosen, OrderLineOption{OptionID: &optionID, GroupName: group.Name, Name: option.Name, Price: option.Price})
+			extra += option.Price
+			count++
+		}
+		found += count
+		if min := group.minSelect(); count < min {
+			return nil, 0, fmt.Errorf("%w: choose at least %d in %q", errInvalidOptions, min, group.Name)
+		}
+		if count > group.MaxSelect {
+			return nil, 0, fmt.Errorf("%w: choose at most %d in %q", errInvalidOptions, group.MaxSelect, group.Name)
+		}
+	}
+	if found != len(selected) {
+		return nil, 0, fmt.Errorf("%w: option does not belong to %q", errInvalidOptions, item.Name)
+	}
+	return chosen, extra, nil
+}
+
+func (g *OptionGroup) minSelect() int {
+	if g.Required && g.MinSelect < 1 {
+		return 1
+	}
+	return g.MinSelect
+}
+
+// validateOptionGroup проверяет группу перед сохранением. MaxSelect = 0 - без
+// ограничения, то есть все опции группы.
+func validateOptionGroup(g *OptionGroup) error {
+	g.Name = strings.TrimSpace(g.Name)
+	switch {
+	case g.Name == "":
+		return errors.New("group name is required")
+	case utf8.RuneCountInString(g.Name) > maxOptionGroupName:
+		return fmt.Errorf("group name must be at most %d characters", maxOptionGroupName)
+	case len(g.Options) == 0:
+		return errors.New("group must have at least one option")
+	case len(g.Options) > maxOptionsInGroup:
+		return fmt.Errorf("group can have at most %d options", maxOptionsInGroup)
+	case g.MinSelect < 0 || g.MaxSelect < 0:
+		return errors.New("min_select and max_select must be non-negative")
+	}
+	if g.MaxSelect == 0 {
+		g.MaxSelect = len(g.Options)
+	}
+	g.MinSelect = g.minSelect()
+	g.Required = g.MinSelect > 0
+	if g.MinSelect > g.MaxSelect || g.MaxSelect > len(g.Options) {
+		return fmt.Errorf("need 0 <= min_select <= max_select <= %d", len(g.Options))
+	}
+	for i := range g.Options {
+		option := &g.Options[i]
+		option.Name = strings.TrimSpace(option.Name)
+		if option.Name == "" || utf8.RuneCountInString(option.Name) > maxOptionGroupName {
+			return fmt.Errorf("option name must be 1 to %d characters", maxOptionGroupName)
+		}
+		if option.Price < 0 {
+			return errors.New("option price must be non-negative")
+		}
+	}
+	return nil
+}
+
+// bumpFoodItemVersion - изменение модификаторов меняет блюдо, поэтому его ETag
+// тоже меняется.
+func bumpFoodItemVersion(tx *gorm.DB, id uint) error {
+	return tx.Model(&FoodItem{}).Where("id = ?", id).Update("version", gorm.Expr("version + 1")).Error
+}
+
+// loadMenuItemGroup находит группу {groupId} блюда {id} из URL и пишет ответ об ошибке сам.
+func loadMenuItemGroup(w http.ResponseWriter, r *http.Request) (*OptionGroup, bool) {
+	itemID, err1 := strconv.Atoi(mux.Vars(r)["id"])
+	groupID, err2 := strconv.Atoi(mux.Vars(r)["groupId"])
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return nil, false
+	}
+	var group OptionGroup
+	err := db.Preload("Options").Where("id = ? AND food_item_id = ?", groupID, itemID).First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Option group not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch option group", err)
+		return nil, false
+	}
+	return &group, true
+}
+
+func writeOptionGroup(w http.ResponseWriter, status int, group *OptionGroup) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(group)
+}
+
+// createOptionGroup - POST /menu/{id}/option-groups: группа вместе с опциями.
+func createOptionGroup(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var group OptionGroup
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if err := validateOptionGroup(&group); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	var item FoodItem
+	if err := db.First(&item, itemID).Error; err != nil {
+		http.Error(w, "Menu item not found", http.StatusNotFound)
+		return
+	}
+
+	group.ID, group.FoodItemID = 0, item.ID
+	for i := range group.Options {
+		group.Options[i].ID, group.Options[i].GroupID = 0, 0
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		return bumpFoodItemVersion(tx, item.ID)
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to create option group", err)
+		return
+	}
+	writeOptionGroup(w, http.StatusCreated, &group)
+}
+
+// updateOptionGroup - PUT /menu/{id}/option-groups/{groupId}. Опции с id
+// обновляются, без id - добавляются, не переданные - удаляются. Позиции корзин с
+// удаленными опциями пропадут при следующем просмотре корзины.
+func updateOptionGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := loadMenuItemGroup(w, r)
+	if !ok {
+		return
+	}
+	var input OptionGroup
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if err := validateOptionGroup(&input); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	existing := make(map[uint]bool, len(group.Options))
+	for _, option := range group.Options {
+		existing[option.ID] = true
+	}
+	keep := []uint{}
+	for i := range input.Options {
+		option := &input.Options[i]
+		if option.ID != 0 && !existing[option.ID] {
+			http.Error(w, fmt.Sprintf("Option %d does not belong to this group", option.ID), http.StatusBadRequest)
+			return
+		}
+		option.GroupID = group.ID
+		if option.ID != 0 {
+			keep = append(keep, option.ID)
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(group).Updates(map[string]interface{}{
+			"name":       input.Name,
+			"required":   input.Required,
+			"min_select": input.MinSelect,
+			"max_select": input.MaxSelect,
+			"position":   input.Position,
+		}).Error
+		if err != nil {
+			return err
+		}
+		remove := tx.Where("group_id = ?", group.ID)
+		if len(keep) > 0 {
+			remove = remove.Where("id NOT IN ?", keep)
+		}
+		if err := remove.Delete(&MenuOption{}).Error; err != nil {
+			return err
+		}
+		for i := range input.Options {
+			if err := tx.Save(&input.Options[i]).Error; err != nil {
+				return err
+			}
+		}
+		return bumpFoodItemVersion(tx, group.FoodItemID)
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to update option group", err)
+		return
+	}
+	input.ID, input.FoodItemID = group.ID, group.FoodItemID
+	writeOptionGroup(w, http.StatusOK, &input)
+}
+
+// deleteOptionGroup - DELETE /menu/{id}/option-groups/{groupId}.
+func deleteOptionGroup(w http.ResponseWriter, r *http.Request) {
+	group, ok := loadMenuItemGroup(w, r)
+	if !ok {
+		return
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Опции удаляются каскадом.
+		if err := tx.Delete(group).Error; err != nil {
+			return err
+		}
+		return bumpFoodItemVersion(tx, group.FoodItemID)
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to delete option group", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
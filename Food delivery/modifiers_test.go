@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func testItemWithOptions() *FoodItem {
+	return &FoodItem{
+		ID:   1,
+		Name: "Burger",
+		OptionGroups: []OptionGroup{
+			{Name: "Sauce", Required: true, MinSelect: 1, MaxSelect: 1, Options: []MenuOption{
+				{ID: 1, Name: "Ketchup"},
+				{ID: 2, Name: "Truffle mayo", Price: 50},
+			}},
+			{Name: "Extras", MaxSelect: 2, Options: []MenuOption{
+				{ID: 3, Name: "Cheese", Price: 100},
+				{ID: 4, Name: "Bacon", Price: 150},
+				{ID: 5, Name: "Egg", Price: 100},
+			}},
+		},
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		ids       []uint
+		wantNames []string
+		wantExtra Money
+		wantErr   bool
+	}{
+		{"required only", []uint{1}, []string{"Ketchup"}, 0, false},
+		{"in display order", []uint{4, 2, 3}, []string{"Truffle mayo", "Cheese", "Bacon"}, 300, false},
+		{"missing required group", []uint{3}, nil, 0, true},
+		{"too many in group", []uint{1, 2}, nil, 0, true},
+		{"over max extras", []uint{1, 3, 4, 5}, nil, 0, true},
+		{"selected twice", []uint{1, 1}, nil, 0, true},
+		{"option of another item", []uint{1, 99}, nil, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chosen, extra, err := resolveOptions(testItemWithOptions(), tt.ids)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidOptions) {
+					t.Fatalf("error = %v, want errInvalidOptions", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if extra != tt.wantExtra {
+				t.Errorf("extra = %d, want %d", extra, tt.wantExtra)
+			}
+			if len(chosen) != len(tt.wantNames) {
+				t.Fatalf("chosen %d options, want %v", len(chosen), tt.wantNames)
+			}
+			for i, name := range tt.wantNames {
+				if chosen[i].Name != name {
+					t.Errorf("option %d = %q, want %q", i, chosen[i].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateOptionGroup(t *testing.T) {
+	options := func(n int) []MenuOption {
+		out := make([]MenuOption, n)
+		for i := range out {
+			out[i].Name = "Option"
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		group   OptionGroup
+		wantMin int
+		wantMax int
+		wantErr bool
+	}{
+		{"max 0 means all options", OptionGroup{Name: "Extras", Options: options(3)}, 0, 3, false},
+		{"required implies min 1", OptionGroup{Name: "Sauce", Required: true, MaxSelect: 1, Options: options(2)}, 1, 1, false},
+		{"empty name", OptionGroup{Name: " ", Options: options(1)}, 0, 0, true},
+		{"no options", OptionGroup{Name: "Sauce"}, 0, 0, true},
+		{"min above max", OptionGroup{Name: "Sauce", MinSelect: 2, MaxSelect: 1, Options: options(2)}, 0, 0, true},
+		{"max above option count", OptionGroup{Name: "Sauce", MaxSelect: 3, Options: options(2)}, 0, 0, true},
+		{"negative price", OptionGroup{Name: "Sauce", Options: []MenuOption{{Name: "Mayo", Price: -1}}}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := tt.group
+			err := validateOptionGroup(&group)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (group.MinSelect != tt.wantMin || group.MaxSelect != tt.wantMax) {
+				t.Errorf("min/max = %d/%d, want %d/%d", group.MinSelect, group.MaxSelect, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
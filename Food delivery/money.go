@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Money - сумма в минимальных единицах валюты (центах). Поддерживаются валюты
+// с двумя знаками после запятой. В JSON и YAML пишется десятичным числом (9.99),
+// чтобы старые клиенты продолжали работать.
+type Money int64
+
+const (
+	minorUnitsPerMajor = 100
+	defaultCurrency    = "USD"
+)
+
+var currencyRe = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// String возвращает сумму в виде "12.50".
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/minorUnitsPerMajor, v%minorUnitsPerMajor)
+}
+
+// parseMoney разбирает десятичную запись суммы. Знаки после второго округляются
+// до цента половиной вверх (от нуля): 22.979999 -> 22.98, 0.125 -> 0.13.
+func parseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		// Экспоненциальную запись JSON разбираем через float - точность тут не важна.
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+		return Money(math.Round(f * minorUnitsPerMajor)), nil
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	frac += "000"
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	cents, err := strconv.ParseInt(frac[:2], 10, 64)
+	if err != nil || strings.Trim(frac[2:], "0123456789") != "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if units > (math.MaxInt64-minorUnitsPerMajor)/minorUnitsPerMajor {
+		return 0, fmt.Errorf("amount %q is too large", s)
+	}
+	v := units*minorUnitsPerMajor + cents
+	if frac[2] >= '5' {
+		v++
+	}
+	if negative {
+		v = -v
+	}
+	return Money(v), nil
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON принимает число (9.99) или строку ("9.99").
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	v, err := parseMoney(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+func (m *Money) UnmarshalYAML(node *yaml.Node) error {
+	v, err := parseMoney(node.Value)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// basisPoints переводит долю (0.12) в базисные пункты (1200) для целочисленных расчетов.
+func basisPoints(rate float64) int64 {
+	return int64(math.Round(rate * 10000))
+}
+
+// mulBasisPoints возвращает m * bp / 10000 с округлением до цента половиной вверх
+// (от нуля). Так считаются налог и процентные скидки.
+func (m Money) mulBasisPoints(bp int64) Money {
+	product := int64(m) * bp
+	q, r := product/10000, product%10000
+	if r >= 5000 {
+		q++
+	} else if r <= -5000 {
+		q--
+	}
+	return Money(q)
+}
+
+// times - стоимость qty штук; умножение целое, без округления.
+func (m Money) times(qty int) Money {
+	return m * Money(qty)
+}
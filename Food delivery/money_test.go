@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{"9.99", 999, false},
+		{"5", 500, false},
+		{".5", 50, false},
+		{" 12.50 ", 1250, false},
+		{"22.979999", 2298, false},
+		{"0.125", 13, false},
+		{"0.124", 12, false},
+		{"-1.005", -101, false},
+		{"1e2", 10000, false},
+		{"", 0, true},
+		{"-", 0, true},
+		{"abc", 0, true},
+		{"1.2x", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseMoney(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMoney(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseMoney(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		in   Money
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1250, "12.50"},
+		{-101, "-1.01"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMulBasisPoints(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Money
+		bp   int64
+		want Money
+	}{
+		{"exact", 1000, 800, 80},
+		{"rounds down below half", 1749, 800, 140},
+		{"half rounds up", 15, 1000, 2},
+		{"negative half rounds away from zero", -15, 1000, -2},
+		{"below half", 14, 1000, 1},
+		{"zero rate", 1234, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.m.mulBasisPoints(tt.bp); got != tt.want {
+				t.Errorf("%d.mulBasisPoints(%d) = %d, want %d", tt.m, tt.bp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBasisPoints(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want int64
+	}{
+		{0.08, 800},
+		{0.0825, 825},
+		{0.1, 1000},
+		{0.07, 700}, // 0.07 * 10000 = 700.0000000000001
+	}
+	for _, tt := range tests {
+		if got := basisPoints(tt.rate); got != tt.want {
+			t.Errorf("basisPoints(%v) = %d, want %d", tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
+)
+
+const (
+	streamHeartbeatInterval = 25 * time.Second
+	subscriberBuffer        = 32
+	// maxReplayEvents - если клиент отстал больше, вместо replay он получает reset.
+	maxReplayEvents = 500
+	wsWriteTimeout  = 10 * time.Second
+)
+
+// orderSubscriber получает события одного заказа или, при orderID = 0, всех заказов
+// ресторана restaurantID (0 - всех ресторанов).
+type orderSubscriber struct {
+	orderID      uint
+	restaurantID uint
+	events       chan OrderStatusEvent
+}
+
+// orderEventHub - pub/sub внутри процесса. Источник событий - order_status_events:
+// ID события в потоке совпадает с ID строки, поэтому пропущенное при переподключении
+// можно дочитать из базы. При нескольких экземплярах сервера каждый видит только
+// свои изменения, а недостающее клиент получит через replay.
+type orderEventHub struct {
+	mu   sync.Mutex
+	subs map[*orderSubscriber]struct{}
+}
+
+var orderEvents = &orderEventHub{subs: map[*orderSubscriber]struct{}{}}
+
+func (h *orderEventHub) subscribe(orderID, restaurantID uint) *orderSubscriber {
+	sub := &orderSubscriber{orderID: orderID, restaurantID: restaurantID, events: make(chan OrderStatusEvent, subscriberBuffer)}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *orderEventHub) unsubscribe(sub *orderSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.events)
+	}
+}
+
+// publish вызывается после коммита транзакции. Медленного подписчика не ждем:
+// его канал закрывается, клиент переподключится с Last-Event-ID.
+func (h *orderEventHub) publish(event OrderStatusEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if (sub.orderID != 0 && sub.orderID != event.OrderID) ||
+			(sub.restaurantID != 0 && sub.restaurantID != event.RestaurantID) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			delete(h.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// errReplayTooLong - после afterID больше maxReplayEvents событий: клиенту проще
+// перечитать заказы целиком, чем получить их историю в потоке.
+var errReplayTooLong = errors.New("too many events to replay")
+
+func orderEventsQuery(orderID, restaurantID uint) *gorm.DB {
+	query := db.Model(&OrderStatusEvent{})
+	if orderID != 0 {
+		query = query.Where("order_id = ?", orderID)
+	}
+	if restaurantID != 0 {
+		query = query.Where("restaurant_id = ?", restaurantID)
+	}
+	return query
+}
+
+// replayOrderEvents возвращает события с ID больше afterID или errReplayTooLong,
+// если их больше maxReplayEvents.
+func replayOrderEvents(orderID, restaurantID uint, afterID uint) ([]OrderStatusEvent, error) {
+	var events []OrderStatusEvent
+	err := orderEventsQuery(orderID, restaurantID).
+		Where("id > ?", afterID).Order("id").Limit(maxReplayEvents + 1).
+		Find(&events).Error
+	if err == nil && len(events) > maxReplayEvents {
+		return nil, errReplayTooLong
+	}
+	return events, err
+}
+
+// latestOrderEventID - ID последнего события потока, 0 - событий нет.
+func latestOrderEventID(orderID, restaurantID uint) (uint, error) {
+	var id uint
+	err := orderEventsQuery(orderID, restaurantID).Select("COALESCE(MAX(id), 0)").Scan(&id).Error
+	return id, err
+}
+
+// lastEventID берет Last-Event-ID из заголовка (EventSource при переподключении)
+// или из ?last_event_id= (первое подключение, WebSocket).
+func lastEventID(r *http.Request) (uint, bool) {
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// orderStream - общая часть SSE и WebSocket: подписка, replay, затем живые события.
+// Подписываемся до чтения истории, чтобы не потерять событие между ними, а
+// дубликаты отсекаем по ID. Если клиент отстал больше чем на maxReplayEvents,
+// вместо replay он получает событие reset с ID последнего события: нужно
+// перечитать заказы запросом и продолжать поток с этого ID.
+type orderStream struct {
+	sub    *orderSubscriber
+	replay []OrderStatusEvent
+	lastID uint
+	reset  bool
+}
+
+func openOrderStream(orderID, restaurantID uint, r *http.Request, replayByDefault bool) (*orderStream, error) {
+	sub := orderEvents.subscribe(orderID, restaurantID)
+	afterID, ok := lastEventID(r)
+	if !ok && !replayByDefault {
+		return &orderStream{sub: sub}, nil
+	}
+	replay, err := replayOrderEvents(orderID, restaurantID, afterID)
+	if errors.Is(err, errReplayTooLong) {
+		var latest uint
+		if latest, err = latestOrderEventID(orderID, restaurantID); err == nil {
+			return &orderStream{sub: sub, lastID: latest, reset: true}, nil
+		}
+	}
+	if err != nil {
+		orderEvents.unsubscribe(sub)
+		return nil, err
+	}
+	s := &orderStream{sub: sub, replay: replay, lastID: afterID}
+	if len(replay) > 0 {
+		s.lastID = replay[len(replay)-1].ID
+	}
+	return s, nil
+}
+
+func (s *orderStream) close() {
+	orderEvents.unsubscribe(s.sub)
+}
+
+// streamTarget проверяет доступ и возвращает ID заказа для потока (0 - все заказы)
+// и ресторан, чьи заказы в нем видны (0 - все). Поток одного заказа доступен его
+// владельцу, сотрудникам и админам; поток всех заказов сотрудника ресторана -
+// только заказы его ресторана, админ может выбрать ресторан через ?restaurant_id=.
+func streamTarget(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	if _, ok := mux.Vars(r)["id"]; !ok {
+		restaurantID, ok := ordersRestaurantFilter(w, r)
+		return 0, restaurantID, ok
+	}
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return 0, 0, false
+	}
+	user, _ := userFromContext(r.Context())
+	if user.Role != roleStaff && !canAccessUser(user, order.UserID, "") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, 0, false
+	}
+	return order.ID, 0, true
+}
+
+// streamOrderEvents - SSE. GET /orders/{id}/events для покупателя (по умолчанию
+// отдает всю историю заказа) и GET /orders/events для кухни и админов (только новые).
+func streamOrderEvents(w http.ResponseWriter, r *http.Request) {
+	orderID, restaurantID, ok := streamTarget(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	stream, err := openOrderStream(orderID, restaurantID, r, orderID != 0)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to load order events", err)
+		return
+	}
+	defer stream.close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if stream.reset {
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {\"reason\":\"too_far_behind\"}\n\n", stream.lastID); err != nil {
+			return
+		}
+	}
+	for _, event := range stream.replay {
+		if err := writeSSE(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event, ok := <-stream.sub.events:
+			if !ok {
+				return
+			}
+			if event.ID <= stream.lastID {
+				continue
+			}
+			if err := writeSSE(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event OrderStatusEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: status\ndata: %s\n\n", event.ID, data)
+	return err
+}
+
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range cfg.Server.CORSOrigins {
+			if allowed == "*" || allowed == origin {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+// wsOrderEvent - сообщение в WebSocket; id передается в ?last_event_id= при переподключении.
+// У события reset нет data.
+type wsOrderEvent struct {
+	ID    uint              `json:"id"`
+	Event string            `json:"event"`
+	Data  *OrderStatusEvent `json:"data,omitempty"`
+}
+
+// streamOrderEventsWS - те же события, что и streamOrderEvents, по WebSocket.
+func streamOrderEventsWS(w http.ResponseWriter, r *http.Request) {
+	orderID, restaurantID, ok := streamTarget(w, r)
+	if !ok {
+		return
+	}
+	stream, err := openOrderStream(orderID, restaurantID, r, orderID != 0)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to load order events", err)
+		return
+	}
+	defer stream.close()
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade уже ответил клиенту.
+		logger.WithField("error", err).Warn("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	// Клиент ничего не присылает; читаем только чтобы заметить закрытие соединения.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(event OrderStatusEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(wsOrderEvent{ID: event.ID, Event: "status", Data: &event})
+	}
+	if stream.reset {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(wsOrderEvent{ID: stream.lastID, Event: "reset"}); err != nil {
+			return
+		}
+	}
+	for _, event := range stream.replay {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case event, ok := <-stream.sub.events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "reconnect with last_event_id"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			}
+			if event.ID <= stream.lastID {
+				continue
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamAuthenticated - authenticated для EventSource и WebSocket из браузера.
+func streamAuthenticated(h http.HandlerFunc) http.Handler {
+	return streamAuthMiddleware(h)
+}
+
+func streamStaffOnly(h http.HandlerFunc) http.Handler {
+	return streamAuthMiddleware(requireRole(roleStaff, roleAdmin)(h))
+}
+
+// streamAuthMiddleware - authMiddleware для потоков событий. EventSource и WebSocket
+// не умеют передавать заголовок Authorization, поэтому без него принимается
+// ?stream_token= из POST /auth/stream-token. Access token в URL не принимается:
+// URL попадает в логи прокси, а stream token живет минуту и годится только для потоков.
+func streamAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("stream_token")
+		if token == "" || r.Header.Get("Authorization") != "" {
+			authMiddleware(next).ServeHTTP(w, r)
+			return
+		}
+		user, err := authenticateToken(token, purposeStream)
+		if err != nil {
+			handleError(w, http.StatusUnauthorized, "Unauthorized", err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
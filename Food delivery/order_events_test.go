@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// useTestKeys подписывает токены теста фиксированным ключом.
+func useTestKeys(t *testing.T) {
+	t.Helper()
+	ring, err := loadKeyRing("", "test-signing-secret-0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := jwtKeys
+	jwtKeys = ring
+	t.Cleanup(func() { jwtKeys = prev })
+}
+
+func TestStreamAuthRejectsTokensOtherThanStream(t *testing.T) {
+	useTestKeys(t)
+	access, err := issueToken(&Claims{Email: "user@example.com", Purpose: purposeAccess, SessionID: 1}, accessTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := issueToken(&Claims{Email: "user@example.com", Purpose: purposeStream, SessionID: 1}, streamTokenTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reached := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached without a valid token")
+	})
+	tests := []struct {
+		name    string
+		handler http.Handler
+		query   url.Values
+		bearer  string
+	}{
+		{"access token in stream_token", streamAuthMiddleware(reached), url.Values{"stream_token": {access}}, ""},
+		{"access_token query is not accepted", streamAuthMiddleware(reached), url.Values{"access_token": {access}}, ""},
+		{"stream token as bearer on a stream", streamAuthMiddleware(reached), nil, stream},
+		{"stream token as bearer on the API", authMiddleware(reached), nil, stream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders/events?"+tt.query.Encode(), nil)
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want 401", w.Code)
+			}
+		})
+	}
+}
+
+func TestOpenOrderStreamResetsWhenTooFarBehind(t *testing.T) {
+	openTestDB(t)
+	events := make([]OrderStatusEvent, maxReplayEvents+1)
+	for i := range events {
+		events[i] = OrderStatusEvent{OrderID: 1, ToStatus: orderPlaced, RestaurantID: 1}
+	}
+	if err := db.CreateInBatches(events, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+	latest := events[len(events)-1].ID
+
+	tests := []struct {
+		name       string
+		lastID     uint
+		wantReset  bool
+		wantReplay int
+	}{
+		{"too far behind", events[0].ID - 1, true, 0},
+		{"exactly the limit", events[0].ID, false, maxReplayEvents},
+		{"up to date", latest, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders/events", nil)
+			r.Header.Set("Last-Event-ID", strconv.FormatUint(uint64(tt.lastID), 10))
+			stream, err := openOrderStream(0, 1, r, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stream.close()
+			if stream.reset != tt.wantReset || len(stream.replay) != tt.wantReplay {
+				t.Fatalf("reset = %v, replay = %d; want %v, %d", stream.reset, len(stream.replay), tt.wantReset, tt.wantReplay)
+			}
+			if stream.lastID != latest {
+				t.Errorf("lastID = %d, want latest event %d", stream.lastID, latest)
+			}
+		})
+	}
+}
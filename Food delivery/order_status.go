@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Статусы заказа.
+const (
+	orderPlaced         = "placed"
+	orderAccepted       = "accepted"
+	orderPreparing      = "preparing"
+	orderReady          = "ready"
+	orderOutForDelivery = "out_for_delivery"
+	orderDelivered      = "delivered"
+	orderCancelled      = "cancelled"
+)
+
+// orderTransitions - разрешенные переходы. delivered и cancelled - конечные статусы.
+var orderTransitions = map[string][]string{
+	orderPlaced:         {orderAccepted, orderCancelled},
+	orderAccepted:       {orderPreparing, orderCancelled},
+	orderPreparing:      {orderReady, orderCancelled},
+	orderReady:          {orderOutForDelivery, orderCancelled},
+	orderOutForDelivery: {orderDelivered},
+	orderDelivered:      {},
+	orderCancelled:      {},
+}
+
+// OrderStatusEvent - запись в истории статусов: кто, когда и из какого статуса
+// перевел заказ. Первое событие (FromStatus = "") пишется при создании заказа.
+type OrderStatusEvent struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	OrderID    uint      `json:"order_id" gorm:"index;not null"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status" gorm:"not null"`
+	ActorID    *uint     `json:"actor_id"`
+	ActorRole  string    `json:"actor_role"`
+	Note       string    `json:"note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	// RestaurantID копируется из заказа, чтобы поток кухни ресторана фильтровался без join.
+	RestaurantID uint `json:"restaurant_id" gorm:"index"`
+}
+
+var (
+	errUnknownStatus     = errors.New("unknown order status")
+	errInvalidTransition = errors.New("status transition is not allowed")
+	errStatusChanged     = errors.New("order status was changed concurrently")
+)
+
+func canTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// recordOrderPlaced пишет первое событие истории; вызывается в транзакции создания
+// заказа. Событие нужно опубликовать в orderEvents после коммита.
+func recordOrderPlaced(tx *gorm.DB, order *Order, actor *User) (OrderStatusEvent, error) {
+	event := OrderStatusEvent{
+		OrderID:      order.ID,
+		ToStatus:     orderPlaced,
+		ActorID:      &actor.ID,
+		ActorRole:    actor.Role,
+		RestaurantID: order.RestaurantID,
+	}
+	err := tx.Create(&event).Error
+	return event, err
+}
+
+// changeOrderStatus переводит заказ в статус to, списывает или возвращает оплату
+// (preparePaymentForTransition), пишет событие в историю и публикует его подписчикам.
+// Сначала в транзакции идет условное обновление статуса (WHERE status = текущий):
+// оно блокирует строку заказа, и из двух одновременных переходов второй дождется
+// коммита первого и получит errStatusChanged, не тронув оплату. В той же транзакции
+// записываются операция со шлюзом и событие, а при отмене возвращаются остатки.
+// Шлюз вызывается только после коммита; его ошибку повторит воркер, статус заказа
+// она уже не откатывает.
+func changeOrderStatus(ctx context.Context, order *Order, to string, actor *User, note string) error {
+	if _, ok := orderTransitions[to]; !ok {
+		return fmt.Errorf("%w: %q", errUnknownStatus, to)
+	}
+	if !canTransition(order.Status, to) {
+		return fmt.Errorf("%w: %s -> %s", errInvalidTransition, order.Status, to)
+	}
+	if to == orderCancelled && order.CancelReason == "" {
+		order.CancelReason = cancelOther
+	}
+
+	now := time.Now()
+	var event OrderStatusEvent
+	var op *PaymentOperation
+	updates := map[string]interface{}{"status": to, "status_updated_at": now}
+	if to == orderCancelled {
+		updates["cancel_reason"] = order.CancelReason
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&Order{}).
+			Where("id = ? AND status = ?", order.ID, order.Status).
+			Updates(updates)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errStatusChanged
+		}
+		var err error
+		if op, err = preparePaymentForTransition(tx, order, to, actor); err != nil {
+			return err
+		}
+		if to == orderCancelled {
+			if err := releaseOrderStock(tx, order.ID); err != nil {
+				return err
+			}
+		}
+		event = OrderStatusEvent{
+			OrderID:      order.ID,
+			FromStatus:   order.Status,
+			ToStatus:     to,
+			ActorID:      &actor.ID,
+			ActorRole:    actor.Role,
+			Note:         note,
+			CreatedAt:    now,
+			RestaurantID: order.RestaurantID,
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		order.Status = to
+		order.StatusUpdatedAt = now
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	orderEvents.publish(event)
+	if op != nil {
+		if err := runPaymentOperation(ctx, op); err != nil {
+			logger.WithField("order_id", order.ID).WithField("error", err).Warn("Payment operation is pending, will retry")
+		}
+	}
+	return nil
+}
+
+// loadOrderFromPath загружает заказ по {id} из URL и пишет ответ об ошибке сам.
+func loadOrderFromPath(w http.ResponseWriter, r *http.Request) (*Order, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return nil, false
+	}
+	var order Order
+	if err := db.Preload("Lines.Options").First(&order, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return nil, false
+		}
+		handleError(w, http.StatusInternalServerError, "Failed to fetch order", err)
+		return nil, false
+	}
+	// Сотрудник ресторана работает только с заказами своего ресторана.
+	if user, ok := userFromContext(r.Context()); ok {
+		if id := staffRestaurant(user); id != 0 && order.RestaurantID != id {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return nil, false
+		}
+	}
+	return &order, true
+}
+
+func writeStatusChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnknownStatus):
+		handleError(w, http.StatusBadRequest, "Unknown order status", err)
+	case errors.Is(err, errUnknownCancelReason):
+		handleError(w, http.StatusBadRequest, "Unknown cancel reason", err)
+	case errors.Is(err, errInvalidTransition), errors.Is(err, errStatusChanged):
+		handleError(w, http.StatusConflict, err.Error(), err)
+	case errors.Is(err, errPaymentRequired):
+		handleError(w, http.StatusConflict, "Order is not paid yet", err)
+	case errors.Is(err, errPaymentOperationPending):
+		handleError(w, http.StatusConflict, "Payment operation is still in progress", err)
+	default:
+		handleError(w, http.StatusInternalServerError, "Failed to update order status", err)
+	}
+}
+
+// updateOrderStatus - POST /orders/{id}/status для сотрудников и админов.
+func updateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	actor, _ := userFromContext(r.Context())
+	if err := changeOrderStatus(r.Context(), order, input.Status, actor, input.Note); err != nil {
+		writeStatusChangeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// cancelOwnOrder - POST /orders/{id}/cancel. Покупатель может отменить свой заказ,
+// пока ресторан его не принял; сотрудники и админы - на любом шаге до доставки.
+// Оплата снимается или возвращается полностью.
+func cancelOwnOrder(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	// Тело необязательно.
+	json.NewDecoder(r.Body).Decode(&input)
+
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	actor, _ := userFromContext(r.Context())
+	isStaff := actor.Role == roleStaff || actor.Role == roleAdmin
+	if !isStaff && order.UserID != actor.ID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if !isStaff && order.Status != orderPlaced {
+		http.Error(w, "Order can no longer be cancelled", http.StatusConflict)
+		return
+	}
+	if input.Reason == "" {
+		input.Reason = cancelCustomerRequest
+		if isStaff {
+			input.Reason = cancelOther
+		}
+	}
+	if err := cancelOrder(r.Context(), order, actor, input.Reason, input.Note); err != nil {
+		writeStatusChangeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
+
+// getOrderHistory - GET /orders/{id}/history: владелец заказа, сотрудники и админы.
+func getOrderHistory(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	actor, _ := userFromContext(r.Context())
+	if actor.Role != roleStaff && !canAccessUser(actor, order.UserID, "") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var events []OrderStatusEvent
+	if err := db.Where("order_id = ?", order.ID).Order("created_at, id").Find(&events).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch order history", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
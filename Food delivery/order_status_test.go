@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestCanTransition(t *testing.T) {
+	statuses := []string{orderPlaced, orderAccepted, orderPreparing, orderReady, orderOutForDelivery, orderDelivered, orderCancelled}
+	allowed := map[[2]string]bool{
+		{orderPlaced, orderAccepted}:          true,
+		{orderPlaced, orderCancelled}:         true,
+		{orderAccepted, orderPreparing}:       true,
+		{orderAccepted, orderCancelled}:       true,
+		{orderPreparing, orderReady}:          true,
+		{orderPreparing, orderCancelled}:      true,
+		{orderReady, orderOutForDelivery}:     true,
+		{orderReady, orderCancelled}:          true,
+		{orderOutForDelivery, orderDelivered}: true,
+	}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if len(orderTransitions) != len(statuses) {
+		t.Errorf("orderTransitions has %d statuses, want %d", len(orderTransitions), len(statuses))
+	}
+	if canTransition("unknown", orderCancelled) {
+		t.Error("unknown status must not transition")
+	}
+}
+
+func TestChangeOrderStatusRejectsBeforeSideEffects(t *testing.T) {
+	actor := &User{ID: 1, Role: roleAdmin}
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		wantErr error
+	}{
+		{"unknown target", orderPlaced, "lost", errUnknownStatus},
+		{"skipping a step", orderPlaced, orderReady, errInvalidTransition},
+		{"back to previous", orderPreparing, orderAccepted, errInvalidTransition},
+		{"out of terminal status", orderDelivered, orderCancelled, errInvalidTransition},
+		{"cancel after pickup", orderOutForDelivery, orderCancelled, errInvalidTransition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// db и шлюз не настроены: проверка должна отказать раньше, чем до них дойдет.
+			order := &Order{ID: 1, Status: tt.from}
+			err := changeOrderStatus(context.Background(), order, tt.to, actor, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if order.Status != tt.from {
+				t.Errorf("status changed to %s", order.Status)
+			}
+		})
+	}
+}
+
+func TestChangeOrderStatusLostRaceDoesNotMoveMoney(t *testing.T) {
+	openTestDB(t)
+	provider := useCountingProvider(t)
+	order, user := createTestOrder(t, paymentAuthorized, OrderLine{Name: "Soup", UnitPrice: 1000, Quantity: 1, LineTotal: 1000})
+	staff := &User{ID: user.ID, Role: roleStaff}
+
+	// Два запроса загрузили заказ в статусе placed; первый принимает его.
+	stale := *order
+	if err := changeOrderStatus(context.Background(), order, orderAccepted, staff, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Второй пытается отменить по устаревшему статусу и должен проиграть до вызова шлюза.
+	err := cancelOrder(context.Background(), &stale, staff, cancelRestaurantRejected, "")
+	if !errors.Is(err, errStatusChanged) {
+		t.Fatalf("error = %v, want errStatusChanged", err)
+	}
+	if n := provider.count("void") + provider.count("refund"); n != 0 {
+		t.Errorf("provider was called %d times by the losing transition", n)
+	}
+	var payment Payment
+	if err := db.Where("order_id = ?", order.ID).First(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != paymentCaptured || payment.RefundedAmount != 0 {
+		t.Errorf("payment = %s refunded %s, want captured and nothing refunded", payment.Status, payment.RefundedAmount)
+	}
+}
+
+func TestChangeOrderStatusConcurrentCapturesOnce(t *testing.T) {
+	openTestDB(t)
+	provider := useCountingProvider(t)
+	order, user := createTestOrder(t, paymentAuthorized, OrderLine{Name: "Soup", UnitPrice: 1000, Quantity: 1, LineTotal: 1000})
+	staff := &User{ID: user.ID, Role: roleStaff}
+
+	const attempts = 8
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(copy Order) {
+			defer wg.Done()
+			errs <- changeOrderStatus(context.Background(), &copy, orderAccepted, staff, "")
+		}(*order)
+	}
+	wg.Wait()
+	close(errs)
+
+	won := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, errStatusChanged):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if won != 1 || provider.count("capture") != 1 {
+		t.Errorf("%d transitions won and %d captures, want 1 and 1", won, provider.count("capture"))
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const maxLineQuantity = 99
+
+// OrderLine - позиция заказа. Название и цена копируются из FoodItem в момент
+// заказа, поэтому изменение меню не меняет старые заказы.
+type OrderLine struct {
+	ID         uint  `json:"id" gorm:"primaryKey"`
+	OrderID    uint  `json:"order_id" gorm:"index;not null"`
+	FoodItemID *uint `json:"food_item_id" gorm:"index"`
+	// VariantID и SKU - выбранный размер; Name тогда вида "Pizza (Large)".
+	VariantID *uint  `json:"variant_id,omitempty"`
+	SKU       string `json:"sku,omitempty"`
+	Name      string `json:"name" gorm:"not null"`
+	UnitPrice Money  `json:"unit_price" gorm:"not null"`
+	Quantity  int    `json:"quantity" gorm:"not null"`
+	LineTotal Money  `json:"line_total" gorm:"not null"`
+	// CancelledQuantity - сколько штук отменено и возвращено частичными возвратами.
+	CancelledQuantity int       `json:"cancelled_quantity" gorm:"not null;default:0"`
+	CreatedAt         time.Time `json:"created_at"`
+	// Options - выбранные модификаторы; их доплаты уже входят в UnitPrice.
+	Options []OrderLineOption `json:"options,omitempty" gorm:"foreignKey:OrderLineID;constraint:OnDelete:CASCADE"`
+	// RestaurantID - ресторан блюда; по нему заказ из корзины делится на заказы ресторанов.
+	RestaurantID uint `json:"-" gorm:"-"`
+}
+
+// orderLineInput - позиция в запросе на создание заказа.
+type orderLineInput struct {
+	FoodItemID uint   `json:"food_item_id"`
+	VariantID  uint   `json:"variant_id"` // обязателен, если у блюда есть варианты
+	Quantity   int    `json:"quantity"`
+	Options    []uint `json:"options"` // ID выбранных MenuOption
+}
+
+var (
+	errFoodItemNotFound = errors.New("food item not found")
+	errCurrencyMismatch = errors.New("food item currency does not match store currency")
+)
+
+// legacyLineInputs превращает старый формат (список ID, по одному на штуку) в позиции.
+func legacyLineInputs(ids []uint) []orderLineInput {
+	inputs := make([]orderLineInput, 0, len(ids))
+	for _, id := range ids {
+		inputs = append(inputs, orderLineInput{FoodItemID: id, Quantity: 1})
+	}
+	return inputs
+}
+
+// buildOrderLines загружает блюда из базы, объединяет повторяющиеся позиции (одно
+// блюдо с одинаковым выбором опций), проверяет опции и фиксирует текущие цены с
+// доплатами. Возвращает позиции и их сумму.
+func buildOrderLines(inputs []orderLineInput) ([]OrderLine, Money, error) {
+	if len(inputs) == 0 {
+		return nil, 0, errors.New("order has no items")
+	}
+
+	type lineKey struct {
+		foodItemID uint
+		variantID  uint
+		options    string
+	}
+	var order []lineKey
+	var ids []uint
+	quantities := map[lineKey]int{}
+	selections := map[lineKey][]uint{}
+	for _, in := range inputs {
+		if in.Quantity <= 0 {
+			return nil, 0, fmt.Errorf("invalid quantity %d for item %d", in.Quantity, in.FoodItemID)
+		}
+		key := lineKey{in.FoodItemID, in.VariantID, optionsKey(in.Options)}
+		if _, seen := quantities[key]; !seen {
+			order = append(order, key)
+			ids = append(ids, in.FoodItemID)
+			selections[key] = in.Options
+		}
+		quantities[key] += in.Quantity
+		if quantities[key] > maxLineQuantity {
+			return nil, 0, fmt.Errorf("quantity for item %d exceeds %d", in.FoodItemID, maxLineQuantity)
+		}
+	}
+
+	var items []FoodItem
+	if err := withMenuDetails(db).Where("id IN ?", ids).Find(&items).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := checkAvailable(items, time.Now()); err != nil {
+		return nil, 0, err
+	}
+	byID := make(map[uint]FoodItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+
+	lines := make([]OrderLine, 0, len(order))
+	var total Money
+	for _, key := range order {
+		item, ok := byID[key.foodItemID]
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: %d", errFoodItemNotFound, key.foodItemID)
+		}
+		if item.Currency != cfg.Pricing.Currency {
+			return nil, 0, fmt.Errorf("%w: item %d is priced in %s", errCurrencyMismatch, item.ID, item.Currency)
+		}
+		if item.SoldOut {
+			return nil, 0, fmt.Errorf("%w: %s", errSoldOut, item.Name)
+		}
+		variant, price, err := resolveVariant(&item, key.variantID)
+		if err != nil {
+			return nil, 0, err
+		}
+		options, extra, err := resolveOptions(&item, selections[key])
+		if err != nil {
+			return nil, 0, err
+		}
+		itemID := item.ID
+		unitPrice := price + extra
+		line := OrderLine{
+			FoodItemID:   &itemID,
+			Name:         lineName(&item, variant),
+			UnitPrice:    unitPrice,
+			Quantity:     quantities[key],
+			LineTotal:    unitPrice.times(quantities[key]),
+			Options:      options,
+			RestaurantID: item.RestaurantID,
+		}
+		if variant != nil {
+			variantID := variant.ID
+			line.VariantID, line.SKU = &variantID, variant.SKU
+		}
+		lines = append(lines, line)
+		total += line.LineTotal
+	}
+	return lines, total, nil
+}
+
+// saveNewOrder списывает остатки блюд и сохраняет заказ вместе с первым событием
+// истории и письмом-чеком в одной транзакции и публикует событие после коммита. extra, если задан,
+// выполняется в той же транзакции.
+func saveNewOrder(order *Order, user *User, extra func(tx *gorm.DB) error) error {
+	return saveNewOrders([]*Order{order}, user, extra)
+}
+
+// saveNewOrders - saveNewOrder для нескольких заказов одной покупки (корзина,
+// разделенная по ресторанам): создаются либо все, либо ни одного.
+func saveNewOrders(orders []*Order, user *User, extra func(tx *gorm.DB) error) error {
+	var placed []OrderStatusEvent
+	err := db.Transaction(func(tx *gorm.DB) error {
+		placed = placed[:0]
+		for _, order := range orders {
+			if err := reserveStock(tx, order.Lines); err != nil {
+				return err
+			}
+			if err := tx.Create(order).Error; err != nil {
+				return err
+			}
+			event, err := recordOrderPlaced(tx, order, user)
+			if err != nil {
+				return err
+			}
+			placed = append(placed, event)
+			if err := enqueueOrderReceipt(tx, order, user); err != nil {
+				return err
+			}
+		}
+		if extra != nil {
+			return extra(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, event := range placed {
+		orderEvents.publish(event)
+	}
+	return nil
+}
+
+// legacyFoodItems восстанавливает старое поле food_items из позиций: по одному
+// элементу на каждую штуку, с ценой на момент заказа.
+func (o *Order) legacyFoodItems() []FoodItem {
+	var items []FoodItem
+	for _, line := range o.Lines {
+		item := FoodItem{Name: line.Name, Price: line.UnitPrice, Currency: o.Currency}
+		if line.FoodItemID != nil {
+			item.ID = *line.FoodItemID
+		}
+		for i := 0; i < line.Quantity; i++ {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// MarshalJSON добавляет food_items для старых клиентов, которые не знают про lines.
+func (o Order) MarshalJSON() ([]byte, error) {
+	type orderJSON Order
+	out := orderJSON(o)
+	if len(o.Lines) > 0 {
+		out.FoodItems = o.legacyFoodItems()
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+)
+
+const (
+	outboxPollInterval = 5 * time.Second
+	outboxBatchSize    = 20
+	outboxMaxAttempts  = 8
+	outboxBaseBackoff  = 30 * time.Second
+	outboxMaxBackoff   = time.Hour
+	// outboxLease - на это время письмо скрыто от других воркеров, пока идет отправка.
+	outboxLease = 2 * time.Minute
+)
+
+// OutboxEmail - письмо, записанное в той же транзакции, что и бизнес-изменение.
+// Доставляет его фоновый воркер, поэтому сбой SMTP не теряет письмо и не ломает запрос.
+type OutboxEmail struct {
+	ID            uint               `json:"id" gorm:"primaryKey"`
+	Kind          string             `json:"kind" gorm:"index"`
+	From          string             `json:"from"`
+	To            string             `json:"to"`
+	Subject       string             `json:"subject"`
+	HTMLBody      string             `json:"html_body"`
+	TextBody      string             `json:"text_body"`
+	Attachments   []OutboxAttachment `json:"attachments,omitempty" gorm:"foreignKey:OutboxEmailID;constraint:OnDelete:CASCADE"`
+	Status        string             `json:"status" gorm:"index;not null;default:pending"`
+	Attempts      int                `json:"attempts"`
+	NextAttemptAt time.Time          `json:"next_attempt_at" gorm:"index"`
+	LastError     string             `json:"last_error"`
+	SentAt        *time.Time         `json:"sent_at"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+}
+
+// OutboxAttachment - вложение письма из outbox. Удаляется после отправки письма.
+type OutboxAttachment struct {
+	ID            uint   `json:"-" gorm:"primaryKey"`
+	OutboxEmailID uint   `json:"-" gorm:"index;not null"`
+	Name          string `json:"name" gorm:"not null"`
+	Data          []byte `json:"-" gorm:"not null"`
+}
+
+func (o *OutboxEmail) email() *Email {
+	msg := &Email{
+		From:     o.From,
+		To:       strings.Split(o.To, ","),
+		Subject:  o.Subject,
+		HTMLBody: o.HTMLBody,
+		TextBody: o.TextBody,
+	}
+	for _, a := range o.Attachments {
+		msg.Attachments = append(msg.Attachments, Attachment{Name: a.Name, Data: a.Data})
+	}
+	return msg
+}
+
+// enqueueEmail ставит письмо в очередь. tx должен быть транзакцией, в которой
+// сохраняется связанная запись, тогда письмо уйдет только если она закоммичена.
+func enqueueEmail(tx *gorm.DB, kind string, msg *Email) error {
+	if msg.From == "" {
+		msg.From = mailFrom()
+	}
+	attachments := make([]OutboxAttachment, len(msg.Attachments))
+	for i, a := range msg.Attachments {
+		attachments[i] = OutboxAttachment{Name: a.Name, Data: a.Data}
+	}
+	return tx.Create(&OutboxEmail{
+		Kind:          kind,
+		From:          msg.From,
+		To:            strings.Join(msg.To, ","),
+		Subject:       msg.Subject,
+		HTMLBody:      msg.HTMLBody,
+		TextBody:      msg.TextBody,
+		Attachments:   attachments,
+		Status:        outboxPending,
+		NextAttemptAt: time.Now(),
+	}).Error
+}
+
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return delay
+}
+
+// claimOutboxBatch забирает готовые к отправке письма и продлевает им
+// next_attempt_at на время аренды. SKIP LOCKED позволяет запускать несколько воркеров.
+func claimOutboxBatch() ([]OutboxEmail, error) {
+	var batch []OutboxEmail
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", outboxPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(outboxBatchSize).
+			Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		ids := make([]uint, len(batch))
+		for i := range batch {
+			ids[i] = batch[i].ID
+		}
+		return tx.Model(&OutboxEmail{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(outboxLease)).Error
+	})
+	return batch, err
+}
+
+// deliverOutboxEmail отправляет письмо и записывает результат. Вложения
+// подгружаются только здесь и удаляются, как только письмо отправлено.
+func deliverOutboxEmail(o *OutboxEmail) {
+	err := db.Where("outbox_email_id = ?", o.ID).Order("id").Find(&o.Attachments).Error
+	if err == nil {
+		err = mailer.Send(o.email())
+	}
+	attempts := o.Attempts + 1
+	updates := map[string]interface{}{"attempts": attempts}
+	entry := logger.WithField("outbox_id", o.ID).WithField("attempts", attempts)
+
+	switch {
+	case err == nil:
+		updates["status"] = outboxSent
+		updates["sent_at"] = time.Now()
+		updates["last_error"] = ""
+		entry.Info("Outbox email sent")
+	case attempts >= outboxMaxAttempts:
+		updates["status"] = outboxDead
+		updates["last_error"] = err.Error()
+		entry.WithField("error", err).Error("Outbox email moved to dead letter")
+	default:
+		updates["next_attempt_at"] = time.Now().Add(outboxBackoff(attempts))
+		updates["last_error"] = err.Error()
+		entry.WithField("error", err).Warn("Outbox email delivery failed, will retry")
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&OutboxEmail{}).Where("id = ?", o.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+		if updates["status"] != outboxSent {
+			return nil
+		}
+		return tx.Where("outbox_email_id = ?", o.ID).Delete(&OutboxAttachment{}).Error
+	})
+	if err != nil {
+		entry.WithField("error", err).Error("Failed to update outbox email")
+	}
+}
+
+func processOutbox() {
+	batch, err := claimOutboxBatch()
+	if err != nil {
+		logger.WithField("error", err).Error("Failed to claim outbox emails")
+		return
+	}
+	for i := range batch {
+		deliverOutboxEmail(&batch[i])
+	}
+}
+
+// runOutboxWorker - фоновая доставка писем из outbox.
+func runOutboxWorker() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		processOutbox()
+		<-ticker.C
+	}
+}
+
+func getOutboxEmails(w http.ResponseWriter, r *http.Request) {
+	query := db.Model(&OutboxEmail{}).Order("id desc").
+		Preload("Attachments", func(tx *gorm.DB) *gorm.DB { return tx.Select("id", "outbox_email_id", "name") })
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+
+	var emails []OutboxEmail
+	if err := query.Limit(limit).Find(&emails).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch emails", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(emails)
+}
+
+// retryOutboxEmail возвращает письмо (обычно из dead) в очередь с нуля попыток.
+func retryOutboxEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid email ID", http.StatusBadRequest)
+		return
+	}
+
+	var email OutboxEmail
+	if err := db.First(&email, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Email not found", http.StatusNotFound)
+			return
+		}
+		handleError(w, http.StatusInternalServerError, "Failed to fetch email", err)
+		return
+	}
+	if email.Status == outboxSent {
+		http.Error(w, "Email already sent", http.StatusConflict)
+		return
+	}
+
+	err = db.Model(&email).Updates(map[string]interface{}{
+		"status":          outboxPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	}).Error
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to requeue email", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(email)
+}
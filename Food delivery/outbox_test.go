@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.attempts); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestProcessOutbox(t *testing.T) {
+	openTestDB(t)
+	sent := useMemoryMailer(t)
+	msg, err := renderEmail(emailSupportAck, "en", "guest@example.com", map[string]interface{}{"Name": "Guest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enqueueEmail(db, emailSupportAck, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	processOutbox()
+
+	messages := sent.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "guest@example.com" || messages[0].Subject != msg.Subject {
+		t.Fatalf("sent %+v, want one support ack to guest@example.com", messages)
+	}
+	var row OutboxEmail
+	if err := db.First(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+	if row.Status != outboxSent || row.Attempts != 1 || row.SentAt == nil {
+		t.Errorf("outbox row = %s after %d attempts, want sent", row.Status, row.Attempts)
+	}
+
+	sent.Reset()
+	processOutbox()
+	if n := len(sent.Messages()); n != 0 {
+		t.Errorf("sent email was delivered again: %d messages", n)
+	}
+}
+
+func TestOutboxAttachmentsDeletedAfterDelivery(t *testing.T) {
+	openTestDB(t)
+	sent := useMemoryMailer(t)
+	msg := &Email{
+		To:          []string{"support@example.com"},
+		Subject:     "Support Request",
+		TextBody:    "see attached",
+		Attachments: []Attachment{{Name: "a.txt", Data: []byte("first")}, {Name: "a.txt", Data: []byte("second")}},
+	}
+	if err := enqueueEmail(db, emailSupport, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	processOutbox()
+
+	messages := sent.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(messages))
+	}
+	got := messages[0].Attachments
+	if len(got) != 2 || string(got[0].Data) != "first" || string(got[1].Data) != "second" {
+		t.Errorf("attachments = %+v, want both files with the same name kept apart", got)
+	}
+	var left int64
+	db.Model(&OutboxAttachment{}).Count(&left)
+	if left != 0 {
+		t.Errorf("%d attachments left after delivery", left)
+	}
+}
+
+func TestOutboxAttachmentsMigrationKeepsUnsentAttachments(t *testing.T) {
+	openTestDB(t)
+	// Откатываемся до схемы, где вложения хранились путями в outbox_emails.
+	for migrationVersion() >= 20 {
+		if err := migrateDown(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert := `INSERT INTO outbox_emails (kind, "to", attachments, status) VALUES ('support_ack', 'guest@example.com', '/tmp/upload-1', ?)`
+	if err := db.Exec(insert, outboxPending).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateUp(1); err == nil {
+		t.Fatal("migration dropped the attachments of an unsent email")
+	}
+	if err := db.Exec("UPDATE outbox_emails SET status = ?", outboxSent).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateUp(0); err != nil {
+		t.Fatalf("migration with only sent attachments: %v", err)
+	}
+
+	email := OutboxEmail{Kind: emailSupportAck, To: "guest@example.com", Status: outboxPending,
+		Attachments: []OutboxAttachment{{Name: "photo.jpg", Data: []byte("jpeg")}}}
+	if err := db.Create(&email).Error; err != nil {
+		t.Fatal(err)
+	}
+	for migrationVersion() > 20 {
+		if err := migrateDown(1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateDown(1); err == nil {
+		t.Fatal("down migration dropped queued attachments")
+	}
+}
+
+// migrationVersion - последняя примененная миграция тестовой схемы.
+func migrationVersion() int {
+	var version int
+	db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version)
+	return version
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	// passwordResetCooldown - пока последнее письмо свежее, новое не отправляется.
+	passwordResetCooldown = time.Minute
+	minPasswordLength     = 6
+)
+
+// PasswordResetToken - одноразовый токен сброса пароля. Сам токен уходит
+// только в письме, в базе хранится его sha256.
+type PasswordResetToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index;not null"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+var errInvalidResetToken = errors.New("reset token is invalid, used or expired")
+
+// forgotPassword всегда отвечает одинаково, чтобы по ответу нельзя было узнать,
+// зарегистрирован ли email. Повторный запрос в течение passwordResetCooldown
+// письма не отправляет.
+func forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	err := db.Where("email = ?", strings.TrimSpace(input.Email)).First(&user).Error
+	if err == nil {
+		if err := sendPasswordReset(&user); err != nil {
+			logger.WithField("error", err).Error("Failed to send password reset email")
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		handleError(w, http.StatusInternalServerError, "Internal server error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Если email зарегистрирован, мы отправили ссылку для сброса пароля"})
+}
+
+func sendPasswordReset(user *User) error {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	reset := PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}
+
+	msg, err := renderEmail(emailPasswordReset, user.Language, user.Email, map[string]interface{}{
+		"Link": publicLink("/auth/password/reset", url.Values{"token": {token}}),
+	})
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Блокировка пользователя, чтобы параллельные запросы не прошли проверку оба.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&User{}, user.ID).Error; err != nil {
+			return err
+		}
+		var recent int64
+		err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL AND expires_at > ? AND created_at > ?", user.ID, time.Now(), time.Now().Add(-passwordResetCooldown)).
+			Count(&recent).Error
+		if err != nil || recent > 0 {
+			return err
+		}
+		if err := tx.Create(&reset).Error; err != nil {
+			return err
+		}
+		return enqueueEmail(tx, emailPasswordReset, msg)
+	})
+}
+
+func resetPassword(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.Token == "" {
+		http.Error(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+	if len(input.Password) < minPasswordLength {
+		http.Error(w, fmt.Sprintf("Password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), bcrypt.DefaultCost)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Ошибка сервера", err)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var reset PasswordResetToken
+		err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(input.Token), now).
+			First(&reset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		// Помечаем использованным условно, чтобы два параллельных запроса не прошли оба.
+		result := tx.Model(&PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidResetToken
+		}
+		// Остальные ссылки сброса этого пользователя тоже больше не нужны.
+		if err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", reset.UserID).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", reset.UserID).
+			Update("password", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		return revokeAllSessions(tx, reset.UserID)
+	})
+	if errors.Is(err, errInvalidResetToken) {
+		handleError(w, http.StatusBadRequest, "Invalid or expired token", err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to reset password", err)
+		return
+	}
+
+	log.Println("Пароль сброшен по одноразовому токену")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Пароль изменен. Войдите с новым паролем."})
+}
+
+var passwordResetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="UTF-8"><title>Сброс пароля</title></head>
+<body>
+<form id="resetForm">
+    <h2>Новый пароль</h2>
+    <input type="password" id="password" placeholder="Новый пароль" minlength="{{.MinLength}}" required>
+    <button type="submit">Сохранить</button>
+    <p id="status"></p>
+</form>
+<script>
+document.getElementById('resetForm').addEventListener('submit', async (e) => {
+    e.preventDefault();
+    const response = await fetch('/auth/password/reset', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: {{.Token}}, password: document.getElementById('password').value }),
+    });
+    document.getElementById('status').textContent = response.ok
+        ? 'Пароль изменен. Войдите с новым паролем.'
+        : await response.text();
+});
+</script>
+</body>
+</html>
+`))
+
+// passwordResetForm отдает страницу из письма со ссылкой на сброс пароля.
+func passwordResetForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Invalid token", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	passwordResetPage.Execute(w, map[string]interface{}{
+		"Token":     token,
+		"MinLength": minPasswordLength,
+	})
+}
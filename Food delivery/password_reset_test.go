@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/time/rate"
+)
+
+func TestForgotPasswordIsRateLimited(t *testing.T) {
+	reached := 0
+	handler := rateLimitByRouteMiddleware(rate.NewLimiter(rate.Every(time.Hour), 1), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+	}))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/auth/password/forgot", nil))
+		if w.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, w.Code, want)
+		}
+	}
+	if reached != 1 {
+		t.Errorf("handler reached %d times, want 1", reached)
+	}
+}
+
+func TestForgotPasswordCooldown(t *testing.T) {
+	openTestDB(t)
+	user := User{Name: "Eve", Email: "eve@example.com", Role: roleCustomer, Language: "en"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	forgot := func() {
+		t.Helper()
+		w := httptest.NewRecorder()
+		forgotPassword(w, httptest.NewRequest(http.MethodPost, "/auth/password/forgot", strings.NewReader(`{"email": "eve@example.com"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+	}
+	counts := func() (tokens, emails int64) {
+		t.Helper()
+		db.Model(&PasswordResetToken{}).Where("user_id = ?", user.ID).Count(&tokens)
+		db.Model(&OutboxEmail{}).Where("kind = ?", emailPasswordReset).Count(&emails)
+		return tokens, emails
+	}
+
+	forgot()
+	forgot()
+	if tokens, emails := counts(); tokens != 1 || emails != 1 {
+		t.Fatalf("within the cooldown: %d tokens and %d emails, want 1 and 1", tokens, emails)
+	}
+
+	// Через минуту после письма можно запросить новое.
+	if err := db.Model(&PasswordResetToken{}).Where("user_id = ?", user.ID).
+		Update("created_at", time.Now().Add(-2*passwordResetCooldown)).Error; err != nil {
+		t.Fatal(err)
+	}
+	forgot()
+	if tokens, emails := counts(); tokens != 2 || emails != 2 {
+		t.Errorf("after the cooldown: %d tokens and %d emails, want 2 and 2", tokens, emails)
+	}
+}
+
+func TestResetTokenIsSingleUse(t *testing.T) {
+	openTestDB(t)
+	useTestKeys(t)
+	user, session := createTestSession(t)
+	issue := func(ttl time.Duration) string {
+		t.Helper()
+		token, err := newOpaqueToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reset := PasswordResetToken{UserID: user.ID, TokenHash: hashToken(token), ExpiresAt: time.Now().Add(ttl)}
+		if err := db.Create(&reset).Error; err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	reset := func(token, password string) int {
+		body := `{"token": "` + token + `", "password": "` + password + `"}`
+		w := httptest.NewRecorder()
+		resetPassword(w, httptest.NewRequest(http.MethodPost, "/auth/password/reset", strings.NewReader(body)))
+		return w.Code
+	}
+
+	expired := issue(-time.Minute)
+	first := issue(passwordResetTTL)
+	second := issue(passwordResetTTL)
+
+	if code := reset(expired, "new-password-1"); code != http.StatusBadRequest {
+		t.Errorf("expired token: status = %d, want 400", code)
+	}
+	if code := reset(first, "new-password-1"); code != http.StatusOK {
+		t.Fatalf("first use: status = %d, want 200", code)
+	}
+	var stored User
+	if err := db.First(&stored, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("new-password-1")) != nil {
+		t.Error("password was not changed")
+	}
+	if code := accessStatus(session.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("session after reset: status = %d, want 401", code)
+	}
+
+	// Ни та же ссылка, ни другая, выданная раньше, больше не сработают.
+	if code := reset(first, "new-password-2"); code != http.StatusBadRequest {
+		t.Errorf("reused token: status = %d, want 400", code)
+	}
+	if code := reset(second, "new-password-2"); code != http.StatusBadRequest {
+		t.Errorf("older token: status = %d, want 400", code)
+	}
+	if err := db.First(&stored, user.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored.Password), []byte("new-password-1")) != nil {
+		t.Error("password changed by a used token")
+	}
+}
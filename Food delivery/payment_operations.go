@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Действия со шлюзом после смены статуса заказа или возврата.
+const (
+	paymentOpCapture = "capture"
+	paymentOpVoid    = "void"
+	paymentOpRefund  = "refund"
+)
+
+const (
+	paymentOpPending = "pending"
+	paymentOpDone    = "done"
+	paymentOpDead    = "dead"
+)
+
+var errPaymentOperationPending = errors.New("payment operation is already in progress")
+
+// PaymentOperation - вызов шлюза, записанный в транзакции, которая его вызвала.
+// Шлюз вызывается уже после коммита: сначала сразу из запроса, а если не вышло -
+// фоновым воркером с теми же задержками, что и у outbox. Пока операция pending,
+// платеж занят, и вторую операцию по нему не создать.
+type PaymentOperation struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	PaymentID     uint      `json:"payment_id" gorm:"not null"`
+	RefundID      *uint     `json:"refund_id,omitempty"`
+	Action        string    `json:"action" gorm:"not null"`
+	Amount        Money     `json:"amount" gorm:"not null"`
+	Status        string    `json:"status" gorm:"not null;default:pending"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     string    `json:"last_error"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// idempotencyKey - ключ для шлюза: повтор операции после сбоя не спишет и не
+// вернет деньги второй раз.
+func (op *PaymentOperation) idempotencyKey() string {
+	return fmt.Sprintf("payop_%d", op.ID)
+}
+
+// schedulePaymentOperation записывает операцию в транзакции tx. Первую попытку
+// делает сам запрос после коммита, поэтому воркер не трогает операцию, пока не
+// истечет аренда. Вторую незавершенную операцию по платежу не пропустит частичный
+// уникальный индекс idx_payment_operations_pending_payment.
+func schedulePaymentOperation(tx *gorm.DB, op *PaymentOperation) error {
+	op.Status = paymentOpPending
+	op.NextAttemptAt = time.Now().Add(outboxLease)
+	err := tx.Create(op).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return errPaymentOperationPending
+	}
+	return err
+}
+
+// runPaymentOperation вызывает шлюз без открытой транзакции и записывает результат.
+// Ошибка шлюза откладывает операцию на повтор; если не удалось записать ответ,
+// операция повторится после аренды с тем же ключом идемпотентности.
+func runPaymentOperation(ctx context.Context, op *PaymentOperation) error {
+	var payment Payment
+	if err := db.First(&payment, op.PaymentID).Error; err != nil {
+		return err
+	}
+
+	var result ProviderResult
+	var err error
+	switch op.Action {
+	case paymentOpCapture:
+		result, err = paymentProvider.Capture(ctx, payment.Reference, op.Amount, op.idempotencyKey())
+	case paymentOpVoid:
+		result, err = paymentProvider.Void(ctx, payment.Reference, op.idempotencyKey())
+	case paymentOpRefund:
+		result, err = paymentProvider.Refund(ctx, payment.Reference, op.Amount, op.idempotencyKey())
+	default:
+		err = fmt.Errorf("unknown payment operation %q", op.Action)
+	}
+	if err != nil {
+		failPaymentOperation(op, err)
+		return fmt.Errorf("%w: %v", errPaymentProvider, err)
+	}
+	return completePaymentOperation(op, result)
+}
+
+// completePaymentOperation закрывает операцию и переносит ответ шлюза в платеж
+// или возврат. Если операцию уже закрыл параллельный запуск, ничего не делает.
+func completePaymentOperation(op *PaymentOperation, result ProviderResult) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&PaymentOperation{}).Where("id = ? AND status = ?", op.ID, paymentOpPending).
+			Updates(map[string]interface{}{"status": paymentOpDone, "attempts": op.Attempts + 1, "last_error": ""})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		op.Status = paymentOpDone
+		if op.Action == paymentOpRefund {
+			return completeRefund(tx, *op.RefundID)
+		}
+		var payment Payment
+		if err := tx.First(&payment, op.PaymentID).Error; err != nil {
+			return err
+		}
+		if payment.Status == result.Status {
+			return nil
+		}
+		return setPaymentStatus(tx, &payment, result.Status, nil)
+	})
+}
+
+// failPaymentOperation откладывает операцию с нарастающей задержкой. После
+// outboxMaxAttempts попыток она уходит в dead, а зарезервированный возврат
+// снимается и остается в истории как failed.
+func failPaymentOperation(op *PaymentOperation, cause error) {
+	op.Attempts++
+	updates := map[string]interface{}{"attempts": op.Attempts, "last_error": cause.Error()}
+	entry := logger.WithField("payment_operation_id", op.ID).WithField("attempts", op.Attempts).WithField("error", cause)
+	if op.Attempts >= outboxMaxAttempts {
+		updates["status"] = paymentOpDead
+		entry.Error("Payment operation moved to dead letter")
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(outboxBackoff(op.Attempts))
+		entry.Warn("Payment operation failed, will retry")
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&PaymentOperation{}).Where("id = ? AND status = ?", op.ID, paymentOpPending).Updates(updates)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		if updates["status"] != paymentOpDead || op.Action != paymentOpRefund {
+			return nil
+		}
+		op.Status = paymentOpDead
+		return releaseRefund(tx, *op.RefundID, cause.Error())
+	})
+	if err != nil {
+		entry.WithField("error", err).Error("Failed to update payment operation")
+	}
+}
+
+// claimPaymentOperations забирает операции, которым пора повториться, и продлевает
+// им аренду, как claimOutboxBatch.
+func claimPaymentOperations() ([]PaymentOperation, error) {
+	var batch []PaymentOperation
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", paymentOpPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(outboxBatchSize).
+			Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		ids := make([]uint, len(batch))
+		for i := range batch {
+			ids[i] = batch[i].ID
+		}
+		return tx.Model(&PaymentOperation{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(outboxLease)).Error
+	})
+	return batch, err
+}
+
+func processPaymentOperations() {
+	batch, err := claimPaymentOperations()
+	if err != nil {
+		logger.WithField("error", err).Error("Failed to claim payment operations")
+		return
+	}
+	for i := range batch {
+		if err := runPaymentOperation(context.Background(), &batch[i]); err != nil && !errors.Is(err, errPaymentProvider) {
+			logger.WithField("payment_operation_id", batch[i].ID).WithField("error", err).Error("Failed to record payment operation")
+		}
+	}
+}
+
+// runPaymentOperationWorker - фоновые повторы вызовов шлюза.
+func runPaymentOperationWorker() {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	for {
+		processPaymentOperations()
+		<-ticker.C
+	}
+}
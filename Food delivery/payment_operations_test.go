@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func loadTestPayment(t *testing.T, orderID uint) Payment {
+	t.Helper()
+	var payment Payment
+	if err := db.Where("order_id = ?", orderID).First(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	return payment
+}
+
+// dueNow делает отложенные операции готовыми к повтору, не дожидаясь задержки.
+func dueNow(t *testing.T) {
+	t.Helper()
+	if err := db.Model(&PaymentOperation{}).Where("status = ?", paymentOpPending).
+		Update("next_attempt_at", time.Now().Add(-time.Second)).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusEventFailureDoesNotCapture(t *testing.T) {
+	openTestDB(t)
+	provider := useCountingProvider(t)
+	order, user := createTestOrder(t, paymentAuthorized, OrderLine{Name: "Soup", UnitPrice: 1000, Quantity: 1, LineTotal: 1000})
+	staff := &User{ID: user.ID, Role: roleStaff}
+
+	err := db.Exec(`CREATE FUNCTION fail_status_event() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'status event insert failed';
+END $$ LANGUAGE plpgsql;
+CREATE TRIGGER fail_status_event BEFORE INSERT ON order_status_events
+    FOR EACH ROW EXECUTE FUNCTION fail_status_event();`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := changeOrderStatus(context.Background(), order, orderAccepted, staff, ""); err == nil {
+		t.Fatal("accept succeeded although the status event was not written")
+	}
+	if n := provider.count("capture"); n != 0 {
+		t.Errorf("provider captured %d times for a rolled back transition", n)
+	}
+	var stored Order
+	if err := db.First(&stored, order.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != orderPlaced {
+		t.Errorf("order status = %s, want placed", stored.Status)
+	}
+	if payment := loadTestPayment(t, order.ID); payment.Status != paymentAuthorized {
+		t.Errorf("payment status = %s, want authorized", payment.Status)
+	}
+	var ops int64
+	db.Model(&PaymentOperation{}).Count(&ops)
+	if ops != 0 {
+		t.Errorf("%d payment operations left after rollback", ops)
+	}
+}
+
+func TestCaptureRetriedAfterProviderError(t *testing.T) {
+	openTestDB(t)
+	provider := useCountingProvider(t)
+	provider.failNext("capture", 1)
+	order, user := createTestOrder(t, paymentAuthorized, OrderLine{Name: "Soup", UnitPrice: 1000, Quantity: 1, LineTotal: 1000})
+	staff := &User{ID: user.ID, Role: roleStaff}
+
+	// Ошибка шлюза не откатывает принятие заказа.
+	if err := changeOrderStatus(context.Background(), order, orderAccepted, staff, ""); err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != orderAccepted {
+		t.Fatalf("order status = %s, want accepted", order.Status)
+	}
+	if payment := loadTestPayment(t, order.ID); payment.Status != paymentAuthorized {
+		t.Fatalf("payment status = %s before retry, want authorized", payment.Status)
+	}
+
+	// Пока списание не прошло, отменить заказ нельзя: платеж занят.
+	stale := *order
+	err := cancelOrder(context.Background(), &stale, staff, cancelCustomerRequest, "")
+	if !errors.Is(err, errPaymentOperationPending) {
+		t.Fatalf("cancel error = %v, want errPaymentOperationPending", err)
+	}
+
+	dueNow(t)
+	processPaymentOperations()
+
+	if payment := loadTestPayment(t, order.ID); payment.Status != paymentCaptured {
+		t.Errorf("payment status = %s after retry, want captured", payment.Status)
+	}
+	var op PaymentOperation
+	if err := db.Where("payment_id = ?", loadTestPayment(t, order.ID).ID).First(&op).Error; err != nil {
+		t.Fatal(err)
+	}
+	if op.Status != paymentOpDone || op.Attempts != 2 {
+		t.Errorf("operation = %s after %d attempts, want done after 2", op.Status, op.Attempts)
+	}
+	if n := provider.count("capture"); n != 2 {
+		t.Errorf("capture called %d times, want 2", n)
+	}
+}
+
+func TestRefundReleasedWhenRetriesRunOut(t *testing.T) {
+	openTestDB(t)
+	provider := useCountingProvider(t)
+	provider.failNext("refund", outboxMaxAttempts)
+	order, user := createTestOrder(t, paymentCaptured, OrderLine{Name: "Soup", UnitPrice: 1000, Quantity: 2, LineTotal: 2000})
+	staff := &User{ID: user.ID, Role: roleStaff}
+
+	inputs := []refundLineInput{{OrderLineID: order.Lines[0].ID, Quantity: 1}}
+	refund, err := refundOrderLines(context.Background(), order, inputs, cancelOutOfStock, "", staff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != refundPending {
+		t.Fatalf("refund status = %s, want pending", refund.Status)
+	}
+	if payment := loadTestPayment(t, order.ID); payment.RefundedAmount != refund.Amount {
+		t.Fatalf("reserved %s, want %s", payment.RefundedAmount, refund.Amount)
+	}
+
+	for i := 1; i < outboxMaxAttempts; i++ {
+		dueNow(t)
+		processPaymentOperations()
+	}
+
+	if err := db.First(refund, refund.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if refund.Status != refundFailed {
+		t.Errorf("refund status = %s, want failed", refund.Status)
+	}
+	payment := loadTestPayment(t, order.ID)
+	if payment.Status != paymentCaptured || payment.RefundedAmount != 0 {
+		t.Errorf("payment = %s refunded %s, want captured and nothing refunded", payment.Status, payment.RefundedAmount)
+	}
+	var line OrderLine
+	if err := db.First(&line, order.Lines[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if line.CancelledQuantity != 0 {
+		t.Errorf("cancelled quantity = %d, want 0", line.CancelledQuantity)
+	}
+	var op PaymentOperation
+	if err := db.Where("refund_id = ?", refund.ID).First(&op).Error; err != nil {
+		t.Fatal(err)
+	}
+	if op.Status != paymentOpDead {
+		t.Errorf("operation status = %s, want dead", op.Status)
+	}
+}
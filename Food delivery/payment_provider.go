@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PaymentProvider - платежный шлюз. Authorize резервирует сумму, Capture списывает
+// зарезервированное, Void снимает резерв, Refund возвращает списанное. Если шлюз
+// отвечает асинхронно, Authorize возвращает paymentPending, а итог приходит вебхуком.
+// key - ключ идемпотентности: повтор с тем же ключом шлюз не выполняет второй раз.
+type PaymentProvider interface {
+	Name() string
+	Authorize(ctx context.Context, req AuthorizeRequest) (ProviderResult, error)
+	Capture(ctx context.Context, reference string, amount Money, key string) (ProviderResult, error)
+	Refund(ctx context.Context, reference string, amount Money, key string) (ProviderResult, error)
+	Void(ctx context.Context, reference string, key string) (ProviderResult, error)
+}
+
+// AuthorizeRequest - запрос на резервирование. Token - одноразовый токен карты
+// от платежной формы шлюза; данные карты сервер не видит.
+type AuthorizeRequest struct {
+	PaymentID uint
+	Amount    Money
+	Currency  string
+	Token     string
+}
+
+// ProviderResult - ответ шлюза.
+type ProviderResult struct {
+	Reference     string
+	Status        string
+	FailureReason string
+}
+
+var errPaymentDeclined = errors.New("payment declined")
+
+var paymentProvider PaymentProvider
+
+func newPaymentProvider(c PaymentsConfig) (PaymentProvider, error) {
+	switch c.Provider {
+	case "fake":
+		return &fakePaymentProvider{
+			webhookURL:   publicLink("/payments/webhook", nil),
+			secret:       c.WebhookSecret,
+			webhookDelay: c.FakeWebhookDelay,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", c.Provider)
+	}
+}
+
+func initPayments(c PaymentsConfig) {
+	p, err := newPaymentProvider(c)
+	if err != nil {
+		logger.WithField("error", err).Fatal("Failed to initialize payment provider")
+	}
+	paymentProvider = p
+	logger.WithField("provider", p.Name()).Info("Payment provider initialized")
+}
+
+// Тестовые токены фейкового шлюза.
+const (
+	fakeTokenApproved      = "tok_approved"
+	fakeTokenDeclined      = "tok_declined"
+	fakeTokenAsync         = "tok_async"          // ответ придет вебхуком: authorized
+	fakeTokenAsyncDeclined = "tok_async_declined" // ответ придет вебхуком: failed
+)
+
+// fakePaymentProvider - детерминированный шлюз для локальной разработки и демо.
+// Результат зависит только от токена, ссылка на платеж - от ID платежа. Для
+// асинхронных токенов он сам присылает подписанный вебхук на /payments/webhook.
+type fakePaymentProvider struct {
+	webhookURL   string
+	secret       string
+	webhookDelay time.Duration
+}
+
+func (p *fakePaymentProvider) Name() string { return "fake" }
+
+func (p *fakePaymentProvider) Authorize(ctx context.Context, req AuthorizeRequest) (ProviderResult, error) {
+	ref := fmt.Sprintf("fake_pay_%d", req.PaymentID)
+	if req.Amount <= 0 {
+		return ProviderResult{}, fmt.Errorf("invalid amount %s", req.Amount)
+	}
+	switch req.Token {
+	case fakeTokenApproved:
+		return ProviderResult{Reference: ref, Status: paymentAuthorized}, nil
+	case fakeTokenDeclined:
+		return ProviderResult{Reference: ref, Status: paymentFailed, FailureReason: "card_declined"}, nil
+	case fakeTokenAsync, fakeTokenAsyncDeclined:
+		event := PaymentWebhookEvent{ID: "evt_" + ref, Type: "payment.authorized", Reference: ref, Status: paymentAuthorized}
+		if req.Token == fakeTokenAsyncDeclined {
+			event.Type, event.Status, event.FailureReason = "payment.failed", paymentFailed, "card_declined"
+		}
+		go p.sendWebhook(event)
+		return ProviderResult{Reference: ref, Status: paymentPending}, nil
+	default:
+		return ProviderResult{Reference: ref, Status: paymentFailed, FailureReason: "invalid_token"}, nil
+	}
+}
+
+func (p *fakePaymentProvider) Capture(ctx context.Context, reference string, amount Money, key string) (ProviderResult, error) {
+	return ProviderResult{Reference: reference, Status: paymentCaptured}, nil
+}
+
+func (p *fakePaymentProvider) Refund(ctx context.Context, reference string, amount Money, key string) (ProviderResult, error) {
+	return ProviderResult{Reference: reference, Status: paymentRefunded}, nil
+}
+
+func (p *fakePaymentProvider) Void(ctx context.Context, reference string, key string) (ProviderResult, error) {
+	return ProviderResult{Reference: reference, Status: paymentVoided}, nil
+}
+
+func (p *fakePaymentProvider) sendWebhook(event PaymentWebhookEvent) {
+	time.Sleep(p.webhookDelay)
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, p.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(paymentSignatureHeader, signPaymentWebhook(p.secret, time.Now(), body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.WithField("error", err).Warn("Fake payment provider failed to send webhook")
+		return
+	}
+	resp.Body.Close()
+}
+
+// Подпись вебхука: "t=<unix>,v1=<hex HMAC-SHA256(secret, t + "." + body)>".
+// Время входит в подпись, поэтому старый вебхук нельзя переиграть позже.
+const (
+	paymentSignatureHeader    = "X-Payment-Signature"
+	paymentSignatureTolerance = 5 * time.Minute
+)
+
+var errBadSignature = errors.New("invalid webhook signature")
+
+func signPaymentWebhook(secret string, at time.Time, body []byte) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + ts + ",v1=" + paymentSignature(secret, ts, body)
+}
+
+func paymentSignature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyPaymentWebhook(secret, header string, body []byte, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return errBadSignature
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > paymentSignatureTolerance || d < -paymentSignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", errBadSignature)
+	}
+	if !hmac.Equal([]byte(sig), []byte(paymentSignature(secret, ts, body))) {
+		return errBadSignature
+	}
+	return nil
+}
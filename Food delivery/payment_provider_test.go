@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyPaymentWebhook(t *testing.T) {
+	const secret = "test-webhook-secret"
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"id":"evt_1","type":"payment.authorized"}`)
+	valid := signPaymentWebhook(secret, now, body)
+	tests := []struct {
+		name    string
+		header  string
+		body    []byte
+		wantErr bool
+	}{
+		{"valid", valid, body, false},
+		{"valid with spaces", strings.ReplaceAll(valid, ",", ", "), body, false},
+		{"signed within tolerance", signPaymentWebhook(secret, now.Add(-4*time.Minute), body), body, false},
+		{"tampered body", valid, []byte(`{"id":"evt_1","type":"payment.failed"}`), true},
+		{"wrong secret", signPaymentWebhook("other-secret", now, body), body, true},
+		{"replayed after tolerance", signPaymentWebhook(secret, now.Add(-6*time.Minute), body), body, true},
+		{"timestamp in the future", signPaymentWebhook(secret, now.Add(6*time.Minute), body), body, true},
+		{"missing header", "", body, true},
+		{"missing signature", "t=" + strings.TrimPrefix(strings.Split(valid, ",")[0], "t="), body, true},
+		{"bad timestamp", "t=abc," + strings.Split(valid, ",")[1], body, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyPaymentWebhook(secret, tt.header, tt.body, now)
+			if tt.wantErr && !errors.Is(err, errBadSignature) {
+				t.Errorf("error = %v, want errBadSignature", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Статусы платежа. У заказа payment_status - статус последнего платежа или unpaid.
+const (
+	paymentUnpaid            = "unpaid"
+	paymentPending           = "pending"
+	paymentAuthorized        = "authorized"
+	paymentCaptured          = "captured"
+	paymentPartiallyRefunded = "partially_refunded"
+	paymentRefunded          = "refunded"
+	paymentVoided            = "voided"
+	paymentFailed            = "failed"
+)
+
+var paymentTransitions = map[string][]string{
+	paymentPending:           {paymentAuthorized, paymentFailed},
+	paymentAuthorized:        {paymentCaptured, paymentVoided},
+	paymentCaptured:          {paymentPartiallyRefunded, paymentRefunded},
+	paymentPartiallyRefunded: {paymentPartiallyRefunded, paymentRefunded},
+}
+
+// Payment - попытка оплаты заказа через PaymentProvider.
+type Payment struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	OrderID        uint      `json:"order_id" gorm:"index;not null"`
+	Provider       string    `json:"provider" gorm:"not null"`
+	Reference      string    `json:"reference"`
+	Status         string    `json:"status" gorm:"not null"`
+	Amount         Money     `json:"amount" gorm:"not null"`
+	Currency       string    `json:"currency" gorm:"not null"`
+	RefundedAmount Money     `json:"refunded_amount" gorm:"not null"`
+	FailureReason  string    `json:"failure_reason,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// PaymentWebhookEvent - тело вебхука от шлюза.
+type PaymentWebhookEvent struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	Reference     string `json:"reference"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+// ProcessedWebhookEvent защищает от повторной обработки одного вебхука.
+type ProcessedWebhookEvent struct {
+	ID         string `gorm:"primaryKey"`
+	Type       string
+	ReceivedAt time.Time
+}
+
+var (
+	errPaymentRequired   = errors.New("order is not paid")
+	errPaymentInProgress = errors.New("order already has an active payment")
+	errPaymentProvider   = errors.New("payment provider error")
+)
+
+func canTransitionPayment(from, to string) bool {
+	for _, next := range paymentTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// setPaymentStatus меняет статус платежа условным обновлением и повторяет его в заказе.
+func setPaymentStatus(tx *gorm.DB, payment *Payment, to string, updates map[string]interface{}) error {
+	if !canTransitionPayment(payment.Status, to) {
+		return fmt.Errorf("payment %d: %s -> %s is not allowed", payment.ID, payment.Status, to)
+	}
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["status"] = to
+	res := tx.Model(&Payment{}).Where("id = ? AND status = ?", payment.ID, payment.Status).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("payment %d status was changed concurrently", payment.ID)
+	}
+	payment.Status = to
+	return tx.Model(&Order{}).Where("id = ?", payment.OrderID).Update("payment_status", to).Error
+}
+
+// activePayment - платеж заказа, который ждет ответа, зарезервирован или списан
+// (в том числе возвращен частично).
+func activePayment(tx *gorm.DB, orderID uint) (*Payment, error) {
+	var payment Payment
+	err := tx.Where("order_id = ? AND status IN ?", orderID,
+		[]string{paymentPending, paymentAuthorized, paymentCaptured, paymentPartiallyRefunded}).
+		Order("id DESC").First(&payment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &payment, err
+}
+
+// authorizeOrderPayment создает платеж на сумму заказа и резервирует ее.
+func authorizeOrderPayment(ctx context.Context, order *Order, token string) (*Payment, error) {
+	existing, err := activePayment(db, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, errPaymentInProgress
+	}
+
+	payment := Payment{
+		OrderID:  order.ID,
+		Provider: paymentProvider.Name(),
+		Status:   paymentPending,
+		Amount:   order.Total,
+		Currency: order.Currency,
+	}
+	// Второй одновременный платеж по заказу не пропустит частичный уникальный
+	// индекс idx_payments_active_order.
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payment).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Order{}).Where("id = ?", order.ID).Update("payment_status", paymentPending).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return nil, errPaymentInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := paymentProvider.Authorize(ctx, AuthorizeRequest{
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+		Currency:  payment.Currency,
+		Token:     token,
+	})
+	if err != nil {
+		db.Transaction(func(tx *gorm.DB) error {
+			return setPaymentStatus(tx, &payment, paymentFailed, map[string]interface{}{"failure_reason": err.Error()})
+		})
+		return &payment, fmt.Errorf("%w: %v", errPaymentProvider, err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		payment.Reference = result.Reference
+		if err := tx.Model(&payment).Update("reference", result.Reference).Error; err != nil {
+			return err
+		}
+		if result.Status == paymentPending {
+			return nil
+		}
+		return setPaymentStatus(tx, &payment, result.Status, map[string]interface{}{"failure_reason": result.FailureReason})
+	})
+	payment.FailureReason = result.FailureReason
+	return &payment, err
+}
+
+// preparePaymentForTransition вызывается в транзакции смены статуса заказа, после
+// того как переход за ней закреплен, и только записывает, что сделать со шлюзом:
+// при принятии заказа списать резерв, при отмене - снять его или вернуть остаток
+// (возврат резервируется сразу, с причиной отмены). Сам шлюз вызывается после коммита.
+func preparePaymentForTransition(tx *gorm.DB, order *Order, to string, actor *User) (*PaymentOperation, error) {
+	if to != orderAccepted && to != orderCancelled {
+		return nil, nil
+	}
+	payment, err := activePayment(tx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch to {
+	case orderAccepted:
+		if payment == nil || payment.Status == paymentPending {
+			if cfg.Payments.Required {
+				return nil, errPaymentRequired
+			}
+			return nil, nil
+		}
+		if payment.Status != paymentAuthorized {
+			return nil, nil
+		}
+		op := &PaymentOperation{PaymentID: payment.ID, Action: paymentOpCapture, Amount: payment.Amount}
+		return op, schedulePaymentOperation(tx, op)
+
+	case orderCancelled:
+		if payment == nil || payment.Status == paymentPending {
+			return nil, nil
+		}
+		if payment.Status == paymentAuthorized {
+			op := &PaymentOperation{PaymentID: payment.ID, Action: paymentOpVoid, Amount: payment.Amount}
+			return op, schedulePaymentOperation(tx, op)
+		}
+		remaining := payment.Amount - payment.RefundedAmount
+		if remaining <= 0 {
+			return nil, nil
+		}
+		reason := order.CancelReason
+		if reason == "" {
+			reason = cancelOther
+		}
+		return scheduleRefund(tx, payment, &Refund{Amount: remaining, Reason: reason, ActorID: &actor.ID})
+	}
+	return nil, nil
+}
+
+// payOrder - POST /orders/{id}/payments: покупатель оплачивает свой заказ токеном
+// карты. 201 - платеж создан (authorized или pending), 402 - отказ шлюза.
+func payOrder(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		PaymentToken string `json:"payment_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	user, _ := userFromContext(r.Context())
+	if !canAccessUser(user, order.UserID, "") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if order.Status == orderCancelled || order.Status == orderDelivered {
+		http.Error(w, "Order can no longer be paid", http.StatusConflict)
+		return
+	}
+
+	payment, err := authorizeOrderPayment(r.Context(), order, input.PaymentToken)
+	switch {
+	case errors.Is(err, errPaymentInProgress):
+		handleError(w, http.StatusConflict, "Order already has an active payment", err)
+		return
+	case errors.Is(err, errPaymentProvider):
+		handleError(w, http.StatusBadGateway, "Payment provider error", err)
+		return
+	case err != nil:
+		handleError(w, http.StatusInternalServerError, "Failed to process payment", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if payment.Status == paymentFailed {
+		w.WriteHeader(http.StatusPaymentRequired)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(payment)
+}
+
+// getOrderPayments - GET /orders/{id}/payments: владелец заказа, сотрудники и админы.
+func getOrderPayments(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	user, _ := userFromContext(r.Context())
+	if user.Role != roleStaff && !canAccessUser(user, order.UserID, "") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var payments []Payment
+	if err := db.Where("order_id = ?", order.ID).Order("id").Find(&payments).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch payments", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payments)
+}
+
+// paymentWebhook - POST /payments/webhook: асинхронные результаты от шлюза.
+// Любой ответ не 2xx шлюз повторит позже.
+func paymentWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+	if err := verifyPaymentWebhook(cfg.Payments.WebhookSecret, r.Header.Get(paymentSignatureHeader), body, time.Now()); err != nil {
+		handleError(w, http.StatusUnauthorized, "Invalid signature", err)
+		return
+	}
+	var event PaymentWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" || event.Reference == "" {
+		http.Error(w, "Invalid event", http.StatusBadRequest)
+		return
+	}
+
+	// Отметка об обработке пишется в той же транзакции: если платеж еще не найден
+	// (шлюз ответил раньше, чем мы сохранили reference), шлюз повторит вебхук.
+	var authorized *Payment
+	err = db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&ProcessedWebhookEvent{ID: event.ID, Type: event.Type, ReceivedAt: time.Now()})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		var payment Payment
+		if err := tx.Where("provider = ? AND reference = ?", paymentProvider.Name(), event.Reference).First(&payment).Error; err != nil {
+			return err
+		}
+		if !canTransitionPayment(payment.Status, event.Status) {
+			logger.WithField("payment_id", payment.ID).WithField("event", event.Type).Info("Ignoring stale payment webhook")
+			return nil
+		}
+		if err := setPaymentStatus(tx, &payment, event.Status, map[string]interface{}{"failure_reason": event.FailureReason}); err != nil {
+			return err
+		}
+		if payment.Status == paymentAuthorized {
+			authorized = &payment
+		}
+		return nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to process webhook", err)
+		return
+	}
+	if authorized != nil {
+		voidIfOrderCancelled(r.Context(), authorized)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// voidIfOrderCancelled снимает резерв, который пришел вебхуком уже после отмены заказа.
+func voidIfOrderCancelled(ctx context.Context, payment *Payment) {
+	var order Order
+	if err := db.Select("id", "status").First(&order, payment.OrderID).Error; err != nil || order.Status != orderCancelled {
+		return
+	}
+	op := &PaymentOperation{PaymentID: payment.ID, Action: paymentOpVoid, Amount: payment.Amount}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return schedulePaymentOperation(tx, op)
+	})
+	if err == nil {
+		err = runPaymentOperation(ctx, op)
+	}
+	if err != nil {
+		logger.WithField("payment_id", payment.ID).WithField("error", err).Error("Failed to void payment of cancelled order")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PricingConfig - параметры расчета стоимости заказа.
+type PricingConfig struct {
+	Currency         string             `yaml:"currency"`
+	TaxRate          float64            `yaml:"tax_rate"`
+	DeliveryFee      Money              `yaml:"delivery_fee"`
+	FreeDeliveryOver Money              `yaml:"free_delivery_over"` // 0 - доставка всегда платная
+	PromoCodes       map[string]float64 `yaml:"promo_codes"`        // код -> скидка в процентах
+}
+
+// PriceBreakdown - расчет стоимости заказа по ценам из базы.
+type PriceBreakdown struct {
+	Subtotal    Money  `json:"subtotal"`
+	Discount    Money  `json:"discount"`
+	Tax         Money  `json:"tax"`
+	DeliveryFee Money  `json:"delivery_fee"`
+	Total       Money  `json:"total"`
+	Currency    string `json:"currency"`
+	PromoCode   string `json:"promo_code,omitempty"`
+}
+
+var errUnknownPromoCode = errors.New("unknown promo code")
+
+// priceOrder считает подытог, скидку, налог, доставку и итог в центах. Скидка и
+// налог округляются один раз на весь заказ (половиной вверх), а не по позициям.
+// Налог берется с суммы после скидки, доставка не облагается и бесплатна от
+// FreeDeliveryOver.
+func priceOrder(c PricingConfig, lines []OrderLine, promoCode string) (PriceBreakdown, error) {
+	b := PriceBreakdown{Currency: c.Currency}
+	for _, line := range lines {
+		b.Subtotal += line.LineTotal
+	}
+
+	if code := strings.ToUpper(strings.TrimSpace(promoCode)); code != "" {
+		percent, ok := c.PromoCodes[code]
+		if !ok {
+			return b, fmt.Errorf("%w: %s", errUnknownPromoCode, code)
+		}
+		b.PromoCode = code
+		b.Discount = b.Subtotal.mulBasisPoints(basisPoints(percent / 100))
+	}
+
+	discounted := b.Subtotal - b.Discount
+	b.Tax = discounted.mulBasisPoints(basisPoints(c.TaxRate))
+	if c.FreeDeliveryOver <= 0 || discounted < c.FreeDeliveryOver {
+		b.DeliveryFee = c.DeliveryFee
+	}
+	b.Total = discounted + b.Tax + b.DeliveryFee
+	return b, nil
+}
+
+// add складывает расчеты заказов одной покупки (корзина, разделенная по ресторанам).
+func (b PriceBreakdown) add(other PriceBreakdown) PriceBreakdown {
+	b.Subtotal += other.Subtotal
+	b.Discount += other.Discount
+	b.Tax += other.Tax
+	b.DeliveryFee += other.DeliveryFee
+	b.Total += other.Total
+	if b.PromoCode == "" {
+		b.PromoCode = other.PromoCode
+	}
+	return b
+}
+
+// apply переносит расчет в заказ.
+func (b PriceBreakdown) apply(order *Order) {
+	order.Subtotal = b.Subtotal
+	order.Discount = b.Discount
+	order.Tax = b.Tax
+	order.DeliveryFee = b.DeliveryFee
+	order.Total = b.Total
+	order.Currency = b.Currency
+	order.PromoCode = b.PromoCode
+}
+
+func writePriceMismatch(w http.ResponseWriter, clientTotal Money, b PriceBreakdown) {
+	logger.WithField("client_total", clientTotal.String()).WithField("server_total", b.Total.String()).Warn("Order total mismatch")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   "Order total does not match server price",
+		"pricing": b,
+	})
+}
+
+// quoteOrder считает стоимость без создания заказа, чтобы клиент мог показать
+// итог и отправить его в createOrder. Как и заказ, расчет - для одного ресторана.
+func quoteOrder(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Lines     []orderLineInput `json:"lines"`
+		FoodItems []uint           `json:"food_items"`
+		PromoCode string           `json:"promo_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	lineInputs := input.Lines
+	if len(lineInputs) == 0 {
+		lineInputs = legacyLineInputs(input.FoodItems)
+	}
+	lines, _, err := buildOrderLines(lineInputs)
+	if err == nil {
+		_, err = orderRestaurant(lines)
+	}
+	if err != nil {
+		writeOrderLinesError(w, err)
+		return
+	}
+	breakdown, err := priceOrder(cfg.Pricing, lines, input.PromoCode)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, "Invalid promo code", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"lines":   lines,
+		"pricing": breakdown,
+	})
+}
+
+func writeOrderLinesError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errFoodItemNotFound) {
+		handleError(w, http.StatusNotFound, "Food item not found", err)
+		return
+	}
+	if errors.Is(err, errSoldOut) || errors.Is(err, errUnavailable) {
+		handleError(w, http.StatusConflict, err.Error(), err)
+		return
+	}
+	if errors.Is(err, errMixedRestaurants) || errors.Is(err, errOutOfDeliveryArea) {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	handleError(w, http.StatusBadRequest, "Invalid order lines", err)
+}
+
+// writeSaveOrderError отвечает на ошибку saveNewOrder: 409, если блюдо закончилось,
+// пока заказ оформлялся.
+func writeSaveOrderError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errSoldOut) {
+		handleError(w, http.StatusConflict, err.Error(), err)
+		return
+	}
+	handleError(w, http.StatusInternalServerError, "Failed to create order", err)
+}
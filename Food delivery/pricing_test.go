@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPriceOrder(t *testing.T) {
+	c := PricingConfig{
+		Currency:         "USD",
+		TaxRate:          0.08,
+		DeliveryFee:      299,
+		FreeDeliveryOver: 3000,
+		PromoCodes:       map[string]float64{"SAVE10": 10},
+	}
+	lines := func(totals ...Money) []OrderLine {
+		var out []OrderLine
+		for _, total := range totals {
+			out = append(out, OrderLine{LineTotal: total})
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		config  PricingConfig
+		lines   []OrderLine
+		promo   string
+		want    PriceBreakdown
+		wantErr error
+	}{
+		{
+			name:   "tax and delivery",
+			config: c,
+			lines:  lines(1250, 499),
+			want:   PriceBreakdown{Subtotal: 1749, Tax: 140, DeliveryFee: 299, Total: 2188, Currency: "USD"},
+		},
+		{
+			name:   "promo code is case-insensitive and taxed after discount",
+			config: c,
+			lines:  lines(1250, 499),
+			promo:  " save10 ",
+			want:   PriceBreakdown{Subtotal: 1749, Discount: 175, Tax: 126, DeliveryFee: 299, Total: 1999, Currency: "USD", PromoCode: "SAVE10"},
+		},
+		{
+			name:   "free delivery at threshold",
+			config: c,
+			lines:  lines(3000),
+			want:   PriceBreakdown{Subtotal: 3000, Tax: 240, Total: 3240, Currency: "USD"},
+		},
+		{
+			name:   "discount drops below free delivery threshold",
+			config: c,
+			lines:  lines(3000),
+			promo:  "SAVE10",
+			want:   PriceBreakdown{Subtotal: 3000, Discount: 300, Tax: 216, DeliveryFee: 299, Total: 3215, Currency: "USD", PromoCode: "SAVE10"},
+		},
+		{
+			name:   "tax rounds once per order",
+			config: PricingConfig{Currency: "USD", TaxRate: 0.1},
+			lines:  lines(5, 5),
+			want:   PriceBreakdown{Subtotal: 10, Tax: 1, Total: 11, Currency: "USD"},
+		},
+		{
+			name:   "zero threshold always charges delivery",
+			config: PricingConfig{Currency: "EUR", DeliveryFee: 150},
+			lines:  lines(100000),
+			want:   PriceBreakdown{Subtotal: 100000, DeliveryFee: 150, Total: 100150, Currency: "EUR"},
+		},
+		{
+			name:    "unknown promo code",
+			config:  c,
+			lines:   lines(1000),
+			promo:   "FREE",
+			wantErr: errUnknownPromoCode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := priceOrder(tt.config, tt.lines, tt.promo)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("priceOrder() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceBreakdownAdd(t *testing.T) {
+	a := PriceBreakdown{Subtotal: 1000, Discount: 100, Tax: 72, DeliveryFee: 299, Total: 1271, Currency: "USD", PromoCode: "SAVE10"}
+	b := PriceBreakdown{Subtotal: 500, Discount: 50, Tax: 36, DeliveryFee: 299, Total: 785, Currency: "USD", PromoCode: "SAVE10"}
+	want := PriceBreakdown{Subtotal: 1500, Discount: 150, Tax: 108, DeliveryFee: 598, Total: 2056, Currency: "USD", PromoCode: "SAVE10"}
+	if got := a.add(b); got != want {
+		t.Errorf("add() = %+v, want %+v", got, want)
+	}
+}
@@ -5,6 +5,11 @@ const ordersPerPage = 4;
 
 
 
+function authHeaders() {
+    const token = localStorage.getItem('authToken');
+    return token ? { 'Authorization': `Bearer ${token}` } : {};
+}
+
 async function checkAuthentication() {
     const token = localStorage.getItem('authToken');
 
@@ -60,7 +65,9 @@ async function checkAdminRole() {
     }
 
     try {
-        const response = await fetch(`${SERVER_URL}/users/by-email?email=${currentUser.email}`);
+        const response = await fetch(`${SERVER_URL}/users/by-email?email=${currentUser.email}`, {
+            headers: authHeaders(),
+        });
         if (!response.ok) {
             throw new Error('Failed to check admin role');
         }
@@ -81,7 +88,9 @@ async function loadUserProfile() {
     }
 
     try {
-        const response = await fetch(`${SERVER_URL}/users/by-email?email=${currentUser.email}`);
+        const response = await fetch(`${SERVER_URL}/users/by-email?email=${currentUser.email}`, {
+            headers: authHeaders(),
+        });
         if (!response.ok) {
             throw new Error(`❌ Ошибка запроса профиля: ${response.statusText}`);
         }
@@ -107,6 +116,7 @@ async function loadUserProfile() {
 
         const orders = await fetchUserOrders(user.id);
         displayOrderHistory(orders);
+        subscribeToOrderUpdates(orders);
     } catch (error) {
         console.error('❌ Ошибка загрузки профиля:', error);
     }
@@ -115,7 +125,9 @@ async function loadUserProfile() {
 async function fetchUserOrders(userId) {
     try {
         console.log(`🔍 Загружаем заказы для пользователя ID: ${userId}`);
-        const response = await fetch(`${SERVER_URL}/orders/by-user?userId=${userId}`);
+        const response = await fetch(`${SERVER_URL}/orders/by-user?userId=${userId}`, {
+            headers: authHeaders(),
+        });
         
         if (!response.ok) {
             throw new Error(`Failed to fetch orders: ${response.statusText}`);
@@ -146,15 +158,80 @@ function displayOrderHistory(orders) {
     orderHistoryContainer.innerHTML = orders.map(order => `
         <div class="order-item">
             <h4>Order #${order.id}</h4>
+            <p><strong>Status:</strong> <span id="order-status-${order.id}">${formatOrderStatus(order.status)}</span></p>
             <p><strong>Total:</strong> $${order.total.toFixed(2)}</p>
             <p><strong>Address:</strong> ${order.address}</p>
             <p><strong>Items:</strong></p>
             <ul>
-                ${order.food_items.map(item => `<li>${item.name} - $${item.price.toFixed(2)}</li>`).join('')}
+                ${(order.lines || []).map(line => `<li>${line.name} × ${line.quantity} - $${line.line_total.toFixed(2)}</li>`).join('')}
             </ul>
         </div>
     `).join('');
 }
+function formatOrderStatus(status) {
+    return (status || 'placed').replace(/_/g, ' ');
+}
+
+// Живые обновления статусов вместо повторных запросов /orders/by-user.
+const orderStreams = {};
+
+function subscribeToOrderUpdates(orders) {
+    (orders || [])
+        .filter(order => order.status !== 'delivered' && order.status !== 'cancelled')
+        .forEach(order => openOrderStream(order.id, null));
+}
+
+// EventSource не передает Authorization, поэтому в URL идет одноразовый stream token
+// (живет минуту и подходит только для потоков), а не access token.
+async function fetchStreamToken() {
+    const response = await fetch(`${SERVER_URL}/auth/stream-token`, { method: 'POST', headers: authHeaders() });
+    if (response.status === 401 && await refreshAuthToken()) return fetchStreamToken();
+    if (!response.ok) return null;
+    return (await response.json()).stream_token;
+}
+
+async function openOrderStream(orderId, lastEventId) {
+    if (!localStorage.getItem('authToken') || orderStreams[orderId]) return;
+    orderStreams[orderId] = true;
+    const streamToken = await fetchStreamToken().catch(() => null);
+    if (!streamToken) {
+        delete orderStreams[orderId];
+        return;
+    }
+
+    const params = new URLSearchParams({ stream_token: streamToken });
+    if (lastEventId) params.set('last_event_id', lastEventId);
+    const source = new EventSource(`${SERVER_URL}/orders/${orderId}/events?${params}`);
+    orderStreams[orderId] = source;
+    let lastId = lastEventId;
+
+    // Отстали слишком сильно, сервер не стал отдавать историю: перечитываем заказы.
+    source.addEventListener('reset', event => {
+        lastId = event.lastEventId;
+        loadUserProfile();
+    });
+
+    source.addEventListener('status', event => {
+        lastId = event.lastEventId;
+        const update = JSON.parse(event.data);
+        const statusElement = document.getElementById(`order-status-${orderId}`);
+        if (statusElement) statusElement.textContent = formatOrderStatus(update.to_status);
+        if (update.to_status === 'delivered' || update.to_status === 'cancelled') {
+            source.close();
+            delete orderStreams[orderId];
+        }
+    });
+
+    // Сетевые обрывы EventSource переживает сам, но переподключается с тем же stream
+    // token, который к тому времени истек. Закрытое соединение - берем новый токен и
+    // продолжаем с последнего события.
+    source.onerror = () => {
+        if (source.readyState !== EventSource.CLOSED) return;
+        delete orderStreams[orderId];
+        openOrderStream(orderId, lastId);
+    };
+}
+
 function updateAuthUI() {
     const authLink = document.getElementById('authLink');
     const authLinkText = document.getElementById('authLinkText');
@@ -178,8 +255,8 @@ function updateAuthUI() {
 }
 
 
-function handleLogout() {
-    localStorage.removeItem('authToken');
+async function handleLogout() {
+    await logoutOnServer();
     localStorage.removeItem('currentUser');
     currentUser = null;
     window.location.href = 'auth.html';
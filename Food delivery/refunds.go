@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Причины отмены заказа.
+const (
+	cancelCustomerRequest    = "customer_request"
+	cancelRestaurantRejected = "restaurant_rejected"
+	cancelOutOfStock         = "out_of_stock"
+	cancelPaymentFailed      = "payment_failed"
+	cancelOther              = "other"
+)
+
+var cancelReasons = map[string]bool{
+	cancelCustomerRequest:    true,
+	cancelRestaurantRejected: true,
+	cancelOutOfStock:         true,
+	cancelPaymentFailed:      true,
+	cancelOther:              true,
+}
+
+const (
+	refundPending   = "pending"
+	refundSucceeded = "succeeded"
+	refundFailed    = "failed"
+)
+
+// Refund - возврат денег по платежу: полный при отмене заказа или частичный по позициям.
+type Refund struct {
+	ID            uint         `json:"id" gorm:"primaryKey"`
+	PaymentID     uint         `json:"payment_id" gorm:"index;not null"`
+	OrderID       uint         `json:"order_id" gorm:"index;not null"`
+	Amount        Money        `json:"amount" gorm:"not null"`
+	Currency      string       `json:"currency" gorm:"not null"`
+	Reason        string       `json:"reason" gorm:"not null"`
+	Note          string       `json:"note,omitempty"`
+	Status        string       `json:"status" gorm:"not null"`
+	FailureReason string       `json:"failure_reason,omitempty"`
+	ActorID       *uint        `json:"actor_id"`
+	Lines         []RefundLine `json:"lines,omitempty" gorm:"foreignKey:RefundID;constraint:OnDelete:CASCADE"`
+	CreatedAt     time.Time    `json:"created_at"`
+	UpdatedAt     time.Time    `json:"updated_at"`
+}
+
+// RefundLine - отмененные штуки одной позиции заказа в частичном возврате.
+type RefundLine struct {
+	ID          uint  `json:"id" gorm:"primaryKey"`
+	RefundID    uint  `json:"refund_id" gorm:"index;not null"`
+	OrderLineID uint  `json:"order_line_id" gorm:"not null"`
+	Quantity    int   `json:"quantity" gorm:"not null"`
+	Amount      Money `json:"amount" gorm:"not null"`
+}
+
+// refundLineInput - позиция в запросе на частичный возврат.
+type refundLineInput struct {
+	OrderLineID uint `json:"order_line_id"`
+	Quantity    int  `json:"quantity"`
+}
+
+var (
+	errUnknownCancelReason = errors.New("unknown cancel reason")
+	errNothingToRefund     = errors.New("order has no captured payment to refund")
+	errRefundTooLarge      = errors.New("refund exceeds what is left on the payment or order line")
+)
+
+// lineRefundAmount - доля оплаченного за qty штук позиции. Скидка и налог делятся
+// пропорционально подытогу, доставка не возвращается; округление половиной вверх.
+func lineRefundAmount(order *Order, line *OrderLine, qty int) Money {
+	if order.Subtotal <= 0 {
+		return 0
+	}
+	goods := int64(order.Total - order.DeliveryFee)
+	value := int64(line.UnitPrice.times(qty))
+	product := value * goods
+	amount := product / int64(order.Subtotal)
+	if rem := product % int64(order.Subtotal); rem*2 >= int64(order.Subtotal) {
+		amount++
+	}
+	return Money(amount)
+}
+
+// scheduleRefund резервирует возврат в транзакции tx и записывает операцию для
+// шлюза. Сумма и отмененные штуки резервируются условными обновлениями, поэтому
+// два одновременных возврата не вернут больше оплаченного.
+func scheduleRefund(tx *gorm.DB, payment *Payment, refund *Refund) (*PaymentOperation, error) {
+	refund.PaymentID = payment.ID
+	refund.OrderID = payment.OrderID
+	refund.Currency = payment.Currency
+	refund.Status = refundPending
+
+	res := tx.Model(&Payment{}).
+		Where("id = ? AND status IN ? AND refunded_amount + ? <= amount", payment.ID,
+			[]string{paymentCaptured, paymentPartiallyRefunded}, refund.Amount).
+		Update("refunded_amount", gorm.Expr("refunded_amount + ?", refund.Amount))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errRefundTooLarge
+	}
+	for _, line := range refund.Lines {
+		res := tx.Model(&OrderLine{}).
+			Where("id = ? AND order_id = ? AND cancelled_quantity + ? <= quantity", line.OrderLineID, payment.OrderID, line.Quantity).
+			Update("cancelled_quantity", gorm.Expr("cancelled_quantity + ?", line.Quantity))
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil, errRefundTooLarge
+		}
+	}
+	if err := tx.Create(refund).Error; err != nil {
+		return nil, err
+	}
+	op := &PaymentOperation{PaymentID: payment.ID, RefundID: &refund.ID, Action: paymentOpRefund, Amount: refund.Amount}
+	return op, schedulePaymentOperation(tx, op)
+}
+
+// completeRefund записывает успешный ответ шлюза: возврат succeeded, штуки
+// возвращаются на склад, платеж становится partially_refunded или refunded.
+func completeRefund(tx *gorm.DB, refundID uint) error {
+	var refund Refund
+	if err := tx.Preload("Lines").First(&refund, refundID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&refund).Update("status", refundSucceeded).Error; err != nil {
+		return err
+	}
+	if err := releaseRefundedStock(tx, refund.Lines); err != nil {
+		return err
+	}
+	var payment Payment
+	if err := tx.First(&payment, refund.PaymentID).Error; err != nil {
+		return err
+	}
+	to := paymentPartiallyRefunded
+	if payment.RefundedAmount >= payment.Amount {
+		to = paymentRefunded
+	}
+	// Статус сравнивается со свежим значением, поэтому переход тут всегда разрешен.
+	return setPaymentStatus(tx, &payment, to, nil)
+}
+
+// releaseRefund снимает резерв возврата, от которого шлюз так и не получил ответа:
+// возврат остается в истории как failed.
+func releaseRefund(tx *gorm.DB, refundID uint, reason string) error {
+	var refund Refund
+	if err := tx.Preload("Lines").First(&refund, refundID).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&refund).Updates(map[string]interface{}{"status": refundFailed, "failure_reason": reason}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&Payment{}).Where("id = ?", refund.PaymentID).
+		Update("refunded_amount", gorm.Expr("refunded_amount - ?", refund.Amount)).Error; err != nil {
+		return err
+	}
+	for _, line := range refund.Lines {
+		if err := tx.Model(&OrderLine{}).Where("id = ?", line.OrderLineID).
+			Update("cancelled_quantity", gorm.Expr("cancelled_quantity - ?", line.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refundOrderLines - частичная отмена: возврат за qty штук выбранных позиций.
+func refundOrderLines(ctx context.Context, order *Order, inputs []refundLineInput, reason, note string, actor *User) (*Refund, error) {
+	if order.Status == orderCancelled {
+		return nil, fmt.Errorf("%w: order is cancelled", errInvalidTransition)
+	}
+	payment, err := activePayment(db, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil || (payment.Status != paymentCaptured && payment.Status != paymentPartiallyRefunded) {
+		return nil, errNothingToRefund
+	}
+
+	byID := make(map[uint]*OrderLine, len(order.Lines))
+	for i := range order.Lines {
+		byID[order.Lines[i].ID] = &order.Lines[i]
+	}
+	refund := &Refund{Reason: reason, Note: note, ActorID: &actor.ID}
+	for _, in := range inputs {
+		line, ok := byID[in.OrderLineID]
+		if !ok {
+			return nil, fmt.Errorf("order line %d does not belong to order %d", in.OrderLineID, order.ID)
+		}
+		if in.Quantity <= 0 || in.Quantity > line.Quantity-line.CancelledQuantity {
+			return nil, fmt.Errorf("%w: line %d", errRefundTooLarge, line.ID)
+		}
+		amount := lineRefundAmount(order, line, in.Quantity)
+		refund.Lines = append(refund.Lines, RefundLine{OrderLineID: line.ID, Quantity: in.Quantity, Amount: amount})
+		refund.Amount += amount
+	}
+	if len(refund.Lines) == 0 {
+		return nil, errors.New("no lines to refund")
+	}
+	// Копейки от округления по позициям не должны превысить остаток платежа.
+	if remaining := payment.Amount - payment.RefundedAmount; refund.Amount > remaining {
+		refund.Amount = remaining
+	}
+
+	var op *PaymentOperation
+	err = db.Transaction(func(tx *gorm.DB) error {
+		op, err = scheduleRefund(tx, payment, refund)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Ошибка шлюза не отменяет возврат: он остается pending, и его повторит воркер.
+	if err := runPaymentOperation(ctx, op); err != nil {
+		logger.WithField("refund_id", refund.ID).WithField("error", err).Warn("Refund is pending, will retry")
+	}
+	return refund, db.Preload("Lines").First(refund, refund.ID).Error
+}
+
+// cancelOrder отменяет заказ с причиной; оплата снимается или возвращается в
+// changeOrderStatus через preparePaymentForTransition.
+func cancelOrder(ctx context.Context, order *Order, actor *User, reason, note string) error {
+	if !cancelReasons[reason] {
+		return fmt.Errorf("%w: %q", errUnknownCancelReason, reason)
+	}
+	order.CancelReason = reason
+	return changeOrderStatus(ctx, order, orderCancelled, actor, note)
+}
+
+func writeRefundError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNothingToRefund), errors.Is(err, errRefundTooLarge), errors.Is(err, errInvalidTransition),
+		errors.Is(err, errPaymentOperationPending):
+		handleError(w, http.StatusConflict, err.Error(), err)
+	default:
+		handleError(w, http.StatusBadRequest, "Invalid refund", err)
+	}
+}
+
+// createRefund - POST /orders/{id}/refunds: частичный возврат по позициям
+// для сотрудников и админов. 201 - возврат прошел, 202 - шлюз не ответил, и
+// возврат в статусе pending повторит воркер.
+func createRefund(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Lines  []refundLineInput `json:"lines"`
+		Reason string            `json:"reason"`
+		Note   string            `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if input.Reason == "" {
+		input.Reason = cancelOther
+	}
+	if !cancelReasons[input.Reason] {
+		http.Error(w, "Unknown refund reason", http.StatusBadRequest)
+		return
+	}
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	actor, _ := userFromContext(r.Context())
+	refund, err := refundOrderLines(r.Context(), order, input.Lines, input.Reason, input.Note, actor)
+	if err != nil {
+		writeRefundError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if refund.Status == refundPending {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(refund)
+}
+
+// getOrderRefunds - GET /orders/{id}/refunds: владелец заказа, сотрудники и админы.
+func getOrderRefunds(w http.ResponseWriter, r *http.Request) {
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	user, _ := userFromContext(r.Context())
+	if user.Role != roleStaff && !canAccessUser(user, order.UserID, "") {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	var refunds []Refund
+	if err := db.Preload("Lines").Where("order_id = ?", order.ID).Order("id").Find(&refunds).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch refunds", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refunds)
+}
+
+// rejectOrder - POST /orders/{id}/reject: ресторан отказывается от заказа,
+// оплата возвращается полностью.
+func rejectOrder(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Note string `json:"note"`
+	}
+	// Тело необязательно.
+	json.NewDecoder(r.Body).Decode(&input)
+
+	order, ok := loadOrderFromPath(w, r)
+	if !ok {
+		return
+	}
+	actor, _ := userFromContext(r.Context())
+	if err := cancelOrder(r.Context(), order, actor, cancelRestaurantRejected, input.Note); err != nil {
+		writeStatusChangeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+}
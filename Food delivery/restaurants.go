@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const earthRadiusKm = 6371.0
+
+// Restaurant - ресторан маркетплейса. Меню - блюда с его RestaurantID, часы
+// работы - правила доступности с его RestaurantID (как у блюд, см. availability.go),
+// сотрудники - пользователи с ролью staff и его RestaurantID. DeliveryRadiusKm = 0 -
+// доставка без ограничения расстояния.
+type Restaurant struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	Name             string    `json:"name" gorm:"not null"`
+	Slug             string    `json:"slug" gorm:"uniqueIndex;not null"`
+	Address          string    `json:"address"`
+	Latitude         float64   `json:"latitude"`
+	Longitude        float64   `json:"longitude"`
+	DeliveryRadiusKm float64   `json:"delivery_radius_km" gorm:"not null;default:0"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+
+	// Open - ресторан работает сейчас, иначе OpensAt - когда откроется.
+	Open    bool       `json:"open" gorm:"-"`
+	OpensAt *time.Time `json:"opens_at,omitempty" gorm:"-"`
+	// DistanceKm - расстояние до точки из ?lat=&lng=.
+	DistanceKm *float64 `json:"distance_km,omitempty" gorm:"-"`
+}
+
+var (
+	errRestaurantNotFound = errors.New("restaurant not found")
+	errMixedRestaurants   = errors.New("order lines belong to different restaurants, use the cart to order from several")
+	errOutOfDeliveryArea  = errors.New("delivery address is outside the delivery area")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// distanceKm - расстояние по поверхности Земли между двумя точками (формула гаверсинусов).
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLng := toRad(lat2-lat1), toRad(lng2-lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// delivers - доставляет ли ресторан в точку lat, lng.
+func (rest *Restaurant) delivers(lat, lng float64) bool {
+	return rest.DeliveryRadiusKm == 0 || distanceKm(rest.Latitude, rest.Longitude, lat, lng) <= rest.DeliveryRadiusKm
+}
+
+// slugify делает slug из названия: латиница и цифры через дефис.
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// validateRestaurant проверяет ресторан перед сохранением; пустой slug строится из названия.
+func validateRestaurant(rest *Restaurant) error {
+	rest.Name = strings.TrimSpace(rest.Name)
+	rest.Slug = strings.TrimSpace(rest.Slug)
+	if rest.Slug == "" {
+		rest.Slug = slugify(rest.Name)
+	}
+	switch {
+	case rest.Name == "" || utf8.RuneCountInString(rest.Name) > maxMenuNameLength:
+		return fmt.Errorf("name must be 1 to %d characters", maxMenuNameLength)
+	case !slugPattern.MatchString(rest.Slug):
+		return errors.New("slug must contain only lowercase latin letters, digits and dashes")
+	case rest.Latitude < -90 || rest.Latitude > 90 || rest.Longitude < -180 || rest.Longitude > 180:
+		return errors.New("latitude must be within ±90 and longitude within ±180")
+	case rest.DeliveryRadiusKm < 0:
+		return errors.New("delivery_radius_km must be non-negative")
+	}
+	return nil
+}
+
+// defaultRestaurantID - ресторан, к которому относятся блюда без явного ресторана:
+// самый первый, созданный миграцией.
+func defaultRestaurantID(tx *gorm.DB) (uint, error) {
+	var rest Restaurant
+	if err := tx.Select("id").Order("id").First(&rest).Error; err != nil {
+		return 0, err
+	}
+	return rest.ID, nil
+}
+
+// restaurantExists проверяет, что ресторан id есть в базе.
+func restaurantExists(id uint) (bool, error) {
+	var count int64
+	err := db.Model(&Restaurant{}).Where("id = ?", id).Count(&count).Error
+	return count > 0, err
+}
+
+// restaurantFromRequest - ресторан из /restaurants/{rid}/... или ?restaurant_id=; 0 - все.
+func restaurantFromRequest(r *http.Request) (uint, error) {
+	v, ok := mux.Vars(r)["rid"]
+	if !ok {
+		v = r.URL.Query().Get("restaurant_id")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("invalid restaurant id")
+	}
+	return uint(id), nil
+}
+
+// byRestaurant оставляет в выборке блюд только меню ресторана; 0 - все рестораны.
+func byRestaurant(q *gorm.DB, restaurantID uint) *gorm.DB {
+	if restaurantID == 0 {
+		return q
+	}
+	return q.Where("food_items.restaurant_id = ?", restaurantID)
+}
+
+// withRestaurant - обертка для /restaurants/{rid}/...: 404, если ресторана нет.
+func withRestaurant(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := restaurantFromRequest(r)
+		if err != nil {
+			http.Error(w, "Invalid restaurant ID", http.StatusBadRequest)
+			return
+		}
+		exists, err := restaurantExists(id)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, "Failed to fetch restaurant", err)
+			return
+		}
+		if !exists {
+			http.Error(w, "Restaurant not found", http.StatusNotFound)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// staffRestaurant - ресторан, заказами которого ограничен сотрудник; 0 - без
+// ограничения (админы, сотрудники всего маркетплейса и покупатели).
+func staffRestaurant(user *User) uint {
+	if user.Role == roleStaff && user.RestaurantID != nil {
+		return *user.RestaurantID
+	}
+	return 0
+}
+
+// ordersRestaurantFilter - ресторан для списков и потоков заказов: свой у сотрудника
+// ресторана, иначе ?restaurant_id= (0 - все). Пишет ответ об ошибке сам.
+func ordersRestaurantFilter(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	user, _ := userFromContext(r.Context())
+	if id := staffRestaurant(user); id != 0 {
+		return id, true
+	}
+	id, err := restaurantFromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid restaurant_id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// orderRestaurant - ресторан заказа; все позиции должны быть из одного ресторана.
+func orderRestaurant(lines []OrderLine) (uint, error) {
+	for _, line := range lines[1:] {
+		if line.RestaurantID != lines[0].RestaurantID {
+			return 0, errMixedRestaurants
+		}
+	}
+	return lines[0].RestaurantID, nil
+}
+
+// splitByRestaurant делит позиции по ресторанам в порядке первого появления.
+func splitByRestaurant(lines []OrderLine) [][]OrderLine {
+	var groups [][]OrderLine
+	index := map[uint]int{}
+	for _, line := range lines {
+		i, ok := index[line.RestaurantID]
+		if !ok {
+			i = len(groups)
+			index[line.RestaurantID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], line)
+	}
+	return groups
+}
+
+// restaurantNames - названия ресторанов по ID.
+func restaurantNames(ids []uint) (map[uint]string, error) {
+	var restaurants []Restaurant
+	if err := db.Select("id", "name").Where("id IN ?", ids).Find(&restaurants).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(restaurants))
+	for _, rest := range restaurants {
+		names[rest.ID] = rest.Name
+	}
+	return names, nil
+}
+
+// checkDeliveryArea проверяет, что все рестораны доставляют в точку lat, lng.
+// Без координат проверка пропускается: адрес - свободный текст.
+func checkDeliveryArea(restaurantIDs []uint, lat, lng *float64) error {
+	if lat == nil || lng == nil {
+		return nil
+	}
+	var restaurants []Restaurant
+	if err := db.Where("id IN ?", restaurantIDs).Find(&restaurants).Error; err != nil {
+		return err
+	}
+	for i := range restaurants {
+		if !restaurants[i].delivers(*lat, *lng) {
+			return fmt.Errorf("%w of %s", errOutOfDeliveryArea, restaurants[i].Name)
+		}
+	}
+	return nil
+}
+
+// applyHours заполняет Open и OpensAt по часам работы ресторанов.
+func (s *menuSchedule) applyHours(restaurants []Restaurant, now time.Time) {
+	for i := range restaurants {
+		restaurants[i].Open, restaurants[i].OpensAt = openWindow(s.byRestaurant[restaurants[i].ID], now)
+	}
+}
+
+// parseLocation разбирает ?lat=&lng=; ok = false, если координаты не переданы.
+func parseLocation(r *http.Request) (lat, lng float64, ok bool, err error) {
+	q := r.URL.Query()
+	if q.Get("lat") == "" && q.Get("lng") == "" {
+		return 0, 0, false, nil
+	}
+	lat, err1 := strconv.ParseFloat(q.Get("lat"), 64)
+	lng, err2 := strconv.ParseFloat(q.Get("lng"), 64)
+	if err1 != nil || err2 != nil || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return 0, 0, false, errors.New("lat and lng must be valid coordinates")
+	}
+	return lat, lng, true, nil
+}
+
+// getRestaurants - GET /restaurants[?lat=&lng=]: с координатами - только рестораны,
+// которые туда доставляют, от ближнего к дальнему.
+func getRestaurants(w http.ResponseWriter, r *http.Request) {
+	lat, lng, located, err := parseLocation(r)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	var restaurants []Restaurant
+	if err := db.Order("name, id").Find(&restaurants).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch restaurants", err)
+		return
+	}
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch restaurants", err)
+		return
+	}
+	schedule.applyHours(restaurants, time.Now())
+	if located {
+		nearby := restaurants[:0]
+		for _, rest := range restaurants {
+			if rest.delivers(lat, lng) {
+				distance := distanceKm(rest.Latitude, rest.Longitude, lat, lng)
+				rest.DistanceKm = &distance
+				nearby = append(nearby, rest)
+			}
+		}
+		restaurants = nearby
+		sort.SliceStable(restaurants, func(i, j int) bool { return *restaurants[i].DistanceKm < *restaurants[j].DistanceKm })
+	}
+	if restaurants == nil {
+		restaurants = []Restaurant{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restaurants)
+}
+
+// getRestaurant - GET /restaurants/{rid}.
+func getRestaurant(w http.ResponseWriter, r *http.Request) {
+	id, err := restaurantFromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid restaurant ID", http.StatusBadRequest)
+		return
+	}
+	var rest Restaurant
+	if err := db.First(&rest, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "Restaurant not found", http.StatusNotFound)
+			return
+		}
+		handleError(w, http.StatusInternalServerError, "Failed to fetch restaurant", err)
+		return
+	}
+	schedule, err := loadMenuSchedule()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch restaurant", err)
+		return
+	}
+	rest.Open, rest.OpensAt = openWindow(schedule.byRestaurant[rest.ID], time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rest)
+}
+
+// saveRestaurant - общая часть POST /restaurants и PUT /restaurants/{rid}.
+func saveRestaurant(w http.ResponseWriter, r *http.Request, id uint) {
+	var rest Restaurant
+	if err := json.NewDecoder(r.Body).Decode(&rest); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if err := validateRestaurant(&rest); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	rest.ID = id
+	status := http.StatusCreated
+	var err error
+	if id == 0 {
+		err = db.Create(&rest).Error
+	} else {
+		status = http.StatusOK
+		res := db.Model(&Restaurant{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"name":               rest.Name,
+			"slug":               rest.Slug,
+			"address":            rest.Address,
+			"latitude":           rest.Latitude,
+			"longitude":          rest.Longitude,
+			"delivery_radius_km": rest.DeliveryRadiusKm,
+			"updated_at":         time.Now(),
+		})
+		if err = res.Error; err == nil && res.RowsAffected == 0 {
+			err = errRestaurantNotFound
+		}
+		if err == nil {
+			err = db.First(&rest, id).Error
+		}
+	}
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		handleError(w, http.StatusConflict, "Slug is already used by another restaurant", err)
+		return
+	case errors.Is(err, errRestaurantNotFound):
+		http.Error(w, "Restaurant not found", http.StatusNotFound)
+		return
+	case err != nil:
+		handleError(w, http.StatusInternalServerError, "Failed to save restaurant", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(rest)
+}
+
+// createRestaurant - POST /restaurants (админ).
+func createRestaurant(w http.ResponseWriter, r *http.Request) {
+	saveRestaurant(w, r, 0)
+}
+
+// updateRestaurant - PUT /restaurants/{rid} (админ): полная замена полей.
+func updateRestaurant(w http.ResponseWriter, r *http.Request) {
+	id, err := restaurantFromRequest(r)
+	if err != nil {
+		http.Error(w, "Invalid restaurant ID", http.StatusBadRequest)
+		return
+	}
+	saveRestaurant(w, r, id)
+}
+
+// getRestaurantStaff - GET /restaurants/{rid}/staff (админ): сотрудники ресторана.
+func getRestaurantStaff(w http.ResponseWriter, r *http.Request) {
+	id, _ := restaurantFromRequest(r)
+	users := []User{}
+	if err := db.Where("restaurant_id = ? AND role = ?", id, roleStaff).Order("id").Find(&users).Error; err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to fetch staff", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// assignRestaurantStaff - PUT /restaurants/{rid}/staff/{userId} (админ): делает
+// пользователя сотрудником ресторана. Он будет видеть только заказы этого ресторана.
+// Админа назначить нельзя: его доступ не ограничивается рестораном.
+func assignRestaurantStaff(w http.ResponseWriter, r *http.Request) {
+	id, _ := restaurantFromRequest(r)
+	setRestaurantStaff(w, r, db.Model(&User{}).Where("role <> ?", roleAdmin),
+		map[string]interface{}{"role": roleStaff, "restaurant_id": id})
+}
+
+// removeRestaurantStaff - DELETE /restaurants/{rid}/staff/{userId} (админ): сотрудник
+// снова становится покупателем.
+func removeRestaurantStaff(w http.ResponseWriter, r *http.Request) {
+	id, _ := restaurantFromRequest(r)
+	setRestaurantStaff(w, r, db.Model(&User{}).Where("role = ? AND restaurant_id = ?", roleStaff, id),
+		map[string]interface{}{"role": roleCustomer, "restaurant_id": nil})
+}
+
+// setRestaurantStaff применяет updates к пользователю {userId} из выборки q.
+func setRestaurantStaff(w http.ResponseWriter, r *http.Request, q *gorm.DB, updates map[string]interface{}) {
+	userID, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	res := q.Where("id = ?", userID).Updates(updates)
+	if res.Error != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to update staff", res.Error)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
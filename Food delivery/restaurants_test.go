@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSplitByRestaurant(t *testing.T) {
+	lines := []OrderLine{
+		{Name: "Pizza", RestaurantID: 2},
+		{Name: "Soup", RestaurantID: 1},
+		{Name: "Cola", RestaurantID: 2},
+	}
+	groups := splitByRestaurant(lines)
+	if len(groups) != 2 {
+		t.Fatalf("%d groups, want 2", len(groups))
+	}
+	if len(groups[0]) != 2 || groups[0][0].Name != "Pizza" || groups[0][1].Name != "Cola" {
+		t.Errorf("first group = %+v, want Pizza and Cola", groups[0])
+	}
+	if len(groups[1]) != 1 || groups[1][0].Name != "Soup" {
+		t.Errorf("second group = %+v, want Soup", groups[1])
+	}
+	if _, err := orderRestaurant(lines); !errors.Is(err, errMixedRestaurants) {
+		t.Errorf("orderRestaurant error = %v, want errMixedRestaurants", err)
+	}
+}
+
+func createTestRestaurant(t *testing.T, name string) Restaurant {
+	t.Helper()
+	rest := Restaurant{Name: name, Slug: slugify(name)}
+	if err := db.Create(&rest).Error; err != nil {
+		t.Fatal(err)
+	}
+	return rest
+}
+
+func TestCheckoutSplitsOrdersByRestaurant(t *testing.T) {
+	openTestDB(t)
+	prev := cfg.Pricing
+	cfg.Pricing.FreeDeliveryOver = 0
+	t.Cleanup(func() { cfg.Pricing = prev })
+
+	pizzeria := createTestRestaurant(t, "Pizzeria")
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	pizza := createTestItem(t, FoodItem{Name: "Pizza", Price: 900, RestaurantID: pizzeria.ID})
+	user, cart := createTestCart(t)
+	for _, id := range []uint{soup.ID, pizza.ID} {
+		if err := addToCart(db, cart, id, 0, nil, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	checkoutCart(w, withTestUser(httptest.NewRequest(http.MethodPost, "/cart/checkout", strings.NewReader(`{"address": "Main St 1"}`)), user))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("checkout: status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Orders  []Order        `json:"orders"`
+		Pricing PriceBreakdown `json:"pricing"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Orders) != 2 {
+		t.Fatalf("%d orders, want one per restaurant", len(resp.Orders))
+	}
+	var total Money
+	for _, order := range resp.Orders {
+		var want uint
+		switch order.Lines[0].Name {
+		case "Soup":
+			want = soup.RestaurantID
+		case "Pizza":
+			want = pizzeria.ID
+		}
+		if len(order.Lines) != 1 || order.RestaurantID != want {
+			t.Errorf("order %d: restaurant %d with lines %+v, want only its own restaurant's line", order.ID, order.RestaurantID, order.Lines)
+		}
+		// Каждый ресторан везет свой заказ и берет свою доставку.
+		if order.DeliveryFee != cfg.Pricing.DeliveryFee {
+			t.Errorf("order %d: delivery fee %s, want %s", order.ID, order.DeliveryFee, cfg.Pricing.DeliveryFee)
+		}
+		total += order.Total
+	}
+	if resp.Pricing.Total != total || resp.Pricing.DeliveryFee != 2*cfg.Pricing.DeliveryFee {
+		t.Errorf("pricing = %+v, want the sum of both orders (%s)", resp.Pricing, total)
+	}
+}
+
+func TestCreateOrderRejectsMixedRestaurants(t *testing.T) {
+	openTestDB(t)
+	pizzeria := createTestRestaurant(t, "Pizzeria")
+	soup := createTestItem(t, FoodItem{Name: "Soup", Price: 500})
+	pizza := createTestItem(t, FoodItem{Name: "Pizza", Price: 900, RestaurantID: pizzeria.ID})
+	user, _ := createTestCart(t)
+
+	body := fmt.Sprintf(`{"address": "Main St 1", "lines": [{"food_item_id": %d, "quantity": 1}, {"food_item_id": %d, "quantity": 1}]}`, soup.ID, pizza.ID)
+	w := httptest.NewRecorder()
+	createOrder(w, withTestUser(httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body)), user))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400: %s", w.Code, w.Body)
+	}
+	var orders int64
+	db.Model(&Order{}).Where("user_id = ?", user.ID).Count(&orders)
+	if orders != 0 {
+		t.Errorf("%d orders created", orders)
+	}
+}
+
+func TestStaffSeesOnlyOwnRestaurantOrders(t *testing.T) {
+	openTestDB(t)
+	pizzeria := createTestRestaurant(t, "Pizzeria")
+	own, _ := createTestOrder(t, "", OrderLine{Name: "Soup", UnitPrice: 500, Quantity: 1, LineTotal: 500})
+	other, _ := createTestOrder(t, "", OrderLine{Name: "Pizza", UnitPrice: 900, Quantity: 1, LineTotal: 900})
+	if err := db.Model(other).Update("restaurant_id", pizzeria.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	staff := &User{Name: "Cook", Email: "cook@example.com", Role: roleStaff, RestaurantID: &own.RestaurantID}
+	if err := db.Create(staff).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Чужой ресторан нельзя выбрать и через ?restaurant_id=.
+	w := httptest.NewRecorder()
+	getAllOrders(w, withTestUser(httptest.NewRequest(http.MethodGet, fmt.Sprintf("/orders?restaurant_id=%d", pizzeria.ID), nil), staff))
+	var orders []Order
+	if err := json.NewDecoder(w.Body).Decode(&orders); err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].ID != own.ID {
+		t.Errorf("staff sees orders %+v, want only order %d", orders, own.ID)
+	}
+
+	accept := func(order *Order) int {
+		r := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/orders/%d/status", order.ID), strings.NewReader(`{"status": "accepted"}`))
+		r = mux.SetURLVars(r, map[string]string{"id": strconv.Itoa(int(order.ID))})
+		w := httptest.NewRecorder()
+		updateOrderStatus(w, withTestUser(r, staff))
+		return w.Code
+	}
+	if code := accept(other); code != http.StatusForbidden {
+		t.Errorf("other restaurant's order: status = %d, want 403", code)
+	}
+	var stored Order
+	if err := db.First(&stored, other.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != orderPlaced {
+		t.Errorf("other restaurant's order moved to %s", stored.Status)
+	}
+
+	prev := cfg.Payments.Required
+	cfg.Payments.Required = false
+	t.Cleanup(func() { cfg.Payments.Required = prev })
+	if code := accept(own); code != http.StatusOK {
+		t.Errorf("own order: status = %d, want 200", code)
+	}
+}
@@ -1,5 +1,8 @@
 let currentUser = null;
-let cart = [];
+let cart = []; // позиции серверной корзины
+let cartPricing = null; // расчет итога с сервера
+// Один ключ на попытку оформления: повторные клики и ретраи не создадут второй заказ.
+let checkoutIdempotencyKey = null;
 let allMenuItems = []; // ������ ��� �������� ����
 let recommendedItems = []; // ������ �������� ��� ������� "Recommended"
 let recommendedPage = 1; // ���������� ��� ������� ��������
@@ -27,6 +30,10 @@ async function checkAuthentication(retryCount = 0) {
             headers: { 'Authorization': `Bearer ${token}` },
         });
 
+        if (response.status === 401 && await refreshAuthToken()) {
+            return checkAuthentication(retryCount + 1);
+        }
+
         if (response.status === 429) { // Too Many Requests
             console.warn("Превышен лимит запросов. Повтор через 5 секунд...");
             setTimeout(() => checkAuthentication(retryCount + 1), 5000);
@@ -72,13 +79,98 @@ function updateAuthUI() {
     }
 }
 
-function handleLogout() {
+// Меняет refresh token на новый access token. Возвращает false, если сессия закрыта.
+async function refreshAuthToken() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) return false;
+
+    try {
+        const response = await fetch(`${SERVER_URL}/auth/refresh`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ refresh_token: refreshToken }),
+        });
+        if (!response.ok) {
+            localStorage.removeItem('refreshToken');
+            return false;
+        }
+        const data = await response.json();
+        localStorage.setItem('authToken', data.token);
+        localStorage.setItem('refreshToken', data.refresh_token);
+        return true;
+    } catch (error) {
+        console.error("Ошибка обновления токена:", error);
+        return false;
+    }
+}
+
+async function logoutOnServer() {
+    const token = localStorage.getItem('authToken');
+    if (token) {
+        try {
+            await fetch(`${SERVER_URL}/auth/logout`, {
+                method: 'POST',
+                headers: { 'Authorization': `Bearer ${token}` },
+            });
+        } catch (error) {
+            console.error("Ошибка выхода:", error);
+        }
+    }
+    localStorage.removeItem('authToken');
+    localStorage.removeItem('refreshToken');
+}
+
+async function handleLogout() {
+    await logoutOnServer();
     localStorage.removeItem('currentUser');
     currentUser = null;
     updateAuthUI();
+    updateCart();
     alert('You have been logged out.');
 }
-function updateCart() {
+// Запрос к API корзины. Гость идет без токена - его корзину сервер находит по
+// cookie, поэтому credentials: 'include'. При истекшем токене обновляет его и повторяет.
+async function cartRequest(path, options = {}) {
+    const send = () => {
+        const token = localStorage.getItem('authToken');
+        return fetch(`${SERVER_URL}${path}`, {
+            ...options,
+            credentials: 'include',
+            headers: {
+                'Content-Type': 'application/json',
+                ...(token ? { 'Authorization': `Bearer ${token}` } : {}),
+                ...(options.headers || {}),
+            },
+        });
+    };
+    let response = await send();
+    if (response.status === 401 && await refreshAuthToken()) {
+        response = await send();
+    }
+    return response;
+}
+
+// Корзина хранится на сервере: цены и итог приходят в ответе, клиент их не считает.
+async function updateCart() {
+    try {
+        const response = await cartRequest('/cart');
+        if (!response.ok) throw new Error('Failed to load cart.');
+        applyCartView(await response.json());
+    } catch (error) {
+        console.error('Error loading cart:', error);
+    }
+}
+
+function applyCartView(view) {
+    cart = view.items || [];
+    cartPricing = view.pricing;
+    renderCart();
+    if (view.removed && view.removed.length > 0) {
+        alert(`No longer available and removed from your cart: ${view.removed.join(', ')}`);
+    }
+}
+
+function renderCart() {
     const cartItems = document.getElementById('cartItems');
     const cartTotal = document.getElementById('cartTotal');
     const confirmOrderButton = document.getElementById('confirmOrderButton');
@@ -86,22 +178,27 @@ function updateCart() {
     if (!cartItems || !cartTotal || !confirmOrderButton) return;
 
     cartItems.innerHTML = '';
-    let total = 0;
-
     cart.forEach(item => {
         const cartItem = document.createElement('div');
         cartItem.classList.add('cart-item');
+        const priceNote = item.price_changed ? ` <small>(was $${item.previous_price.toFixed(2)})</small>` : '';
+        const optionsNote = (item.options || []).map(option => option.name).join(', ')
+            + (item.sold_out ? ' <strong>Sold out</strong>' : '')
+            + (item.unavailable ? ` <strong>${availableFromLabel(item)}</strong>` : '');
         cartItem.innerHTML = `
             <div class="cart-item-content">
                 <h4>${item.name}</h4>
-                <p>$${item.price.toFixed(2)}</p>
+                ${optionsNote ? `<small>${optionsNote}</small>` : ''}
+                <p>$${item.unit_price.toFixed(2)} × ${item.quantity} = $${item.line_total.toFixed(2)}${priceNote}</p>
             </div>
+            <button class="btn btn-secondary cart-qty-btn" data-id="${item.id}" data-qty="${item.quantity - 1}">−</button>
+            <button class="btn btn-secondary cart-qty-btn" data-id="${item.id}" data-qty="${item.quantity + 1}">+</button>
             <button class="btn btn-secondary remove-from-cart-btn" data-id="${item.id}">Remove</button>
         `;
         cartItems.appendChild(cartItem);
-        total += item.price;
     });
 
+    const total = cartPricing ? cartPricing.total : 0;
     cartTotal.textContent = `$${total.toFixed(2)}`;
     confirmOrderButton.disabled = cart.length === 0;
 
@@ -111,38 +208,130 @@ function updateCart() {
             removeFromCart(itemId);
         });
     });
+    document.querySelectorAll('.cart-qty-btn').forEach(button => {
+        button.addEventListener('click', e => {
+            const itemId = parseInt(e.target.dataset.id, 10);
+            setCartQuantity(itemId, parseInt(e.target.dataset.qty, 10));
+        });
+    });
 }
 
-function removeFromCart(itemId) {
-    cart = cart.filter(item => item.id !== itemId);
-    updateCart();
-    alert('Item removed from cart.');
+async function setCartQuantity(itemId, quantity) {
+    try {
+        const response = await cartRequest(`/cart/items/${itemId}`, {
+            method: 'PATCH',
+            body: JSON.stringify({ quantity }),
+        });
+        if (!response.ok) throw new Error(await response.text());
+        applyCartView(await response.json());
+    } catch (error) {
+        console.error('Error updating cart:', error);
+        alert('Failed to update cart.');
+    }
 }
 
-async function addToCart(itemId) {
-    if (!currentUser) {
-        alert('Please sign in to add items to your cart.');
-        return;
+async function removeFromCart(itemId) {
+    try {
+        const response = await cartRequest(`/cart/items/${itemId}`, { method: 'DELETE' });
+        if (!response.ok) throw new Error(await response.text());
+        applyCartView(await response.json());
+        alert('Item removed from cart.');
+    } catch (error) {
+        console.error('Error removing item from cart:', error);
     }
+}
 
-    try {
-        const response = await fetch(`http://localhost:8080/menu/${itemId}`);
-        if (!response.ok) throw new Error('Failed to fetch item.');
+// Время, с которого блюдо снова продается, в часовом поясе браузера.
+function availableFromLabel(item) {
+    if (!item.available_from) return 'Not available now';
+    const from = new Date(item.available_from);
+    const time = from.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' });
+    const sameDay = from.toDateString() === new Date().toDateString();
+    return `Available from ${sameDay ? time : `${from.toLocaleDateString([], { weekday: 'short' })} ${time}`}`;
+}
+
+// Кнопка "Add to Cart"; закончившееся или недоступное сейчас блюдо показывается,
+// но заказать его нельзя.
+function addToCartButton(item) {
+    if (item.sold_out) {
+        return `<button class="btn btn-secondary" disabled>Sold out</button>`;
+    }
+    if (item.available === false) {
+        return `<button class="btn btn-secondary" disabled>${availableFromLabel(item)}</button>`;
+    }
+    return `<button class="btn btn-primary add-to-cart-btn" data-id="${item.id}">Add to Cart</button>`;
+}
+
+// Цена блюда для карточки меню: у блюд с размерами - цена самого дешевого.
+function displayPrice(item) {
+    if (!item.variants || item.variants.length === 0) return `$${item.price.toFixed(2)}`;
+    const cheapest = Math.min(...item.variants.map(variant => variant.price));
+    return `from $${cheapest.toFixed(2)}`;
+}
+
+// Спрашивает размер блюда. Возвращает ID варианта, 0 для блюда без размеров или
+// null, если покупатель отказался.
+function chooseVariant(item) {
+    if (!item.variants || item.variants.length === 0) return 0;
+    const list = item.variants
+        .map((variant, i) => `${i + 1}. ${variant.name} - $${variant.price.toFixed(2)}`)
+        .join('\n');
+    const answer = prompt(`${item.name}: choose a size\n${list}`, '1');
+    if (answer === null) return null;
+    const variant = item.variants[parseInt(answer, 10) - 1];
+    return variant ? variant.id : null;
+}
+
+// Спрашивает выбор опций по группам блюда. Возвращает ID опций или null, если
+// покупатель отказался.
+function chooseOptions(item) {
+    const selected = [];
+    for (const group of item.option_groups || []) {
+        const list = group.options
+            .map((option, i) => `${i + 1}. ${option.name}${option.price > 0 ? ` (+$${option.price.toFixed(2)})` : ''}`)
+            .join('\n');
+        const rule = group.min_select > 0
+            ? `choose ${group.min_select === group.max_select ? group.min_select : `${group.min_select}-${group.max_select}`}`
+            : `optional, up to ${group.max_select}`;
+        const answer = prompt(`${item.name}: ${group.name} (${rule})\nEnter numbers separated by commas:\n${list}`, '');
+        if (answer === null) return null;
+        answer.split(',').map(part => parseInt(part, 10)).forEach(n => {
+            const option = group.options[n - 1];
+            if (option && !selected.includes(option.id)) selected.push(option.id);
+        });
+    }
+    return selected;
+}
 
-        const item = await response.json();
-        if (cart.some(cartItem => cartItem.id === item.id)) {
-            alert('Item is already in your cart.');
+async function addToCart(itemId) {
+    try {
+        const itemResponse = await fetch(`${SERVER_URL}/menu/${itemId}`);
+        if (!itemResponse.ok) throw new Error(await itemResponse.text());
+        const item = await itemResponse.json();
+        const variantId = chooseVariant(item);
+        if (variantId === null) return;
+        const options = chooseOptions(item);
+        if (options === null) return;
+
+        const response = await cartRequest('/cart/items', {
+            method: 'POST',
+            body: JSON.stringify({ food_item_id: itemId, variant_id: variantId, options, quantity: 1 }),
+        });
+        if (response.status === 400 || response.status === 409) {
+            alert(await response.text());
             return;
         }
+        if (!response.ok) throw new Error(await response.text());
 
-        cart.push(item);
-        updateCart();
-        alert(`${item.name} added to your cart!`);
+        applyCartView(await response.json());
+        const added = cart.find(cartItem => cartItem.food_item_id === itemId);
+        alert(`${added ? added.name : 'Item'} added to your cart!`);
     } catch (error) {
         console.error('Error adding item to cart:', error);
+        alert('Failed to add item to cart.');
     }
 }
-document.getElementById('confirmOrderButton').addEventListener('click', async () => {
+document.getElementById('confirmOrderButton').addEventListener('click', async (event) => {
     console.log('Confirm Order Button Clicked'); 
     if (!currentUser) {
         alert('Please sign in to place an order.');
@@ -163,34 +352,77 @@ document.getElementById('confirmOrderButton').addEventListener('click', async ()
         return;
     }
 
-    const orderData = {
-        customer: name,
-        email: currentUser.email,
-        phone: phone,
-        address: address,
-        total: cart.reduce((sum, item) => sum + item.price, 0),
-        food_items: cart.map(item => item.id),
-    };
+    if (!checkoutIdempotencyKey) {
+        checkoutIdempotencyKey = crypto.randomUUID();
+    }
+    event.target.disabled = true;
 
     try {
+        // Итог, который видел покупатель: если сервер посчитает иначе, заказ не создастся.
+        const orderData = {
+            customer: name,
+            address: address,
+            total: cartPricing.total,
+        };
+
         console.log('Sending order data:', orderData); 
-        const response = await fetch('http://localhost:8080/orders', {
+        const response = await cartRequest('/cart/checkout', {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
+            headers: { 'Idempotency-Key': checkoutIdempotencyKey },
             body: JSON.stringify(orderData),
         });
 
+        // 409 без JSON - заказ с этим ключом еще создается; с JSON - изменились цены или корзина.
+        const isJSON = (response.headers.get('Content-Type') || '').includes('application/json');
+        if (response.status === 409 && !isJSON) {
+            alert('Your order is already being placed. Please wait a moment.');
+            return;
+        }
+        if (response.status === 409 || response.status === 422) {
+            checkoutIdempotencyKey = null;
+            const body = isJSON ? await response.json() : {};
+            if (body.cart) {
+                applyCartView(body.cart);
+            } else {
+                await updateCart();
+            }
+            alert('Your cart has changed. Please review your order and try again.');
+            return;
+        }
         if (!response.ok) {
             throw new Error('Failed to place order.');
         }
 
-        alert('Order successfully placed!');
-        cart = [];
-        updateCart();
+        // Корзина из нескольких ресторанов оформляется несколькими заказами.
+        const { orders, pricing } = await response.json();
+        let paymentFailed = false;
+        for (const order of orders) {
+            // Демо-оплата через локальный fake-шлюз; настоящий шлюз выдал бы токен карты.
+            const paymentResponse = await fetch(`http://localhost:8080/orders/${order.id}/payments`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': `Bearer ${localStorage.getItem('authToken')}`,
+                    'Idempotency-Key': `${checkoutIdempotencyKey}-payment-${order.id}`,
+                },
+                body: JSON.stringify({ payment_token: 'tok_approved' }),
+            });
+            paymentFailed = paymentFailed || !paymentResponse.ok;
+        }
+        if (paymentFailed) {
+            alert('Order placed, but the payment failed. You can retry from your profile.');
+        }
+
+        const placed = orders.length > 1 ? `${orders.length} orders (one per restaurant)` : 'Order';
+        alert(`${placed} successfully placed! Total: $${pricing.total.toFixed(2)}`);
+        checkoutIdempotencyKey = null;
+        await updateCart();
         document.getElementById('checkoutModal').style.display = 'none';
     } catch (error) {
         console.error('Error placing order:', error);
         alert('Failed to place order.');
+    } finally {
+        event.target.disabled = false;
     }
 });
 
@@ -225,8 +457,8 @@ function paginateRecommended(items, currentPage, limit, totalItems) {
             <h4>${item.name}</h4>
             <p>${item.description}</p>
             <p>Category: ${item.category}</p>
-            <p>Price: $${item.price.toFixed(2)}</p>
-            <button class="btn btn-primary add-to-cart-btn" data-id="${item.id}">Add to Cart</button>
+            <p>Price: ${displayPrice(item)}</p>
+            ${addToCartButton(item)}
         `;
         recommendedGrid.appendChild(menuItem);
     });
@@ -248,7 +480,7 @@ function paginateRecommended(items, currentPage, limit, totalItems) {
 }
 async function loadMenuSections() {
     try {
-        const response = await fetch('http://localhost:8080/menu'); // ������ ������ ���� ��� ������
+        const response = await fetch('http://localhost:8080/menu?show_unavailable=true'); // ������ ������ ���� ��� ������
         if (!response.ok) throw new Error('Failed to fetch menu sections.');
 
         const data = await response.json();
@@ -284,7 +516,7 @@ document.querySelectorAll('.sort-button').forEach(button => {
 
 async function fetchMenu(params = {}) {
     try {
-        const query = new URLSearchParams(params).toString();
+        const query = new URLSearchParams({ show_unavailable: 'true', ...params }).toString();
         console.log(`Requesting menu with query: ${query}`);
 
         const response = await fetch(`http://localhost:8080/items?${query}`);
@@ -371,8 +603,8 @@ function populateMenuSections(items) {
                 <img src="${item.picture_url}" alt="${item.name}" class="menu-item-image">
                 <h4>${item.name}</h4>
                 <p>${item.description}</p>
-                <p>Price: $${item.price.toFixed(2)}</p>
-                <button class="btn btn-primary add-to-cart-btn" data-id="${item.id}">Add to Cart</button>
+                <p>Price: ${displayPrice(item)}</p>
+                ${addToCartButton(item)}
             `;
             sectionGrid.appendChild(menuItem);
         } else {
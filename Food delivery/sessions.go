@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// Session - серверная сессия, к которой привязан refresh token. Access-токены
+// несут ее ID в claim sid, поэтому отзыв сессии сразу закрывает и их.
+type Session struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"index;not null"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type sessionTokens struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken - в базе хранится только sha256 от непрозрачных токенов.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func issueSessionTokens(user *User, session *Session, refreshToken string) (*sessionTokens, error) {
+	accessToken, err := issueToken(&Claims{Email: user.Email, Purpose: purposeAccess, SessionID: session.ID}, accessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// startSession создает сессию после успешного входа.
+func startSession(user *User, r *http.Request) (*sessionTokens, error) {
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	session := Session{
+		UserID:    user.ID,
+		TokenHash: hashToken(refreshToken),
+		UserAgent: r.UserAgent(),
+		IP:        r.RemoteAddr,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return issueSessionTokens(user, &session, refreshToken)
+}
+
+func sessionActive(id uint) bool {
+	if id == 0 {
+		return false
+	}
+	var count int64
+	err := db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, time.Now()).
+		Count(&count).Error
+	return err == nil && count > 0
+}
+
+func revokeSession(id uint) error {
+	return db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+func revokeAllSessions(tx *gorm.DB, userID uint) error {
+	return tx.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// refreshSession меняет refresh token на новую пару токенов. Старый refresh token
+// после этого недействителен.
+func refreshSession(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil || input.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
+		return
+	}
+
+	var session Session
+	err := db.Where("token_hash = ?", hashToken(input.RefreshToken)).First(&session).Error
+	if err != nil {
+		handleError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		handleError(w, http.StatusUnauthorized, "Session expired", errSessionClosed)
+		return
+	}
+
+	var user User
+	if err := db.First(&user, session.UserID).Error; err != nil {
+		handleError(w, http.StatusUnauthorized, "User not found", err)
+		return
+	}
+
+	refreshToken, err := newOpaqueToken()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to refresh session", err)
+		return
+	}
+	// Условие на старый хеш защищает от двух одновременных refresh одним токеном.
+	result := db.Model(&Session{}).
+		Where("id = ? AND token_hash = ?", session.ID, session.TokenHash).
+		Updates(map[string]interface{}{
+			"token_hash": hashToken(refreshToken),
+			"expires_at": time.Now().Add(refreshTokenTTL),
+		})
+	if result.Error != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to refresh session", result.Error)
+		return
+	}
+	if result.RowsAffected == 0 {
+		handleError(w, http.StatusUnauthorized, "Invalid refresh token", nil)
+		return
+	}
+
+	tokens, err := issueSessionTokens(&user, &session, refreshToken)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to refresh session", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// issueStreamToken - POST /auth/stream-token: короткоживущий токен для ?stream_token=
+// у EventSource и WebSocket. Привязан к той же сессии, что и access token.
+func issueStreamToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := claimsFromRequest(r)
+	if err != nil {
+		handleError(w, http.StatusUnauthorized, "Unauthorized", err)
+		return
+	}
+	token, err := issueToken(&Claims{Email: claims.Email, Purpose: purposeStream, SessionID: claims.SessionID}, streamTokenTTL)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to issue stream token", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stream_token": token,
+		"expires_in":   int(streamTokenTTL.Seconds()),
+	})
+}
+
+// logout закрывает текущую сессию.
+func logout(w http.ResponseWriter, r *http.Request) {
+	claims, err := claimsFromRequest(r)
+	if err != nil {
+		handleError(w, http.StatusUnauthorized, "Unauthorized", err)
+		return
+	}
+	if err := revokeSession(claims.SessionID); err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to log out", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutAll закрывает все сессии пользователя ("выйти на всех устройствах").
+func logoutAll(w http.ResponseWriter, r *http.Request) {
+	user, _ := userFromContext(r.Context())
+	if err := revokeAllSessions(db, user.ID); err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to log out", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func revokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var user User
+	if err := db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to fetch user", http.StatusInternalServerError)
+		return
+	}
+	if err := revokeAllSessions(db, user.ID); err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to revoke sessions", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createTestSession(t *testing.T) (*User, *sessionTokens) {
+	t.Helper()
+	user := User{Name: "Eve", Email: "eve-" + newKeyID() + "@example.com", Role: roleCustomer}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := startSession(&user, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &user, tokens
+}
+
+func refreshWith(refreshToken string) *httptest.ResponseRecorder {
+	body := `{"refresh_token": "` + refreshToken + `"}`
+	w := httptest.NewRecorder()
+	refreshSession(w, httptest.NewRequest(http.MethodPost, "/auth/refresh", strings.NewReader(body)))
+	return w
+}
+
+func accessStatus(accessToken string) int {
+	h := authenticated(func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	r.Header.Set("Authorization", "Bearer "+accessToken)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	openTestDB(t)
+	useTestKeys(t)
+	_, tokens := createTestSession(t)
+
+	w := refreshWith(tokens.RefreshToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status = %d: %s", w.Code, w.Body)
+	}
+	var rotated sessionTokens
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil {
+		t.Fatal(err)
+	}
+	if rotated.RefreshToken == "" || rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refresh token was not rotated: %q", rotated.RefreshToken)
+	}
+	if code := accessStatus(rotated.AccessToken); code != http.StatusOK {
+		t.Errorf("new access token: status = %d, want 200", code)
+	}
+
+	// Старый refresh token больше не действует, новый - действует.
+	if w := refreshWith(tokens.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: status = %d, want 401", w.Code)
+	}
+	if w := refreshWith(rotated.RefreshToken); w.Code != http.StatusOK {
+		t.Errorf("rotated refresh token: status = %d, want 200", w.Code)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	openTestDB(t)
+	useTestKeys(t)
+	_, tokens := createTestSession(t)
+	if code := accessStatus(tokens.AccessToken); code != http.StatusOK {
+		t.Fatalf("before logout: status = %d, want 200", code)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	r.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	w := httptest.NewRecorder()
+	logout(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout: status = %d", w.Code)
+	}
+
+	// Access-токен еще не истек, но его сессия закрыта.
+	if code := accessStatus(tokens.AccessToken); code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: status = %d, want 401", code)
+	}
+	if w := refreshWith(tokens.RefreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after logout: status = %d, want 401", w.Code)
+	}
+}
+
+func TestLogoutAllRevokesOnlyOwnSessions(t *testing.T) {
+	openTestDB(t)
+	useTestKeys(t)
+	user, phone := createTestSession(t)
+	laptop, err := startSession(user, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other := createTestSession(t)
+
+	w := httptest.NewRecorder()
+	logoutAll(w, withTestUser(httptest.NewRequest(http.MethodPost, "/auth/logout/all", nil), user))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout-all: status = %d", w.Code)
+	}
+	for name, tokens := range map[string]*sessionTokens{"phone": phone, "laptop": laptop} {
+		if code := accessStatus(tokens.AccessToken); code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, code)
+		}
+	}
+	if code := accessStatus(other.AccessToken); code != http.StatusOK {
+		t.Errorf("another user's session: status = %d, want 200", code)
+	}
+}
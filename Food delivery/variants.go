@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+const (
+	maxVariantName  = 50
+	maxSKULength    = 64
+	maxItemVariants = 20
+	// effectivePriceSQL - цена "от": самый дешевый вариант или цена блюда без вариантов.
+	effectivePriceSQL = "COALESCE((SELECT MIN(v.price) FROM item_variants v WHERE v.food_item_id = food_items.id), food_items.price)"
+)
+
+// ItemVariant - вариант блюда (размер) со своей ценой и артикулом. Если у блюда
+// есть варианты, его Price не используется: покупатель выбирает вариант.
+type ItemVariant struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	FoodItemID uint   `json:"food_item_id" gorm:"index;not null"`
+	Name       string `json:"name" gorm:"not null"`
+	SKU        string `json:"sku" gorm:"uniqueIndex;not null"`
+	Price      Money  `json:"price" gorm:"not null"`
+	Position   int    `json:"position" gorm:"not null"`
+}
+
+var (
+	errInvalidVariant = errors.New("invalid variant")
+	errDuplicateSKU   = errors.New("sku is already used by another variant")
+)
+
+// withMenuDetails подгружает варианты и модификаторы блюд в порядке показа.
+func withMenuDetails(q *gorm.DB) *gorm.DB {
+	return withOptionGroups(q).
+		Preload("Variants", func(tx *gorm.DB) *gorm.DB { return tx.Order("position, id") })
+}
+
+// resolveVariant возвращает выбранный вариант блюда (варианты должны быть подгружены)
+// и базовую цену позиции. У блюда без вариантов variantID должен быть 0.
+func resolveVariant(item *FoodItem, variantID uint) (*ItemVariant, Money, error) {
+	if len(item.Variants) == 0 {
+		if variantID != 0 {
+			return nil, 0, fmt.Errorf("%w: %q has no variants", errInvalidVariant, item.Name)
+		}
+		return nil, item.Price, nil
+	}
+	if variantID == 0 {
+		return nil, 0, fmt.Errorf("%w: choose a variant of %q", errInvalidVariant, item.Name)
+	}
+	for i := range item.Variants {
+		if item.Variants[i].ID == variantID {
+			return &item.Variants[i], item.Variants[i].Price, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: variant %d does not belong to %q", errInvalidVariant, variantID, item.Name)
+}
+
+// lineName - название позиции заказа или корзины: блюдо и размер.
+func lineName(item *FoodItem, variant *ItemVariant) string {
+	if variant == nil {
+		return item.Name
+	}
+	return item.Name + " (" + variant.Name + ")"
+}
+
+// validateVariants проверяет список вариантов блюда перед сохранением.
+func validateVariants(variants []ItemVariant) error {
+	if len(variants) > maxItemVariants {
+		return fmt.Errorf("item can have at most %d variants", maxItemVariants)
+	}
+	skus := make(map[string]bool, len(variants))
+	for i := range variants {
+		variant := &variants[i]
+		variant.Name = strings.TrimSpace(variant.Name)
+		variant.SKU = strings.TrimSpace(variant.SKU)
+		switch {
+		case variant.Name == "" || utf8.RuneCountInString(variant.Name) > maxVariantName:
+			return fmt.Errorf("variant name must be 1 to %d characters", maxVariantName)
+		case variant.SKU == "" || len(variant.SKU) > maxSKULength:
+			return fmt.Errorf("variant sku must be 1 to %d characters", maxSKULength)
+		case skus[variant.SKU]:
+			return fmt.Errorf("sku %q is used twice", variant.SKU)
+		case variant.Price < 0:
+			return errors.New("variant price must be non-negative")
+		}
+		skus[variant.SKU] = true
+	}
+	return nil
+}
+
+// putItemVariants - PUT /menu/{id}/variants: заменяет варианты блюда. Варианты с id
+// обновляются, без id - добавляются, не переданные - удаляются; пустой список
+// убирает варианты, и блюдо снова продается по своей цене. Позиции корзин с
+// удаленными вариантами пропадут при следующем просмотре корзины.
+func putItemVariants(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+	var input []ItemVariant
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+	if err := validateVariants(input); err != nil {
+		handleError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	var item FoodItem
+	if err := db.Preload("Variants").First(&item, itemID).Error; err != nil {
+		http.Error(w, "Menu item not found", http.StatusNotFound)
+		return
+	}
+	existing := make(map[uint]bool, len(item.Variants))
+	for _, variant := range item.Variants {
+		existing[variant.ID] = true
+	}
+	keep := []uint{}
+	for i := range input {
+		variant := &input[i]
+		if variant.ID != 0 && !existing[variant.ID] {
+			http.Error(w, fmt.Sprintf("Variant %d does not belong to this item", variant.ID), http.StatusBadRequest)
+			return
+		}
+		variant.FoodItemID = item.ID
+		if variant.ID != 0 {
+			keep = append(keep, variant.ID)
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		remove := tx.Where("food_item_id = ?", item.ID)
+		if len(keep) > 0 {
+			remove = remove.Where("id NOT IN ?", keep)
+		}
+		if err := remove.Delete(&ItemVariant{}).Error; err != nil {
+			return err
+		}
+		for i := range input {
+			if err := tx.Save(&input[i]).Error; err != nil {
+				return err
+			}
+		}
+		return bumpFoodItemVersion(tx, item.ID)
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		handleError(w, http.StatusConflict, errDuplicateSKU.Error(), err)
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, "Failed to update variants", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(input)
+}
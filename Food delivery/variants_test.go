@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolveVariant(t *testing.T) {
+	plain := &FoodItem{Name: "Soup", Price: 450}
+	pizza := &FoodItem{Name: "Pizza", Price: 900, Variants: []ItemVariant{
+		{ID: 7, Name: "Small", SKU: "PZ-S", Price: 900},
+		{ID: 8, Name: "Large", SKU: "PZ-L", Price: 1400},
+	}}
+	tests := []struct {
+		name      string
+		item      *FoodItem
+		variantID uint
+		wantPrice Money
+		wantName  string
+		wantErr   bool
+	}{
+		{"item without variants", plain, 0, 450, "Soup", false},
+		{"variant price replaces item price", pizza, 8, 1400, "Pizza (Large)", false},
+		{"variant required", pizza, 0, 0, "", true},
+		{"variant of another item", pizza, 3, 0, "", true},
+		{"variant on plain item", plain, 7, 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			variant, price, err := resolveVariant(tt.item, tt.variantID)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidVariant) {
+					t.Fatalf("error = %v, want errInvalidVariant", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if price != tt.wantPrice {
+				t.Errorf("price = %d, want %d", price, tt.wantPrice)
+			}
+			if name := lineName(tt.item, variant); name != tt.wantName {
+				t.Errorf("lineName = %q, want %q", name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestValidateVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		variants []ItemVariant
+		wantErr  bool
+	}{
+		{"valid", []ItemVariant{{Name: "Small", SKU: "S", Price: 100}, {Name: "Large", SKU: "L", Price: 200}}, false},
+		{"duplicate sku", []ItemVariant{{Name: "Small", SKU: "S"}, {Name: "Large", SKU: " S "}}, true},
+		{"missing sku", []ItemVariant{{Name: "Small"}}, true},
+		{"missing name", []ItemVariant{{SKU: "S"}}, true},
+		{"negative price", []ItemVariant{{Name: "Small", SKU: "S", Price: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateVariants(tt.variants); (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}